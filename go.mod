@@ -2,14 +2,14 @@ module github.com/xfy520/m3u8_cli
 
 go 1.17
 
-require github.com/urfave/cli/v2 v2.3.0
-
 require (
-	github.com/andybalholm/brotli v1.0.4 // indirect
-	github.com/robertkrimen/otto v0.0.0-20211024170158-b87d35c0b86f // indirect
-	gopkg.in/sourcemap.v1 v1.0.5 // indirect
+	github.com/andybalholm/brotli v1.0.4
+	github.com/robertkrimen/otto v0.0.0-20211024170158-b87d35c0b86f
+	github.com/urfave/cli/v2 v2.3.0
 )
 
+require gopkg.in/sourcemap.v1 v1.0.5 // indirect
+
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.0-20190314233015-f79a8a8ca69d // indirect
 	github.com/flytam/filenamify v1.0.0
@@ -8,49 +8,73 @@ import (
 	"path"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/urfave/cli/v2"
 
+	"github.com/xfy520/m3u8_cli/package/decrypt"
 	"github.com/xfy520/m3u8_cli/package/download/downloadManager"
 	"github.com/xfy520/m3u8_cli/package/ffmpeg"
 	"github.com/xfy520/m3u8_cli/package/lang"
+	"github.com/xfy520/m3u8_cli/package/live"
 	"github.com/xfy520/m3u8_cli/package/log"
 	"github.com/xfy520/m3u8_cli/package/parser"
 	"github.com/xfy520/m3u8_cli/package/request"
+	"github.com/xfy520/m3u8_cli/package/schedule"
 	"github.com/xfy520/m3u8_cli/package/tool"
 )
 
 var (
-	VERSION         string   = "1.0.0"
-	BUILD_TIME      string   = "nil"
-	GO_VERSION      string   = "1.17.1"
-	DEV             string   = "1"
-	maxThreads      int      = runtime.NumCPU()
-	inputRetryCount int      = 30
-	url             string   = ""
-	minThreads      int      = 16
-	retryCount      int      = 15
-	timeOut         int      = 10
-	baseUrl         string   = ""
-	reqHeaders      string   = ""
-	keyFile         string   = ""
-	keyBase64       string   = ""
-	keyIV           string   = ""
-	muxSetJson      string   = "MUXSETS.json"
-	muxFastStart    bool     = false
-	delAfterDone    bool     = false
-	parseOnly       bool     = false
-	noMerge         bool     = false
-	fileName        string   = ""
-	workDir         string   = ""
-	Args            []string = []string{}
+	VERSION               string   = "1.0.0"
+	BUILD_TIME            string   = "nil"
+	GO_VERSION            string   = "1.17.1"
+	DEV                   string   = "1"
+	maxThreads            int      = runtime.NumCPU()
+	inputRetryCount       int      = 30
+	url                   string   = ""
+	minThreads            int      = 16
+	retryCount            int      = 15
+	timeOut               int      = 10
+	baseUrl               string   = ""
+	reqHeaders            string   = ""
+	keyFile               string   = ""
+	keyBase64             string   = ""
+	keyIV                 string   = ""
+	keys                  []string = []string{}
+	selectVideo           string   = ""
+	selectAudio           string   = ""
+	selectSubtitle        string   = ""
+	muxSetJson            string   = "MUXSETS.json"
+	muxFastStart          bool     = false
+	delAfterDone          bool     = false
+	binaryMerge           bool     = false
+	parseOnly             bool     = false
+	noMerge               bool     = false
+	writeDate             bool     = true
+	disableIntegrityCheck bool     = false
+	coalesceRanges        bool     = false
+	preciseTrim           bool     = false
+	liveRecDur            float64  = 0
+	liveSplitDur          float64  = 0
+	liveSplitSize         int64    = 0
+	liveSplitClock        float64  = 0
+	liveRetention         int      = 0
+	liveMaxConns          int      = 0
+	liveRate              int      = 0
+	liveStartFrom         string   = ""
+	fileName              string   = ""
+	workDir               string   = ""
+	Args                  []string = []string{}
 )
 
+// 命令行指定的分片选择与广告处理设置，每次解析时复制给解析器
+var parseOptions = parser.NewParseOptions()
+
 func main() {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	log.DEV = DEV
 	signal.Notify(c, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGILL)
 	go func() {
@@ -58,19 +82,19 @@ func main() {
 			switch s {
 			case syscall.SIGHUP:
 				fmt.Println("终端控制进程结束(终端连接断开)", s)
-				tool.Exit()
+				tool.Exit(0)
 			case syscall.SIGINT:
 				fmt.Println("用户发送INTR字符(Ctrl+C)触发", s)
-				tool.Exit()
+				tool.Exit(0)
 			case syscall.SIGTERM:
 				fmt.Println("结束程序(可以被捕获、阻塞或忽略)", s)
-				tool.Exit()
+				tool.Exit(0)
 			case syscall.SIGQUIT:
 				fmt.Println("用户发送QUIT字符(Ctrl+/)触发", s)
-				tool.Exit()
+				tool.Exit(0)
 			case syscall.SIGILL:
 				fmt.Println("非法指令(程序错误、试图执行数据段、栈溢出等)", s)
-				tool.Exit()
+				tool.Exit(0)
 			default:
 				fmt.Println("其他错误退出，不作处理，继续执行程序", s)
 			}
@@ -158,6 +182,41 @@ func main() {
 				Aliases: []string{"uki"},
 				Usage:   lang.Lang.UseKeyIV,
 			},
+			&cli.StringSliceFlag{
+				Name:  "key",
+				Usage: lang.Lang.Key,
+			},
+			&cli.StringFlag{
+				Name:    "selectVideo",
+				Aliases: []string{"sv", "select-video"},
+				Usage:   lang.Lang.SelectVideo,
+			},
+			&cli.StringFlag{
+				Name:    "selectAudio",
+				Aliases: []string{"sa", "select-audio"},
+				Usage:   lang.Lang.SelectAudio,
+			},
+			&cli.StringFlag{
+				Name:    "selectSubtitle",
+				Aliases: []string{"sst", "select-subtitle"},
+				Usage:   lang.Lang.SelectSubtitle,
+			},
+			&cli.StringFlag{
+				Name:    "adPolicy",
+				Aliases: []string{"ap", "ad-policy"},
+				Value:   parser.AdPolicyKeep,
+				Usage:   lang.Lang.AdPolicy,
+			},
+			&cli.BoolFlag{
+				Name:    "enableClassifyParts",
+				Aliases: []string{"ecp", "classify-parts"},
+				Usage:   lang.Lang.ClassifyParts,
+			},
+			&cli.StringFlag{
+				Name:    "bumperDurations",
+				Aliases: []string{"bd", "bumper-durations"},
+				Usage:   lang.Lang.BumperDurations,
+			},
 			&cli.StringFlag{
 				Name:    "downloadRange",
 				Aliases: []string{"dr"},
@@ -168,6 +227,51 @@ func main() {
 				Aliases: []string{"ld"},
 				Usage:   lang.Lang.LiveRecDur,
 			},
+			&cli.StringFlag{
+				Name:    "liveStartFrom",
+				Aliases: []string{"lsf", "live-start-from"},
+				Usage:   lang.Lang.LiveStartFrom,
+			},
+			&cli.StringFlag{
+				Name:    "liveSplitDur",
+				Aliases: []string{"lsd"},
+				Usage:   lang.Lang.LiveSplitDur,
+			},
+			&cli.IntFlag{
+				Name:    "liveSplitSize",
+				Aliases: []string{"lss"},
+				Usage:   lang.Lang.LiveSplitSize,
+			},
+			&cli.StringFlag{
+				Name:    "liveSplitClock",
+				Aliases: []string{"lsc"},
+				Usage:   lang.Lang.LiveSplitClock,
+			},
+			&cli.IntFlag{
+				Name:    "liveRetention",
+				Aliases: []string{"lrt"},
+				Usage:   lang.Lang.LiveRetention,
+			},
+			&cli.BoolFlag{
+				Name:    "enableChannelList",
+				Aliases: []string{"ecl"},
+				Usage:   lang.Lang.EnableChannelList,
+			},
+			&cli.BoolFlag{
+				Name:    "enableSchedule",
+				Aliases: []string{"esc"},
+				Usage:   lang.Lang.EnableSchedule,
+			},
+			&cli.IntFlag{
+				Name:    "liveMaxConns",
+				Aliases: []string{"lmc"},
+				Usage:   lang.Lang.LiveMaxConns,
+			},
+			&cli.IntFlag{
+				Name:    "liveRate",
+				Aliases: []string{"lr"},
+				Usage:   lang.Lang.LiveRate,
+			},
 			&cli.IntFlag{
 				Name:        "stopSpeed",
 				Aliases:     []string{"ss"},
@@ -230,6 +334,16 @@ func main() {
 				Aliases: []string{"dic"},
 				Usage:   lang.Lang.DisableIntegrityCheck,
 			},
+			&cli.BoolFlag{
+				Name:    "enableCoalesceRanges",
+				Aliases: []string{"ecr"},
+				Usage:   lang.Lang.EnableCoalesceRanges,
+			},
+			&cli.BoolFlag{
+				Name:    "enablePreciseTrim",
+				Aliases: []string{"ept"},
+				Usage:   lang.Lang.EnablePreciseTrim,
+			},
 		},
 	}
 	args, err := tool.GetArgs(os.Args, 1)
@@ -272,10 +386,10 @@ func run(c *cli.Context) error {
 
 	parseOnly = c.Bool("enableParseOnly")
 
-	BinaryMerge := c.Bool("enableBinaryMerge")
-	fmt.Println(BinaryMerge)
-	WriteDate := !c.Bool("disableDateInfo")
-	fmt.Println(WriteDate)
+	binaryMerge = c.Bool("enableBinaryMerge")
+	fmt.Println(binaryMerge)
+	writeDate = !c.Bool("disableDateInfo")
+	fmt.Println(writeDate)
 	noMerge = c.Bool("noMerge")
 	fmt.Println(noMerge)
 
@@ -291,12 +405,11 @@ func run(c *cli.Context) error {
 
 	muxFastStart = c.Bool("enableMuxFastStart")
 	fmt.Println(muxFastStart)
-	DisableIntegrityCheck := c.Bool("disableIntegrityCheck")
-	fmt.Println(DisableIntegrityCheck)
-	if c.Bool("enableAudioOnly") {
-		VIDEO_TYPE := "IGNORE"
-		fmt.Println(VIDEO_TYPE)
-	}
+	disableIntegrityCheck = c.Bool("disableIntegrityCheck")
+	fmt.Println(disableIntegrityCheck)
+	coalesceRanges = c.Bool("enableCoalesceRanges")
+	preciseTrim = c.Bool("enablePreciseTrim")
+	parseOptions.AudioOnly = c.Bool("enableAudioOnly")
 	muxSetJson = c.String("muxSetJson")
 	fmt.Println(muxSetJson)
 
@@ -324,6 +437,40 @@ func run(c *cli.Context) error {
 		keyIV = c.String("useKeyIV")
 	}
 
+	if len(c.StringSlice("key")) > 0 {
+		keys = c.StringSlice("key")
+	}
+
+	if c.String("selectVideo") != "" {
+		selectVideo = c.String("selectVideo")
+	}
+
+	if c.String("selectAudio") != "" {
+		selectAudio = c.String("selectAudio")
+	}
+
+	if c.String("selectSubtitle") != "" {
+		selectSubtitle = c.String("selectSubtitle")
+	}
+
+	if !parser.IsAdPolicy(c.String("adPolicy")) {
+		return errors.New(lang.Lang.InvalidAdPolicy + c.String("adPolicy"))
+	}
+	parseOptions.AdPolicy = c.String("adPolicy")
+
+	if c.Bool("enableClassifyParts") {
+		parseOptions.ClassifyParts = true
+	}
+
+	if c.String("bumperDurations") != "" {
+		durations, err := parser.ParseBumperDurations(c.String("bumperDurations"))
+		if err != nil {
+			return errors.New(lang.Lang.InvalidBumperDurations + err.Error())
+		}
+		parseOptions.BumperDurations = durations
+		parseOptions.ClassifyParts = true
+	}
+
 	if c.Int("stopSpeed") != -999 {
 		STOP_SPEED := c.Int("stopSpeed")
 		fmt.Println(STOP_SPEED)
@@ -338,58 +485,103 @@ func run(c *cli.Context) error {
 		baseUrl = c.String("baseUrl")
 	}
 
-	maxThreads = c.Int("maxThreads")
+	if c.Int("maxThreads") > 0 {
+		maxThreads = c.Int("maxThreads")
+	}
 	fmt.Println(maxThreads)
 
-	minThreads = c.Int("minThreads")
+	if c.Int("minThreads") > 0 {
+		minThreads = c.Int("minThreads")
+	}
 	fmt.Println(minThreads)
 
-	retryCount = c.Int("retryCount")
+	if c.IsSet("retryCount") {
+		retryCount = c.Int("retryCount")
+	}
 	fmt.Println(retryCount)
 
-	timeOut = c.Int("timeOut")
+	if c.Int("timeOut") > 0 {
+		timeOut = c.Int("timeOut")
+	}
 	fmt.Println(timeOut)
 
 	if c.String("liveRecDur") != "" {
-		reg := regexp.MustCompile(`(\d+):(\d+):(\d+)`)
-		liveRecDur := c.String("liveRecDur")
-		params := reg.FindStringSubmatch(liveRecDur)
-		for _, param := range params {
-			fmt.Println(param)
+		seconds, ok := parseClockDuration(c.String("liveRecDur"))
+		if !ok {
+			return errors.New(lang.Lang.InvalidLiveRecDur + c.String("liveRecDur"))
+		}
+		liveRecDur = seconds
+	}
+	if c.String("liveStartFrom") != "" {
+		if _, ok := parser.ParseStartFrom(c.String("liveStartFrom"), time.Now()); !ok {
+			return errors.New(lang.Lang.InvalidLiveStartFrom + c.String("liveStartFrom"))
+		}
+		liveStartFrom = c.String("liveStartFrom")
+	}
+	if c.String("liveSplitDur") != "" {
+		seconds, ok := parseClockDuration(c.String("liveSplitDur"))
+		if !ok || seconds == 0 {
+			return errors.New(lang.Lang.InvalidLiveSplit + c.String("liveSplitDur"))
+		}
+		liveSplitDur = seconds
+	}
+	if c.Int("liveSplitSize") > 0 {
+		liveSplitSize = int64(c.Int("liveSplitSize")) * 1024 * 1024
+	}
+	if c.String("liveSplitClock") != "" {
+		seconds, ok := parseClockDuration(c.String("liveSplitClock"))
+		if !ok || seconds == 0 {
+			return errors.New(lang.Lang.InvalidLiveSplit + c.String("liveSplitClock"))
 		}
-		// int HH = Convert.ToInt32(reg2.Match(t).Groups[1].Value)
-		// int MM = Convert.ToInt32(reg2.Match(t).Groups[2].Value)
-		// int SS = Convert.ToInt32(reg2.Match(t).Groups[3].Value)
-		// HLSLiveDownloader.REC_DUR_LIMIT = SS + MM * 60 + HH * 60 * 60
+		liveSplitClock = seconds
+	}
+	if c.Int("liveRetention") > 0 {
+		liveRetention = c.Int("liveRetention")
+	}
+	if c.Int("liveMaxConns") > 0 {
+		liveMaxConns = c.Int("liveMaxConns")
+	}
+	if c.Int("liveRate") > 0 {
+		liveRate = c.Int("liveRate")
 	}
 	if c.String("downloadRange") != "" {
 		downloadRange := c.String("downloadRange")
-		if strings.Contains(downloadRange, ":") {
-			reg := regexp.MustCompile(`((\d+):(\d+):(\d+))?-((\d+):(\d+):(\d+))?`)
-			params := reg.FindStringSubmatch(downloadRange)
-			for _, param := range params {
-				fmt.Println(param)
+		if start, end, ok := parser.ParseDateRange(downloadRange); ok { //按绝对时间选择
+			parseOptions.DateStart = start
+			parseOptions.DateEnd = end
+			parseOptions.DelAd = false
+		} else if strings.Contains(downloadRange, ":") { //按时长选择，多个范围以逗号分隔
+			ranges, err := parser.ParseTimeRanges(downloadRange)
+			if err != nil {
+				return errors.New(lang.Lang.InvalidDownloadRange + downloadRange)
+			}
+			parseOptions.TimeRanges = ranges
+			parseOptions.DelAd = false
+		} else { //按分片序号选择
+			reg := regexp.MustCompile(`^(\d*)-(\d*)$`)
+			params := reg.FindStringSubmatch(strings.TrimSpace(downloadRange))
+			if params == nil || (params[1] == "" && params[2] == "") {
+				return errors.New(lang.Lang.InvalidDownloadRange + downloadRange)
+			}
+			if params[1] != "" {
+				parseOptions.RangeStart, _ = strconv.ParseInt(params[1], 10, 64)
+				parseOptions.DelAd = false
+			}
+			if params[2] != "" {
+				parseOptions.RangeEnd, _ = strconv.ParseInt(params[2], 10, 64)
+				parseOptions.DelAd = false
+			}
+			if parseOptions.RangeEnd != -1 && parseOptions.RangeEnd < parseOptions.RangeStart {
+				return errors.New(lang.Lang.InvalidDownloadRange + downloadRange)
 			}
-			// Parser.DurStart = reg2.Match(p).Groups[1].Value;
-			// Parser.DurEnd = reg2.Match(p).Groups[5].Value;
-			// if (Parser.DurEnd == "00:00:00") Parser.DurEnd = "";
-			// Parser.DelAd = false;
-		} else {
-			reg := regexp.MustCompile(`(\d*)-(\d*)`)
-			params := reg.FindStringSubmatch(downloadRange)
-			fmt.Println(params)
-			// if (!string.IsNullOrEmpty(reg.Match(p).Groups[1].Value))
-			// {
-			//     Parser.RangeStart = Convert.ToInt32(reg.Match(p).Groups[1].Value);
-			//     Parser.DelAd = false;
-			// }
-			// if (!string.IsNullOrEmpty(reg.Match(p).Groups[2].Value))
-			// {
-			//     Parser.RangeEnd = Convert.ToInt32(reg.Match(p).Groups[2].Value);
-			//     Parser.DelAd = false;
-			// }
 		}
 	}
+	if c.Bool("enableChannelList") { //输入为频道列表文件
+		return recordChannels(CurrentPath)
+	}
+	if c.Bool("enableSchedule") { //输入为计划文件
+		return recordSchedule(CurrentPath)
+	}
 	return input(CurrentPath)
 }
 
@@ -400,7 +592,7 @@ func input(CurrentPath string) error {
 	}
 
 	if strings.Contains(url, "twitcasting") && strings.Contains(url, "/fmp4/") {
-		downloadManager.BinaryMerge = true
+		binaryMerge = true
 	}
 
 	// m3u8Content := ""
@@ -423,18 +615,50 @@ func input(CurrentPath string) error {
 	m3u8Parser.DownName = fileName
 	m3u8Parser.DownDir = path.Join(workDir, fileName)
 	m3u8Parser.M3u8Url = url
+	startTime := liveStartTime()
+	if !startTime.IsZero() {
+		if playbackUrl, ok := parser.SetPlaybackStart(url, startTime); ok { //回看地址由服务器从开始时间返回分片
+			log.Info(lang.Lang.LivePlaybackStart + startTime.Format("2006-01-02 15:04:05"))
+			m3u8Parser.M3u8Url = playbackUrl
+			startTime = time.Time{}
+		}
+	}
 	m3u8Parser.KeyBase64 = keyBase64
 	m3u8Parser.KeyIV = keyIV
 	m3u8Parser.KeyFile = keyFile
+	if selectVideo != "" {
+		selector, err := parser.ParseVariantSelector(selectVideo)
+		if err != nil {
+			return errors.New(lang.Lang.InvalidSelector + err.Error())
+		}
+		m3u8Parser.VideoSelector = selector
+	}
+	if selectAudio != "" {
+		selector, err := parser.ParseRenditionSelector(selectAudio)
+		if err != nil {
+			return errors.New(lang.Lang.InvalidSelector + err.Error())
+		}
+		m3u8Parser.AudioSelector = selector
+	}
+	if selectSubtitle != "" {
+		selector, err := parser.ParseRenditionSelector(selectSubtitle)
+		if err != nil {
+			return errors.New(lang.Lang.InvalidSelector + err.Error())
+		}
+		m3u8Parser.SubtitleSelector = selector
+	}
 	if baseUrl != "" {
 		m3u8Parser.BaseUrl = baseUrl
 	}
 	m3u8Parser.Headers = reqHeaders
+	m3u8Parser.ParseOptions = parseOptions
 	log.LogFile = path.Join(CurrentPath, "Logs", time.Now().Format("2006-01-02_15-04-05.000")+".log")
 	if err := log.InitLog(url + " " + strings.Join(append(Args[:0], Args[1:]...), " ")); err != nil {
 		return err
 	}
-	tool.Check(log.WriteInfo(lang.Lang.StartParsing + url))
+	if err := log.WriteInfo(lang.Lang.StartParsing + url); err != nil {
+		return err
+	}
 	log.Warn(lang.Lang.StartParsing + url)
 	if strings.HasSuffix(url, ".json") && tool.Exists(url) { //可直接跳过解析
 		if !tool.Exists(path.Join(workDir, fileName)) { //若文件夹不存在则新建文件夹
@@ -445,14 +669,188 @@ func input(CurrentPath string) error {
 		if err := tool.CopyFile(url, path.Join(workDir, fileName, "meta.json")); err != nil {
 			return err
 		}
-	} else {
-		m3u8Parser.M3u8Parse() //开始解析
+	} else if err := m3u8Parser.M3u8Parse(); err != nil { //开始解析
+		log.WriteError(err.Error())
+		return err
+	}
+
+	if lowLatency := m3u8Parser.IsLowLatency(); lowLatency || m3u8Parser.IsLive() { //直播，录制完成后按本地列表重新解析
+		message := tool.IfString(lowLatency, lang.Lang.LowLatencyLive, lang.Lang.LiveStreamRecording)
+		log.Warn(message)
+		log.WriteInfo(message)
+		recorder, record := live.NewRecorder(lowLatency)
+		recorder.M3u8Url = m3u8Parser.M3u8Url
+		recorder.Headers = reqHeaders
+		recorder.DownDir = path.Join(workDir, fileName)
+		recorder.TimeOut = time.Duration(timeOut)
+		recorder.RetryCount = retryCount
+		recorder.Duration = liveRecDur
+		recorder.Variables = m3u8Parser.Variables()
+		recorder.RollDuration = liveSplitDur
+		recorder.RollSize = liveSplitSize
+		recorder.RollInterval = time.Duration(liveSplitClock * float64(time.Second))
+		recorder.StartTime = startTime
+		recorder.OnRoll = downloadLiveChunk
+		localUrl, err := record()
+		if err != nil {
+			log.WriteError(err.Error())
+			return err
+		}
+		if localUrl == "" { //切分录制时各分段已单独合并
+			return nil
+		}
+		m3u8Parser.M3u8Url = localUrl
+		m3u8Parser.BaseUrl = ""
+		if err := m3u8Parser.M3u8Parse(); err != nil {
+			log.WriteError(err.Error())
+			return err
+		}
 	}
 
 	if parseOnly { //仅解析模式
 		log.Info(lang.Lang.ParseExit)
-		tool.Pause()
+		return nil
+	}
+
+	return download(path.Join(workDir, fileName), reqHeaders, "")
+}
+
+// 下载meta.json中的分片并合并，logFile为空时写入全局日志
+func download(downDir string, headers string, logFile string) error {
+	manager := downloadManager.NewDownloadManager()
+	manager.DownDir = downDir
+	manager.Headers = headers
+	manager.Threads = maxThreads
+	manager.RetryCount = retryCount
+	manager.TimeOut = time.Duration(timeOut)
+	manager.NoMerge = noMerge
+	manager.MuxFastStart = muxFastStart
+	manager.WriteDate = writeDate
+	manager.DelAfterDone = delAfterDone
+	manager.BinaryMerge = binaryMerge
+	manager.LogFile = logFile
+	manager.DisableIntegrityCheck = disableIntegrityCheck
+	manager.CoalesceRanges = coalesceRanges
+	manager.PreciseTrim = preciseTrim
+	userKeys, err := decrypt.ParseKeyPairs(keys)
+	if err != nil {
+		return errors.New(lang.Lang.InvalidKey + err.Error())
+	}
+	manager.Keys = userKeys
+	return manager.DoDownload()
+}
+
+// 切分录制的分段以开始时间命名，按本地列表解析后单独合并，并删除过期的分段
+func downloadLiveChunk(localUrl string, start time.Time) error {
+	if err := downloadLocal(localUrl, path.Join(workDir, live.ChunkName(fileName, start)), reqHeaders, ""); err != nil {
+		return err
 	}
+	return live.RemoveExpiredChunks(workDir, fileName, time.Duration(liveRetention)*time.Hour)
+}
 
-	return nil
+// 按录制生成的本地m3u8解析后合并到downDir，同时录制多个频道时logFile为频道的日志文件
+func downloadLocal(localUrl string, downDir string, headers string, logFile string) error {
+	m3u8Parser := parser.NewM3u8Parser()
+	m3u8Parser.DownName = path.Base(downDir)
+	m3u8Parser.DownDir = downDir
+	m3u8Parser.M3u8Url = localUrl
+	m3u8Parser.KeyBase64 = keyBase64
+	m3u8Parser.KeyIV = keyIV
+	m3u8Parser.KeyFile = keyFile
+	m3u8Parser.Headers = headers
+	m3u8Parser.LiveStream = true
+	m3u8Parser.ParseOptions = parseOptions
+	m3u8Parser.LogFile = logFile
+	if err := m3u8Parser.M3u8Parse(); err != nil {
+		return err
+	}
+	return download(downDir, headers, logFile)
+}
+
+// 同时录制频道列表文件中的全部频道，各频道的日志单独保存
+func recordChannels(CurrentPath string) error {
+	channels, err := live.LoadChannels(url)
+	if err != nil {
+		return errors.New(lang.Lang.InvalidChannelList + err.Error())
+	}
+	if err := initLog(CurrentPath); err != nil {
+		return err
+	}
+	log.Info(fmt.Sprintf(lang.Lang.RecordingChannels, len(channels)))
+	log.WriteInfo(fmt.Sprintf(lang.Lang.RecordingChannels, len(channels)))
+	return channelRecorders(CurrentPath)(channels).Record()
+}
+
+// 常驻运行，按计划文件中的时间段录制直播，各计划共享连接限制
+func recordSchedule(CurrentPath string) error {
+	if _, err := schedule.LoadEntries(url); err != nil {
+		return errors.New(lang.Lang.InvalidSchedule + err.Error())
+	}
+	if err := initLog(CurrentPath); err != nil {
+		return err
+	}
+	newRecorder := channelRecorders(CurrentPath)
+	scheduler := schedule.NewScheduler()
+	scheduler.File = url
+	scheduler.NewRecorder = func(entry *schedule.Entry, name string) schedule.Recorder {
+		channel := entry.Channel
+		channel.Name = name
+		return newRecorder([]*live.Channel{&channel})
+	}
+	return scheduler.Run(make(chan struct{}))
+}
+
+// 按命令行参数创建多频道录制器，创建的录制器共享同一个连接限制
+func channelRecorders(CurrentPath string) func(channels []*live.Channel) schedule.Recorder {
+	maxConns := liveMaxConns
+	if maxConns == 0 {
+		maxConns = maxThreads
+	}
+	limiter := live.NewLimiter(maxConns, float64(liveRate))
+	return func(channels []*live.Channel) schedule.Recorder {
+		recorder := live.NewMultiRecorder()
+		recorder.Channels = channels
+		recorder.DownDir = workDir
+		recorder.LogDir = path.Join(CurrentPath, "Logs")
+		recorder.Headers = reqHeaders
+		recorder.TimeOut = time.Duration(timeOut)
+		recorder.RetryCount = retryCount
+		recorder.Duration = liveRecDur
+		recorder.RollDuration = liveSplitDur
+		recorder.RollSize = liveSplitSize
+		recorder.RollInterval = time.Duration(liveSplitClock * float64(time.Second))
+		recorder.Retention = time.Duration(liveRetention) * time.Hour
+		recorder.StartTime = liveStartTime()
+		recorder.Limiter = limiter
+		recorder.OnFinish = downloadLocal
+		return recorder
+	}
+}
+
+// --liveStartFrom 对应的开始时间，相对时间以调用时的当前时间计算，未指定时为零值
+func liveStartTime() time.Time {
+	if liveStartFrom == "" {
+		return time.Time{}
+	}
+	start, _ := parser.ParseStartFrom(liveStartFrom, time.Now())
+	return start
+}
+
+// 初始化本次运行的日志文件
+func initLog(CurrentPath string) error {
+	log.LogFile = path.Join(CurrentPath, "Logs", time.Now().Format("2006-01-02_15-04-05.000")+".log")
+	return log.InitLog(url + " " + strings.Join(append(Args[:0], Args[1:]...), " "))
+}
+
+// 解析HH:MM:SS格式的时长，返回秒数
+func parseClockDuration(value string) (float64, bool) {
+	reg := regexp.MustCompile(`^(\d+):([0-5]?\d):([0-5]?\d)$`)
+	params := reg.FindStringSubmatch(strings.TrimSpace(value))
+	if params == nil {
+		return 0, false
+	}
+	hh, _ := strconv.Atoi(params[1])
+	mm, _ := strconv.Atoi(params[2])
+	ss, _ := strconv.Atoi(params[3])
+	return float64(ss + mm*60 + hh*60*60), true
 }
@@ -0,0 +1,147 @@
+package decrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	ErrBlockSize = errors.New("ciphertext is not a multiple of the block size")
+	ErrIVLength  = errors.New("iv must not exceed 16 bytes")
+)
+
+// 解析十六进制IV，不足16字节时左侧补0
+func ParseIV(iv string) ([]byte, error) {
+	iv = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(iv), "0x"), "0X")
+	if len(iv)%2 == 1 {
+		iv = "0" + iv
+	}
+	value, err := hex.DecodeString(iv)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > aes.BlockSize {
+		return nil, ErrIVLength
+	}
+	return append(make([]byte, aes.BlockSize-len(value)), value...), nil
+}
+
+// 去除PKCS7填充，填充不合法时原样返回
+func PKCS7Unpad(data []byte) []byte {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return data
+	}
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return data
+	}
+	if !bytes.Equal(data[len(data)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return data
+	}
+	return data[:len(data)-padding]
+}
+
+// AES-128-CBC解密并去除PKCS7填充
+func AES128CBC(data []byte, key []byte, iv []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%aes.BlockSize != 0 {
+		return nil, ErrBlockSize
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	return PKCS7Unpad(out), nil
+}
+
+// 边读边解密的AES-128-CBC流，最后一个分组留到读完后再去除填充
+type aesReader struct {
+	src    io.Reader
+	mode   cipher.BlockMode
+	buf    []byte
+	out    []byte
+	chunk  []byte
+	err    error
+	closed bool
+}
+
+// 包装AES-128-CBC加密的数据流
+func NewAES128Reader(r io.Reader, key []byte, iv []byte) (io.Reader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, ErrIVLength
+	}
+	return &aesReader{
+		src:   r,
+		mode:  cipher.NewCBCDecrypter(block, iv),
+		chunk: make([]byte, 32*1024),
+	}, nil
+}
+
+func (r *aesReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.closed {
+			return 0, r.err
+		}
+		n, err := r.src.Read(r.chunk)
+		r.buf = append(r.buf, r.chunk[:n]...)
+		if err == io.EOF {
+			r.closed = true
+			r.err = io.EOF
+			if len(r.buf)%aes.BlockSize != 0 {
+				r.err = ErrBlockSize
+				r.buf = r.buf[:len(r.buf)/aes.BlockSize*aes.BlockSize]
+			}
+			r.mode.CryptBlocks(r.buf, r.buf)
+			r.out = PKCS7Unpad(r.buf)
+			r.buf = nil
+			continue
+		}
+		if err != nil {
+			r.closed = true
+			r.err = err
+			continue
+		}
+		// 保留最后一个完整分组，等待判断是否为结尾
+		size := len(r.buf) / aes.BlockSize * aes.BlockSize
+		if size == len(r.buf) {
+			size -= aes.BlockSize
+		}
+		if size > 0 {
+			r.out = make([]byte, size)
+			r.mode.CryptBlocks(r.out, r.buf[:size])
+			r.buf = append(r.buf[:0], r.buf[size:]...)
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+var ErrKeyFormat = errors.New("key must be KID:KEY or KEY in hex")
+
+// 解析 --key 参数，格式为 KID:KEY 或仅 KEY (十六进制)，仅KEY时以空字符串作为KID
+func ParseKeyPairs(values []string) (map[string][]byte, error) {
+	keys := map[string][]byte{}
+	for _, value := range values {
+		kid, key := "", strings.TrimSpace(value)
+		if index := strings.Index(key, ":"); index != -1 {
+			kid, key = strings.ToLower(strings.ReplaceAll(key[:index], "-", "")), key[index+1:]
+		}
+		byts, err := hex.DecodeString(key)
+		if err != nil || len(byts) != aes.BlockSize {
+			return nil, ErrKeyFormat
+		}
+		keys[kid] = byts
+	}
+	return keys, nil
+}
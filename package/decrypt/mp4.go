@@ -0,0 +1,413 @@
+package decrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+
+	"github.com/xfy520/m3u8_cli/package/mp4"
+)
+
+var (
+	ErrNoKey         = errors.New("no key for kid")
+	ErrUnknownScheme = errors.New("unsupported protection scheme")
+	ErrSampleRange   = errors.New("sample out of range")
+	ErrTruncatedBox  = errors.New("truncated box")
+)
+
+// 轨道加密信息(sinf/schm/tenc)
+type TrackEncryption struct {
+	TrackID        uint32
+	Scheme         string
+	KID            string
+	IVSize         int
+	ConstantIV     []byte
+	CryptByteBlock int
+	SkipByteBlock  int
+}
+
+// 读取初始化分段中每个轨道的加密信息
+func ReadTrackEncryption(init []byte) map[uint32]*TrackEncryption {
+	tracks := map[uint32]*TrackEncryption{}
+	moov, ok := mp4.Find(init, "moov")
+	if !ok {
+		return tracks
+	}
+	for _, trak := range mp4.Children(init, moov) {
+		if trak.Type != "trak" {
+			continue
+		}
+		trackID := readTrackID(init, trak)
+		for _, entry := range sampleEntries(init, trak) {
+			sinf, ok := findSinf(init, entry)
+			if !ok {
+				continue
+			}
+			info := &TrackEncryption{TrackID: trackID}
+			if schm, ok := mp4.Child(init, sinf, "schm"); ok && len(schm.Body(init)) >= 8 {
+				info.Scheme = string(schm.Body(init)[4:8])
+			}
+			if schi, ok := mp4.Child(init, sinf, "schi"); ok {
+				if tenc, ok := mp4.Child(init, schi, "tenc"); ok {
+					readTenc(tenc.Body(init), info)
+				}
+			}
+			tracks[trackID] = info
+		}
+	}
+	return tracks
+}
+
+// 将初始化分段改写为未加密形式：还原原始编码类型并屏蔽sinf与pssh
+func ClearInit(init []byte) {
+	moov, ok := mp4.Find(init, "moov")
+	if !ok {
+		return
+	}
+	for _, box := range mp4.Children(init, moov) {
+		if box.Type == "pssh" {
+			mp4.Rename(init, box, "free")
+		}
+		if box.Type != "trak" {
+			continue
+		}
+		for _, entry := range sampleEntries(init, box) {
+			sinf, ok := findSinf(init, entry)
+			if !ok {
+				continue
+			}
+			if frma, ok := mp4.Child(init, sinf, "frma"); ok && len(frma.Body(init)) >= 4 {
+				mp4.Rename(init, entry, string(frma.Body(init)[:4]))
+			}
+			mp4.Rename(init, sinf, "free")
+		}
+	}
+}
+
+func readTrackID(data []byte, trak mp4.Box) uint32 {
+	tkhd, ok := mp4.Child(data, trak, "tkhd")
+	if !ok {
+		return 0
+	}
+	body := tkhd.Body(data)
+	if len(body) > 0 && body[0] == 1 && len(body) >= 24 {
+		return binary.BigEndian.Uint32(body[20:])
+	}
+	if len(body) >= 16 {
+		return binary.BigEndian.Uint32(body[12:])
+	}
+	return 0
+}
+
+// stsd中的样本描述
+func sampleEntries(data []byte, trak mp4.Box) []mp4.Box {
+	box := trak
+	for _, boxType := range []string{"mdia", "minf", "stbl", "stsd"} {
+		child, ok := mp4.Child(data, box, boxType)
+		if !ok {
+			return nil
+		}
+		box = child
+	}
+	return mp4.ReadBoxes(data, box.Start()+8, box.End())
+}
+
+// 样本描述的子box位于固定字段之后
+func findSinf(data []byte, entry mp4.Box) (mp4.Box, bool) {
+	skip := 78
+	if entry.Type == "enca" {
+		skip = 28
+	}
+	if entry.Type != "encv" && entry.Type != "enca" {
+		return mp4.Box{}, false
+	}
+	for _, box := range mp4.ReadBoxes(data, entry.Start()+skip, entry.End()) {
+		if box.Type == "sinf" {
+			return box, true
+		}
+	}
+	return mp4.Box{}, false
+}
+
+func readTenc(body []byte, info *TrackEncryption) {
+	if len(body) < 24 {
+		return
+	}
+	if body[0] > 0 {
+		info.CryptByteBlock = int(body[5] >> 4)
+		info.SkipByteBlock = int(body[5] & 0x0f)
+	}
+	info.IVSize = int(body[7])
+	info.KID = hex.EncodeToString(body[8:24])
+	if body[6] == 1 && info.IVSize == 0 && len(body) >= 25 {
+		size := int(body[24])
+		if len(body) >= 25+size {
+			info.ConstantIV = append([]byte{}, body[25:25+size]...)
+		}
+	}
+}
+
+type subsample struct {
+	clear     int
+	protected int
+}
+
+type sampleAux struct {
+	iv         []byte
+	subsamples []subsample
+}
+
+// 解密fMP4分段(moof+mdat)，keyFunc根据KID返回对应的KEY
+func DecryptMP4(data []byte, tracks map[uint32]*TrackEncryption, keyFunc func(kid string) []byte) error {
+	if moov, ok := mp4.Find(data, "moov"); ok {
+		for id, info := range ReadTrackEncryption(data[:moov.End()]) {
+			tracks[id] = info
+		}
+		ClearInit(data[:moov.End()])
+	}
+	for _, moof := range mp4.ReadBoxes(data, 0, len(data)) {
+		if moof.Type != "moof" {
+			continue
+		}
+		for _, traf := range mp4.Children(data, moof) {
+			if traf.Type != "traf" {
+				continue
+			}
+			if err := decryptTraf(data, moof, traf, tracks, keyFunc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// moof之后的第一个mdat，分段中可能有多组moof+mdat
+func nextMdat(data []byte, moof mp4.Box) (mp4.Box, bool) {
+	for _, box := range mp4.ReadBoxes(data, moof.End(), len(data)) {
+		if box.Type == "mdat" {
+			return box, true
+		}
+	}
+	return mp4.Box{}, false
+}
+
+func decryptTraf(data []byte, moof mp4.Box, traf mp4.Box, tracks map[uint32]*TrackEncryption, keyFunc func(kid string) []byte) error {
+	tfhd, ok := mp4.Child(data, traf, "tfhd")
+	if !ok {
+		return nil
+	}
+	body := tfhd.Body(data)
+	if len(body) < 8 {
+		return nil
+	}
+	flags := binary.BigEndian.Uint32(body) & 0xffffff
+	info, ok := tracks[binary.BigEndian.Uint32(body[4:])]
+	if !ok {
+		return nil
+	}
+	base := int64(moof.Offset)
+	pos := 8
+	if flags&0x01 != 0 {
+		if len(body) < pos+8 {
+			return ErrTruncatedBox
+		}
+		base = int64(binary.BigEndian.Uint64(body[pos:]))
+		pos += 8
+	}
+	if flags&0x02 != 0 {
+		pos += 4
+	}
+	if flags&0x08 != 0 {
+		pos += 4
+	}
+	defaultSize := 0
+	if flags&0x10 != 0 && len(body) >= pos+4 {
+		defaultSize = int(binary.BigEndian.Uint32(body[pos:]))
+	}
+
+	samples := readSenc(data, traf, info)
+	key := keyFunc(info.KID)
+	if key == nil {
+		return ErrNoKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	index := 0
+	next := int64(-1)
+	for _, trun := range mp4.Children(data, traf) {
+		if trun.Type != "trun" {
+			continue
+		}
+		body := trun.Body(data)
+		if len(body) < 8 {
+			return ErrTruncatedBox
+		}
+		flags := binary.BigEndian.Uint32(body) & 0xffffff
+		count := int(binary.BigEndian.Uint32(body[4:]))
+		pos := 8
+		offset := next
+		if flags&0x01 != 0 {
+			value, err := readUint32(body, pos)
+			if err != nil {
+				return err
+			}
+			offset = base + int64(int32(value))
+			pos += 4
+		}
+		if offset < 0 { //未指定data_offset时样本从当前moof之后的mdat开始
+			if mdat, ok := nextMdat(data, moof); ok {
+				offset = int64(mdat.Start())
+			}
+		}
+		if flags&0x04 != 0 {
+			pos += 4
+		}
+		for i := 0; i < count; i++ {
+			size := defaultSize
+			if flags&0x100 != 0 {
+				pos += 4
+			}
+			if flags&0x200 != 0 {
+				value, err := readUint32(body, pos)
+				if err != nil {
+					return err
+				}
+				size = int(value)
+				pos += 4
+			}
+			if flags&0x400 != 0 {
+				pos += 4
+			}
+			if flags&0x800 != 0 {
+				pos += 4
+			}
+			if pos > len(body) {
+				return ErrTruncatedBox
+			}
+			if offset < 0 || offset > int64(len(data)) || int64(size) > int64(len(data))-offset {
+				return ErrSampleRange
+			}
+			if index < len(samples) {
+				sample := data[offset : int(offset)+size]
+				if err := decryptSample(sample, block, info, samples[index]); err != nil {
+					return err
+				}
+			}
+			offset += int64(size)
+			index++
+		}
+		next = offset
+	}
+	for _, box := range mp4.Children(data, traf) {
+		if box.Type == "senc" || box.Type == "saiz" || box.Type == "saio" {
+			mp4.Rename(data, box, "free")
+		}
+	}
+	return nil
+}
+
+// 读取pos处的4字节整数，长度不足时返回ErrTruncatedBox，避免不完整的分段导致越界
+func readUint32(body []byte, pos int) (uint32, error) {
+	if pos < 0 || len(body) < pos+4 {
+		return 0, ErrTruncatedBox
+	}
+	return binary.BigEndian.Uint32(body[pos:]), nil
+}
+
+func readSenc(data []byte, traf mp4.Box, info *TrackEncryption) []sampleAux {
+	senc, ok := mp4.Child(data, traf, "senc")
+	if !ok {
+		return nil
+	}
+	body := senc.Body(data)
+	if len(body) < 8 {
+		return nil
+	}
+	flags := binary.BigEndian.Uint32(body) & 0xffffff
+	count := int(binary.BigEndian.Uint32(body[4:]))
+	// 样本数来自文件内容，不足时按实际长度分配
+	if count > len(body) {
+		count = len(body)
+	}
+	samples := make([]sampleAux, 0, count)
+	pos := 8
+	for i := 0; i < count && pos <= len(body); i++ {
+		aux := sampleAux{iv: info.ConstantIV}
+		if info.IVSize > 0 && pos+info.IVSize <= len(body) {
+			aux.iv = body[pos : pos+info.IVSize]
+			pos += info.IVSize
+		}
+		if flags&0x02 != 0 && pos+2 <= len(body) {
+			n := int(binary.BigEndian.Uint16(body[pos:]))
+			pos += 2
+			for j := 0; j < n && pos+6 <= len(body); j++ {
+				aux.subsamples = append(aux.subsamples, subsample{
+					clear:     int(binary.BigEndian.Uint16(body[pos:])),
+					protected: int(binary.BigEndian.Uint32(body[pos+2:])),
+				})
+				pos += 6
+			}
+		}
+		samples = append(samples, aux)
+	}
+	return samples
+}
+
+// 解密单个样本，cenc为AES-CTR，cbcs为按模式加密的AES-CBC
+func decryptSample(sample []byte, block cipher.Block, info *TrackEncryption, aux sampleAux) error {
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, aux.iv)
+	subsamples := aux.subsamples
+	if len(subsamples) == 0 {
+		subsamples = []subsample{{clear: 0, protected: len(sample)}}
+	}
+	switch info.Scheme {
+	case "cenc", "":
+		stream := cipher.NewCTR(block, iv)
+		pos := 0
+		for _, sub := range subsamples {
+			pos += sub.clear
+			if pos+sub.protected > len(sample) {
+				return ErrSampleRange
+			}
+			stream.XORKeyStream(sample[pos:pos+sub.protected], sample[pos:pos+sub.protected])
+			pos += sub.protected
+		}
+	case "cbcs":
+		pos := 0
+		for _, sub := range subsamples {
+			pos += sub.clear
+			if pos+sub.protected > len(sample) {
+				return ErrSampleRange
+			}
+			decryptPattern(sample[pos:pos+sub.protected], block, iv, info.CryptByteBlock, info.SkipByteBlock)
+			pos += sub.protected
+		}
+	default:
+		return ErrUnknownScheme
+	}
+	return nil
+}
+
+// 按crypt:skip模式解密，每个子样本重新使用IV，不足一个分组的尾部不加密
+func decryptPattern(data []byte, block cipher.Block, iv []byte, crypt int, skip int) {
+	mode := cipher.NewCBCDecrypter(block, iv)
+	blocks := len(data) / aes.BlockSize
+	if crypt == 0 && skip == 0 {
+		crypt = blocks
+	}
+	pos := 0
+	for blocks > 0 {
+		n := crypt
+		if n > blocks {
+			n = blocks
+		}
+		mode.CryptBlocks(data[pos:pos+n*aes.BlockSize], data[pos:pos+n*aes.BlockSize])
+		pos += (n + skip) * aes.BlockSize
+		blocks -= n + skip
+	}
+}
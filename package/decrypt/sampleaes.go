@@ -0,0 +1,467 @@
+package decrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+)
+
+const tsPacketSize = 188
+
+var ErrNotTS = errors.New("not a mpeg-ts stream")
+
+// SAMPLE-AES加密流类型与对应的明文流类型
+var sampleAESStreamTypes = map[byte]byte{
+	0xdb: 0x1b, // H.264
+	0xcf: 0x0f, // AAC ADTS
+	0xc1: 0x81, // AC-3
+	0xc2: 0x87, // E-AC-3
+}
+
+// 判断数据是否为MPEG-TS
+func IsTS(data []byte) bool {
+	return len(data) >= tsPacketSize && data[0] == 0x47 && (len(data) < 2*tsPacketSize || data[tsPacketSize] == 0x47)
+}
+
+// 一个PES包在输出中的位置
+type pesSlot struct {
+	pid        uint16
+	streamType byte
+	packets    [][]byte
+	payload    []byte
+}
+
+// 解密Apple SAMPLE-AES加密的MPEG-TS分片，返回重新封装后的TS
+func DecryptSampleAESTS(data []byte, key []byte, iv []byte) ([]byte, error) {
+	if !IsTS(data) {
+		return nil, ErrNotTS
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		pmtPid  = -1
+		streams = map[uint16]byte{}
+		current = map[uint16]*pesSlot{}
+		out     [][]byte
+		order   []interface{}
+	)
+	finish := func(slot *pesSlot) error {
+		decrypted, err := decryptPES(slot.payload, slot.streamType, block, iv)
+		if err != nil {
+			return err
+		}
+		slot.packets = packetizePES(slot.pid, decrypted, slot.packets[0])
+		return nil
+	}
+	for pos := 0; pos+tsPacketSize <= len(data); pos += tsPacketSize {
+		packet := append([]byte{}, data[pos:pos+tsPacketSize]...)
+		if packet[0] != 0x47 {
+			return nil, ErrNotTS
+		}
+		pusi := packet[1]&0x40 != 0
+		pid := uint16(packet[1]&0x1f)<<8 | uint16(packet[2])
+		payload := tsPayload(packet)
+		switch {
+		case pid == 0 && pusi:
+			pmtPid = readPAT(payload)
+		case int(pid) == pmtPid && pusi:
+			for k, v := range readPMT(payload) {
+				streams[k] = v
+			}
+			rewritePMT(packet)
+		}
+		streamType, ok := streams[pid]
+		if _, encrypted := sampleAESStreamTypes[streamType]; !ok || !encrypted {
+			order = append(order, packet)
+			continue
+		}
+		if pusi {
+			if slot := current[pid]; slot != nil {
+				if err := finish(slot); err != nil {
+					return nil, err
+				}
+			}
+			slot := &pesSlot{pid: pid, streamType: streamType, packets: [][]byte{packet}}
+			slot.payload = append(slot.payload, payload...)
+			current[pid] = slot
+			order = append(order, slot)
+			continue
+		}
+		// 分片开头不完整的PES无法解密，直接丢弃
+		if slot := current[pid]; slot != nil {
+			slot.payload = append(slot.payload, payload...)
+		}
+	}
+	for _, slot := range current {
+		if err := finish(slot); err != nil {
+			return nil, err
+		}
+	}
+	// 重新编号加密流的连续计数器
+	counters := map[uint16]byte{}
+	for _, item := range order {
+		switch v := item.(type) {
+		case []byte:
+			out = append(out, v)
+		case *pesSlot:
+			for _, packet := range v.packets {
+				packet[3] = packet[3]&0xf0 | counters[v.pid]&0x0f
+				counters[v.pid]++
+				out = append(out, packet)
+			}
+		}
+	}
+	return bytes.Join(out, nil), nil
+}
+
+// TS包负载
+func tsPayload(packet []byte) []byte {
+	afc := (packet[3] >> 4) & 0x03
+	pos := 4
+	if afc == 2 {
+		return nil
+	}
+	if afc == 3 {
+		pos += 1 + int(packet[4])
+	}
+	if pos >= tsPacketSize {
+		return nil
+	}
+	return packet[pos:]
+}
+
+func readPAT(payload []byte) int {
+	if len(payload) < 1 {
+		return -1
+	}
+	section := payload[1+int(payload[0]):]
+	if len(section) < 8 {
+		return -1
+	}
+	length := int(section[1]&0x0f)<<8 | int(section[2])
+	for i := 8; i+4 <= 3+length-4 && i+4 <= len(section); i += 4 {
+		program := int(section[i])<<8 | int(section[i+1])
+		if program != 0 {
+			return int(section[i+2]&0x1f)<<8 | int(section[i+3])
+		}
+	}
+	return -1
+}
+
+func readPMT(payload []byte) map[uint16]byte {
+	streams := map[uint16]byte{}
+	if len(payload) < 1 {
+		return streams
+	}
+	section := payload[1+int(payload[0]):]
+	if len(section) < 12 {
+		return streams
+	}
+	length := int(section[1]&0x0f)<<8 | int(section[2])
+	end := 3 + length - 4
+	if end > len(section) {
+		end = len(section)
+	}
+	pos := 12 + (int(section[10]&0x0f)<<8 | int(section[11]))
+	for pos+5 <= end {
+		pid := uint16(section[pos+1]&0x1f)<<8 | uint16(section[pos+2])
+		streams[pid] = section[pos]
+		pos += 5 + (int(section[pos+3]&0x0f)<<8 | int(section[pos+4]))
+	}
+	return streams
+}
+
+// 将PMT中的SAMPLE-AES流类型改为明文类型，并重新计算CRC
+func rewritePMT(packet []byte) {
+	payload := tsPayload(packet)
+	if len(payload) < 1 {
+		return
+	}
+	section := payload[1+int(payload[0]):]
+	if len(section) < 12 {
+		return
+	}
+	length := int(section[1]&0x0f)<<8 | int(section[2])
+	end := 3 + length - 4
+	if end+4 > len(section) {
+		return
+	}
+	pos := 12 + (int(section[10]&0x0f)<<8 | int(section[11]))
+	for pos+5 <= end {
+		if clear, ok := sampleAESStreamTypes[section[pos]]; ok {
+			section[pos] = clear
+		}
+		pos += 5 + (int(section[pos+3]&0x0f)<<8 | int(section[pos+4]))
+	}
+	crc := crc32MPEG(section[:end])
+	section[end] = byte(crc >> 24)
+	section[end+1] = byte(crc >> 16)
+	section[end+2] = byte(crc >> 8)
+	section[end+3] = byte(crc)
+}
+
+func crc32MPEG(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// 解密PES负载中的基本流
+func decryptPES(pes []byte, streamType byte, block cipher.Block, iv []byte) ([]byte, error) {
+	if len(pes) < 9 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 {
+		return pes, nil
+	}
+	headerLength := 9 + int(pes[8])
+	if headerLength > len(pes) {
+		return pes, nil
+	}
+	es := append([]byte{}, pes[headerLength:]...)
+	switch streamType {
+	case 0xdb:
+		es = decryptH264(es, block, iv)
+	case 0xcf:
+		decryptADTS(es, block, iv)
+	case 0xc1, 0xc2:
+		decryptAC3(es, block, iv)
+	}
+	header := append([]byte{}, pes[:headerLength]...)
+	// 视频长度可能变化，超出范围时置0
+	length := len(header) - 6 + len(es)
+	if length > 0xffff || (header[4] == 0 && header[5] == 0) {
+		length = 0
+	}
+	header[4] = byte(length >> 8)
+	header[5] = byte(length)
+	return append(header, es...), nil
+}
+
+// H.264: 类型1和5且长度大于48的NAL单元，前32字节为明文，之后每160字节加密首个16字节分组
+func decryptH264(es []byte, block cipher.Block, iv []byte) []byte {
+	out := make([]byte, 0, len(es))
+	starts := nalStarts(es)
+	if len(starts) == 0 {
+		return es
+	}
+	out = append(out, es[:starts[0][0]]...)
+	for i, start := range starts {
+		end := len(es)
+		if i+1 < len(starts) {
+			end = starts[i+1][0]
+		}
+		out = append(out, es[start[0]:start[1]]...)
+		nal := es[start[1]:end]
+		nalType := byte(0)
+		if len(nal) > 0 {
+			nalType = nal[0] & 0x1f
+		}
+		if (nalType == 1 || nalType == 5) && len(nal) > 48 {
+			nal = removeEmulationPrevention(nal)
+			mode := cipher.NewCBCDecrypter(block, iv)
+			for pos := 32; pos < len(nal); pos += 160 {
+				if len(nal)-pos > aes.BlockSize {
+					mode.CryptBlocks(nal[pos:pos+aes.BlockSize], nal[pos:pos+aes.BlockSize])
+				}
+			}
+			// 解密后的数据中可能出现起始码，需要重新插入防竞争字节
+			nal = addEmulationPrevention(nal)
+		}
+		out = append(out, nal...)
+	}
+	return out
+}
+
+// 查找起始码，返回起始码位置与NAL起始位置
+func nalStarts(es []byte) [][2]int {
+	starts := [][2]int{}
+	for i := 0; i+3 <= len(es); i++ {
+		if es[i] == 0 && es[i+1] == 0 && es[i+2] == 1 {
+			start := i
+			if i > 0 && es[i-1] == 0 {
+				start = i - 1
+				if len(starts) > 0 && starts[len(starts)-1][1] > start {
+					start = i
+				}
+			}
+			starts = append(starts, [2]int{start, i + 3})
+			i += 2
+		}
+	}
+	return starts
+}
+
+// 去除防竞争字节 00 00 03
+func removeEmulationPrevention(nal []byte) []byte {
+	out := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, b := range nal {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return out
+}
+
+// 插入防竞争字节，00 00后紧跟00至03时插入03，以00结尾时末尾追加03
+func addEmulationPrevention(nal []byte) []byte {
+	out := make([]byte, 0, len(nal)+len(nal)/64)
+	zeros := 0
+	for _, b := range nal {
+		if zeros >= 2 && b <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		out = append(out, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	if len(out) > 0 && out[len(out)-1] == 0 {
+		out = append(out, 3)
+	}
+	return out
+}
+
+// AAC: ADTS头与其后16字节为明文，之后完整分组加密，每帧重新使用IV
+func decryptADTS(es []byte, block cipher.Block, iv []byte) {
+	for pos := 0; pos+7 <= len(es); {
+		if es[pos] != 0xff || es[pos+1]&0xf0 != 0xf0 {
+			return
+		}
+		headerLength := 7
+		if es[pos+1]&0x01 == 0 {
+			headerLength = 9
+		}
+		frameLength := int(es[pos+3]&0x03)<<11 | int(es[pos+4])<<3 | int(es[pos+5]>>5)
+		if frameLength < headerLength || pos+frameLength > len(es) {
+			return
+		}
+		decryptFrame(es[pos+headerLength:pos+frameLength], block, iv)
+		pos += frameLength
+	}
+}
+
+// AC-3与E-AC-3: 帧首16字节为明文
+func decryptAC3(es []byte, block cipher.Block, iv []byte) {
+	for pos := 0; pos+6 <= len(es); {
+		if es[pos] != 0x0b || es[pos+1] != 0x77 {
+			return
+		}
+		frameLength := ac3FrameLength(es[pos:])
+		if frameLength <= 0 || pos+frameLength > len(es) {
+			return
+		}
+		decryptFrame(es[pos:pos+frameLength], block, iv)
+		pos += frameLength
+	}
+}
+
+func decryptFrame(frame []byte, block cipher.Block, iv []byte) {
+	if len(frame) <= aes.BlockSize {
+		return
+	}
+	data := frame[aes.BlockSize:]
+	size := len(data) / aes.BlockSize * aes.BlockSize
+	if size > 0 {
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(data[:size], data[:size])
+	}
+}
+
+// AC-3每帧字数表，按frmsizecod/2与fscod索引
+var ac3FrameSizes = [][3]int{
+	{64, 69, 96}, {80, 87, 120}, {96, 104, 144}, {112, 121, 168},
+	{128, 139, 192}, {160, 174, 240}, {192, 208, 288}, {224, 243, 336},
+	{256, 278, 384}, {320, 348, 480}, {384, 417, 576}, {448, 487, 672},
+	{512, 557, 768}, {640, 696, 960}, {768, 835, 1152}, {896, 975, 1344},
+	{1024, 1114, 1536}, {1152, 1253, 1728}, {1280, 1393, 1920},
+}
+
+func ac3FrameLength(frame []byte) int {
+	bsid := frame[5] >> 3
+	if bsid > 10 { // E-AC-3
+		return (int(frame[2]&0x07)<<8 | int(frame[3]) + 1) * 2
+	}
+	fscod := int(frame[4] >> 6)
+	frmsizecod := int(frame[4] & 0x3f)
+	if fscod > 2 || frmsizecod/2 >= len(ac3FrameSizes) {
+		return -1
+	}
+	words := ac3FrameSizes[frmsizecod/2][fscod]
+	if fscod == 1 && frmsizecod%2 == 1 {
+		words++
+	}
+	return words * 2
+}
+
+// 将PES重新切分为TS包，首包保留原有的适配域(PCR等)，末包以适配域填充
+func packetizePES(pid uint16, pes []byte, first []byte) [][]byte {
+	packets := [][]byte{}
+	var adaptation []byte
+	if (first[3]>>4)&0x03 == 3 && first[4] > 0 {
+		adaptation = append([]byte{}, first[5:5+int(first[4])]...)
+	}
+	for pos := 0; pos < len(pes) || len(packets) == 0; {
+		packet := make([]byte, tsPacketSize)
+		packet[0] = 0x47
+		packet[1] = byte(pid>>8) & 0x1f
+		if len(packets) == 0 {
+			packet[1] |= 0x40
+		}
+		packet[2] = byte(pid)
+		var field []byte
+		hasField := false
+		if len(packets) == 0 && adaptation != nil {
+			field = append([]byte{}, adaptation...)
+			hasField = true
+		}
+		capacity := tsPacketSize - 4
+		if hasField {
+			capacity -= 1 + len(field)
+		}
+		remain := len(pes) - pos
+		if remain < capacity {
+			stuffing := capacity - remain
+			if hasField {
+				field = append(field, bytes.Repeat([]byte{0xff}, stuffing)...)
+			} else {
+				hasField = true
+				if stuffing > 1 {
+					field = append([]byte{0x00}, bytes.Repeat([]byte{0xff}, stuffing-2)...)
+				}
+			}
+			capacity = remain
+		}
+		if hasField {
+			packet[3] = 0x30
+			packet[4] = byte(len(field))
+			copy(packet[5:], field)
+			copy(packet[5+len(field):], pes[pos:pos+capacity])
+		} else {
+			packet[3] = 0x10
+			copy(packet[4:], pes[pos:pos+capacity])
+		}
+		pos += capacity
+		packets = append(packets, packet)
+	}
+	return packets
+}
@@ -1,6 +0,0 @@
-package downloadManager
-
-var (
-	BinaryMerge bool
-	HasExtMap   bool
-)
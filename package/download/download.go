@@ -1,13 +1,13 @@
 package download
 
 import (
+	"io"
 	"net/http"
 	"net/url"
-	"runtime"
+	"os"
 	"strings"
 	"time"
 
-	"github.com/xfy520/m3u8_cli/package/log"
 	"github.com/xfy520/m3u8_cli/package/request"
 	"github.com/xfy520/m3u8_cli/package/tool"
 )
@@ -17,40 +17,95 @@ func HttpDownloadFileToBytes(uri string, headers string, timeOut time.Duration)
 	if strings.HasPrefix(uri, "file:") {
 		u, err := url.Parse(uri)
 		if err != nil {
-			log.Error(err.Error())
+			return nil, &DownloadError{Url: uri, Err: err}
 		}
-		uri = u.Path
-		sysType := runtime.GOOS
-		uri = tool.IfString(sysType == "windows", uri[:1], uri)
-		infbytes, err := tool.ReadFile(uri)
+		infbytes, err := tool.ReadFile(tool.UrlToPath(u))
 		if err != nil {
-			return nil, err
+			return nil, &DownloadError{Url: uri, Err: err}
 		}
 		return infbytes, nil
 	}
 	req, err := request.New(uri, http.MethodGet, timeOut, true)
+	if err != nil {
+		return nil, &DownloadError{Url: uri, Err: err}
+	}
 	req.InitHeader()
-	req.SetHeaders(headers)
+	if err := req.SetHeaders(headers); err != nil {
+		return nil, &DownloadError{Url: uri, Err: err}
+	}
+	byts, err := req.Send(-1)
 	if err != nil {
-		return nil, err
+		return nil, &DownloadError{Url: uri, Err: err}
 	}
-	return req.Send(-1)
+	return byts, nil
 }
 
 func GetWebSource(uri string, headers string, timeOut time.Duration) ([]byte, error) {
 	req, err := request.New(uri, http.MethodGet, timeOut, true)
 	if err != nil {
-		return nil, err
+		return nil, &DownloadError{Url: uri, Err: err}
 	}
 	req.InitHeader()
 	req.Set("accept-encoding", "gzip, deflate, br")
 	req.Set("keep-alive", "false")
-	req.SetHeaders(headers)
+	if err := req.SetHeaders(headers); err != nil {
+		return nil, &DownloadError{Url: uri, Err: err}
+	}
 	if strings.Contains(uri, "pcvideo") && strings.Contains(uri, ".titan.mgtv.com") {
 		if !strings.Contains(uri, "/internettv/") {
 			req.Set("referer", "https://www.mgtv.com")
 		}
 		req.Set("cookie", "MQGUID")
 	}
-	return req.Send(9)
+	byts, err := req.Send(9)
+	if err != nil {
+		return nil, &DownloadError{Url: uri, Err: err}
+	}
+	return byts, nil
+}
+
+// 以流的方式下载文件，expectByte大于0时只请求指定字节范围
+func HttpDownloadStream(uri string, headers string, timeOut time.Duration, startByte int64, expectByte int64) (io.ReadCloser, error) {
+	if strings.HasPrefix(uri, "file:") {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, &DownloadError{Url: uri, Err: err}
+		}
+		file, err := os.Open(tool.UrlToPath(u))
+		if err != nil {
+			return nil, &DownloadError{Url: uri, Err: err}
+		}
+		if expectByte > 0 {
+			return &fileRange{Reader: io.NewSectionReader(file, startByte, expectByte), file: file}, nil
+		}
+		return file, nil
+	}
+	req, err := request.New(uri, http.MethodGet, timeOut, true)
+	if err != nil {
+		return nil, &DownloadError{Url: uri, Err: err}
+	}
+	req.InitHeader()
+	if err := req.SetHeaders(headers); err != nil {
+		return nil, &DownloadError{Url: uri, Err: err}
+	}
+	var body io.ReadCloser
+	if expectByte > 0 {
+		body, err = req.SendRangeStream(9, startByte, expectByte)
+	} else {
+		body, err = req.SendStream(9)
+	}
+	if err != nil {
+		return nil, &DownloadError{Url: uri, Err: err}
+	}
+	return body, nil
+}
+
+// 本地文件的字节范围
+type fileRange struct {
+	io.Reader
+	file *os.File
+}
+
+func (f *fileRange) Close() error {
+	return f.file.Close()
 }
@@ -0,0 +1,631 @@
+package downloadManager
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xfy520/m3u8_cli/package/decrypt"
+	"github.com/xfy520/m3u8_cli/package/download"
+	"github.com/xfy520/m3u8_cli/package/ffmpeg"
+	"github.com/xfy520/m3u8_cli/package/lang"
+	"github.com/xfy520/m3u8_cli/package/log"
+	"github.com/xfy520/m3u8_cli/package/request"
+	"github.com/xfy520/m3u8_cli/package/tool"
+)
+
+// meta.json中的分片信息
+type segment struct {
+	ExpectByte int64   `json:"expectByte"`
+	StartByte  int64   `json:"startByte"`
+	Index      int64   `json:"index"`
+	Method     string  `json:"method,omitempty"`
+	Key        string  `json:"key,omitempty"`
+	Iv         string  `json:"iv,omitempty"`
+	Duration   float64 `json:"duration"`
+	SegUri     string  `json:"segUri,omitempty"`
+	Ad         bool    `json:"ad,omitempty"`
+	MapIndex   int     `json:"map,omitempty"`
+}
+
+type metaInfo struct {
+	M3u8     string `json:"m3u8,omitempty"`
+	M3u8Info struct {
+		Count       int64       `json:"count"`
+		Vod         bool        `json:"vod"`
+		BinaryMerge bool        `json:"binaryMerge,omitempty"`
+		RecTime     string      `json:"recTime,omitempty"`
+		ExtMAP      string      `json:"extMAP,omitempty"`
+		ExtMAPs     []string    `json:"extMAPs,omitempty"`
+		Segments    [][]segment `json:"segments,omitempty"`
+		Audios      []trackInfo `json:"audios,omitempty"`
+		Subtitles   []trackInfo `json:"subtitles,omitempty"`
+		Clips       []struct {
+			Start float64 `json:"start"`
+			End   float64 `json:"end"`
+		} `json:"clips,omitempty"`
+	} `json:"m3u8Info,omitempty"`
+}
+
+// 合并字节范围时单次请求的最大字节数
+const maxCoalesceSize = 32 * 1024 * 1024
+
+type downloadManager struct {
+	JsonFile              string
+	DownDir               string
+	Headers               string
+	Threads               int
+	RetryCount            int
+	TimeOut               time.Duration
+	NoMerge               bool
+	MuxFastStart          bool
+	WriteDate             bool
+	DelAfterDone          bool
+	BinaryMerge           bool
+	DisableIntegrityCheck bool
+	CoalesceRanges        bool              // 合并同一地址上相邻的字节范围请求
+	PreciseTrim           bool              // 合并后按 --downloadRange 精确裁剪
+	Keys                  map[string][]byte // --key 指定的KEY，以KID为键
+	LogFile               string            // 为空时写入全局日志
+	meta                  metaInfo
+	keys                  map[string][]byte
+	keysLock              sync.Mutex
+	tracks                map[int]map[uint32]*decrypt.TrackEncryption // 以初始化分段的序号为键
+	extraTracks           []ffmpeg.Track                              // 单独下载的音轨与字幕
+	outPath               string                                      // 合并后的文件
+}
+
+func NewDownloadManager() *downloadManager {
+	return &downloadManager{
+		Threads:    16,
+		RetryCount: 15,
+		TimeOut:    10,
+		WriteDate:  true,
+		keys:       map[string][]byte{},
+		tracks:     map[int]map[uint32]*decrypt.TrackEncryption{},
+	}
+}
+
+// 读取meta.json并下载全部分片，完成后合并
+func (d *downloadManager) DoDownload() error {
+	if d.JsonFile == "" {
+		d.JsonFile = path.Join(d.DownDir, "meta.json")
+	}
+	byts, err := tool.ReadFile(d.JsonFile)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(byts, &d.meta); err != nil {
+		return err
+	}
+	d.upgradeExtMap()
+	log.Info(lang.Lang.StartDownloading)
+	log.WriteInfoTo(d.LogFile, lang.Lang.StartDownloading)
+	for i := range d.meta.M3u8Info.ExtMAPs {
+		if err := d.downloadExtMap(i + 1); err != nil {
+			return err
+		}
+		if err := d.prepareExtMap(i + 1); err != nil {
+			return err
+		}
+	}
+	if err := d.downloadSegments(); err != nil {
+		return err
+	}
+	if err := d.downloadTracks(); err != nil {
+		return err
+	}
+	if d.NoMerge {
+		return nil
+	}
+	return d.Merge()
+}
+
+// 分片保存路径
+func (d *downloadManager) segmentPath(part int, seg segment) string {
+	return path.Join(d.DownDir, fmt.Sprintf("Part_%d", part), fmt.Sprintf("%05d.ts", seg.Index))
+}
+
+// 旧版meta.json只有一个初始化分段，所有分片都使用它
+func (d *downloadManager) upgradeExtMap() {
+	if len(d.meta.M3u8Info.ExtMAPs) > 0 || d.meta.M3u8Info.ExtMAP == "" {
+		return
+	}
+	d.meta.M3u8Info.ExtMAPs = []string{d.meta.M3u8Info.ExtMAP}
+	for _, part := range d.meta.M3u8Info.Segments {
+		for i := range part {
+			part[i].MapIndex = 1
+		}
+	}
+}
+
+func (d *downloadManager) extMapPath(index int) string {
+	return path.Join(d.DownDir, fmt.Sprintf("!MAP_%d.mp4", index))
+}
+
+// 去除加密信息后的初始化分段
+func (d *downloadManager) clearExtMapPath(index int) string {
+	return path.Join(d.DownDir, fmt.Sprintf("!MAP_%d.clear.mp4", index))
+}
+
+// 合并时使用的初始化分段
+func (d *downloadManager) mergeExtMapPath(index int) string {
+	if tool.Exists(d.clearExtMapPath(index)) {
+		return d.clearExtMapPath(index)
+	}
+	return d.extMapPath(index)
+}
+
+// 读取初始化分段中的轨道加密信息，并生成合并使用的未加密初始化分段
+func (d *downloadManager) prepareExtMap(index int) error {
+	byts, err := tool.ReadFile(d.extMapPath(index))
+	if err != nil {
+		return err
+	}
+	d.tracks[index] = decrypt.ReadTrackEncryption(byts)
+	if len(d.tracks[index]) == 0 {
+		return nil
+	}
+	decrypt.ClearInit(byts)
+	return os.WriteFile(d.clearExtMapPath(index), byts, 0666)
+}
+
+func (d *downloadManager) downloadExtMap(index int) error {
+	uri := d.meta.M3u8Info.ExtMAPs[index-1]
+	seg := segment{SegUri: uri}
+	if index := strings.Index(uri, "|"); index != -1 {
+		seg.SegUri = uri[:index]
+		t := strings.Split(uri[index+1:], "@")
+		if t[0] != "" {
+			seg.ExpectByte, _ = strconv.ParseInt(t[0], 10, 64)
+		}
+		if len(t) == 2 {
+			seg.StartByte, _ = strconv.ParseInt(t[1], 10, 64)
+		}
+	}
+	return d.retry(seg, d.extMapPath(index))
+}
+
+func (d *downloadManager) downloadSegments() error {
+	type task struct {
+		part int
+		segs []segment
+	}
+	var (
+		tasks  = make(chan task)
+		wg     sync.WaitGroup
+		done   int64
+		failed int64
+		total  int64
+	)
+	for _, part := range d.meta.M3u8Info.Segments {
+		total += int64(len(part))
+	}
+	threads := d.Threads
+	if threads <= 0 {
+		threads = 1
+	}
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tasks {
+				var err error
+				if len(t.segs) == 1 {
+					err = d.retry(t.segs[0], d.segmentPath(t.part, t.segs[0]))
+				} else {
+					err = d.retryRange(t.part, t.segs)
+				}
+				if err != nil {
+					atomic.AddInt64(&failed, int64(len(t.segs)))
+					log.Error(lang.Lang.SegmentDownloadError + err.Error())
+					log.WriteErrorTo(d.LogFile, lang.Lang.SegmentDownloadError+err.Error())
+					continue
+				}
+				fmt.Printf("\r"+lang.Lang.DownloadProgress, atomic.AddInt64(&done, int64(len(t.segs))), total)
+			}
+		}()
+	}
+	for i, part := range d.meta.M3u8Info.Segments {
+		if err := os.MkdirAll(path.Join(d.DownDir, fmt.Sprintf("Part_%d", i)), os.ModePerm); err != nil {
+			close(tasks)
+			wg.Wait()
+			return err
+		}
+		if !d.CoalesceRanges {
+			for _, seg := range part {
+				tasks <- task{part: i, segs: []segment{seg}}
+			}
+			continue
+		}
+		// 已下载的分片不再分组，直接计入进度
+		skipped := len(part)
+		for _, segs := range d.rangeGroups(i, part) {
+			skipped -= len(segs)
+			tasks <- task{part: i, segs: segs}
+		}
+		atomic.AddInt64(&done, int64(skipped))
+	}
+	close(tasks)
+	wg.Wait()
+	fmt.Println()
+	if failed > 0 && !d.DisableIntegrityCheck {
+		return fmt.Errorf(lang.Lang.DownloadIncomplete, failed)
+	}
+	return nil
+}
+
+// 失败时按设定次数重试
+func (d *downloadManager) retry(seg segment, savePath string) error {
+	var err error
+	for i := 0; i <= d.RetryCount; i++ {
+		if err = d.downloadSegment(seg, savePath); err == nil {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return err
+}
+
+// 将同一地址上首尾相接的字节范围分为一组，每组只发送一次请求，已下载的分片不再请求
+func (d *downloadManager) rangeGroups(part int, segs []segment) [][]segment {
+	groups := [][]segment{}
+	var size int64
+	for _, seg := range segs {
+		if tool.Exists(d.segmentPath(part, seg)) {
+			continue
+		}
+		if len(groups) > 0 && seg.ExpectByte > 0 {
+			group := groups[len(groups)-1]
+			last := group[len(group)-1]
+			if last.ExpectByte > 0 && last.SegUri == seg.SegUri && last.StartByte+last.ExpectByte == seg.StartByte && size+seg.ExpectByte <= maxCoalesceSize {
+				groups[len(groups)-1] = append(group, seg)
+				size += seg.ExpectByte
+				continue
+			}
+		}
+		groups = append(groups, []segment{seg})
+		size = seg.ExpectByte
+	}
+	return groups
+}
+
+// 服务器没有按合并后的范围返回时改为逐个下载组内的分片
+func (d *downloadManager) retryRange(part int, segs []segment) error {
+	var err error
+	for i := 0; i <= d.RetryCount; i++ {
+		if err = d.downloadRange(part, segs); err == nil {
+			return nil
+		}
+		if rangeUnsupported(err) {
+			log.WriteInfoTo(d.LogFile, lang.Lang.RangeFallback+err.Error())
+			for _, seg := range segs {
+				if err := d.retry(seg, d.segmentPath(part, seg)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return err
+}
+
+// 合并后的字节范围长度不符
+type rangeSizeError struct {
+	got  int64
+	want int64
+}
+
+func (e *rangeSizeError) Error() string {
+	return fmt.Sprintf(lang.Lang.RangeSizeMismatch, e.got, e.want)
+}
+
+func rangeUnsupported(err error) bool {
+	var sizeErr *rangeSizeError
+	return errors.Is(err, request.ErrRangeIgnored) || errors.As(err, &sizeErr)
+}
+
+// 一次请求下载一组相邻的字节范围，再按各分片的长度拆分并分别解密
+func (d *downloadManager) downloadRange(part int, segs []segment) error {
+	first, last := segs[0], segs[len(segs)-1]
+	length := last.StartByte + last.ExpectByte - first.StartByte
+	body, err := download.HttpDownloadStream(first.SegUri, d.Headers, d.TimeOut, first.StartByte, length)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	byts, err := io.ReadAll(body)
+	if err != nil {
+		return &download.DownloadError{Url: first.SegUri, Err: err}
+	}
+	if int64(len(byts)) != length {
+		return &download.DownloadError{Url: first.SegUri, Err: &rangeSizeError{got: int64(len(byts)), want: length}}
+	}
+	for _, seg := range segs {
+		offset := seg.StartByte - first.StartByte
+		reader, err := d.decryptStream(seg, bytes.NewReader(byts[offset:offset+seg.ExpectByte]))
+		if err != nil {
+			return err
+		}
+		if err := d.saveSegment(seg, reader, d.segmentPath(part, seg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 下载单个分片，加密分片在下载的同时解密
+func (d *downloadManager) downloadSegment(seg segment, savePath string) error {
+	if tool.Exists(savePath) {
+		return nil
+	}
+	body, err := download.HttpDownloadStream(seg.SegUri, d.Headers, d.TimeOut, seg.StartByte, seg.ExpectByte)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	reader, err := d.decryptStream(seg, body)
+	if err != nil {
+		return err
+	}
+	return d.saveSegment(seg, reader, savePath)
+}
+
+// 先写入临时文件，完整写入后再重命名，避免留下不完整的分片
+func (d *downloadManager) saveSegment(seg segment, reader io.Reader, savePath string) error {
+	tmpPath := savePath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, reader)
+	file.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return &download.DownloadError{Url: seg.SegUri, Err: err}
+	}
+	return os.Rename(tmpPath, savePath)
+}
+
+// 解密阶段，每个分片使用meta.json中各自的KEY和IV，以支持KEY轮换
+func (d *downloadManager) decryptStream(seg segment, r io.Reader) (io.Reader, error) {
+	switch seg.Method {
+	case "AES-128":
+		key, err := d.segmentKey(seg.Key)
+		if err != nil {
+			return nil, err
+		}
+		iv, err := decrypt.ParseIV(seg.Iv)
+		if err != nil {
+			return nil, err
+		}
+		return decrypt.NewAES128Reader(r, key, iv)
+	case "SAMPLE-AES", "SAMPLE-AES-CTR":
+		byts, err := io.ReadAll(r)
+		if err != nil {
+			return nil, &download.DownloadError{Url: seg.SegUri, Err: err}
+		}
+		if byts, err = d.decryptSamples(seg, byts); err != nil {
+			return nil, errors.New(lang.Lang.SampleAESDecryptError + err.Error())
+		}
+		return bytes.NewReader(byts), nil
+	}
+	return r, nil
+}
+
+// SAMPLE-AES需要完整分片才能解密，TS按Apple规范解密基本流，fMP4按cenc/cbcs解密样本
+func (d *downloadManager) decryptSamples(seg segment, byts []byte) ([]byte, error) {
+	if decrypt.IsTS(byts) {
+		key, err := d.sampleKey(seg, "")
+		if err != nil {
+			return nil, err
+		}
+		iv, err := decrypt.ParseIV(seg.Iv)
+		if err != nil {
+			return nil, err
+		}
+		return decrypt.DecryptSampleAESTS(byts, key, iv)
+	}
+	// 分片中可能自带moov，复制一份避免并发修改
+	tracks := map[uint32]*decrypt.TrackEncryption{}
+	for id, info := range d.tracks[seg.MapIndex] {
+		tracks[id] = info
+	}
+	err := decrypt.DecryptMP4(byts, tracks, func(kid string) []byte {
+		key, _ := d.sampleKey(seg, kid)
+		return key
+	})
+	return byts, err
+}
+
+// 依次按KID、分片KEY、未指定KID的 --key 查找KEY
+func (d *downloadManager) sampleKey(seg segment, kid string) ([]byte, error) {
+	if key, ok := d.Keys[kid]; ok && kid != "" {
+		return key, nil
+	}
+	if seg.Key != "" {
+		return d.segmentKey(seg.Key)
+	}
+	if key, ok := d.Keys[""]; ok {
+		return key, nil
+	}
+	if len(d.Keys) == 1 {
+		for _, key := range d.Keys {
+			return key, nil
+		}
+	}
+	return nil, decrypt.ErrNoKey
+}
+
+func (d *downloadManager) segmentKey(key string) ([]byte, error) {
+	d.keysLock.Lock()
+	defer d.keysLock.Unlock()
+	if value, ok := d.keys[key]; ok {
+		return value, nil
+	}
+	value, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+	d.keys[key] = value
+	return value, nil
+}
+
+// 合并分片，各分部先二进制合并，再按设定二进制合并或交由ffmpeg封装
+func (d *downloadManager) Merge() error {
+	log.Info(lang.Lang.StartMerging)
+	log.WriteInfoTo(d.LogFile, lang.Lang.StartMerging)
+	partFiles := []string{}
+	for i, part := range d.meta.M3u8Info.Segments {
+		files := []string{}
+		mapIndex := 0
+		for _, seg := range part {
+			if !tool.Exists(d.segmentPath(i, seg)) {
+				continue
+			}
+			// 每个分部以及分部内初始化分段改变处写入对应的初始化分段
+			if seg.MapIndex > 0 && seg.MapIndex != mapIndex {
+				files = append(files, d.mergeExtMapPath(seg.MapIndex))
+			}
+			mapIndex = seg.MapIndex
+			files = append(files, d.segmentPath(i, seg))
+		}
+		partFile := path.Join(d.DownDir, fmt.Sprintf("Part_%d.ts", i))
+		if err := CombineFiles(files, partFile); err != nil {
+			return err
+		}
+		partFiles = append(partFiles, partFile)
+	}
+	var outPath string
+	if d.BinaryMerge || d.meta.M3u8Info.BinaryMerge {
+		outPath = d.DownDir + tool.IfString(len(d.meta.M3u8Info.ExtMAPs) > 0, ".mp4", ".ts")
+		if err := CombineFiles(partFiles, outPath); err != nil {
+			return err
+		}
+		// 二进制合并无法封装额外的轨道，保留单独的文件
+		for _, track := range d.extraTracks {
+			log.Warn(lang.Lang.TrackNotMuxed + track.Path)
+			log.WriteInfoTo(d.LogFile, lang.Lang.TrackNotMuxed+track.Path)
+		}
+	} else {
+		outPath = d.DownDir + ".mp4"
+		if err := ffmpeg.Merge(partFiles, outPath, d.MuxFastStart, d.creationTime(), d.adChapters()); err != nil {
+			return err
+		}
+		if len(d.extraTracks) > 0 {
+			if err := d.muxTracks(outPath); err != nil {
+				return err
+			}
+		}
+	}
+	if clips := d.trimClips(); d.PreciseTrim && clips != nil {
+		log.Info(lang.Lang.StartTrimming)
+		log.WriteInfoTo(d.LogFile, lang.Lang.StartTrimming)
+		trimPath := d.DownDir + ".trim.mp4"
+		if err := ffmpeg.Trim(outPath, trimPath, clips, d.MuxFastStart, d.creationTime()); err != nil {
+			return err
+		}
+		if err := os.Remove(outPath); err != nil {
+			return err
+		}
+		outPath = d.DownDir + ".mp4"
+		if err := os.Rename(trimPath, outPath); err != nil {
+			return err
+		}
+	}
+	d.outPath = outPath
+	log.Info(lang.Lang.MergeDone + outPath)
+	log.WriteInfoTo(d.LogFile, lang.Lang.MergeDone+outPath)
+	if d.DelAfterDone {
+		return os.RemoveAll(d.DownDir)
+	}
+	return nil
+}
+
+// 写入的创建时间，优先使用第一个分片的录制时间
+func (d *downloadManager) creationTime() string {
+	if !d.WriteDate {
+		return ""
+	}
+	if d.meta.M3u8Info.RecTime != "" {
+		return d.meta.M3u8Info.RecTime
+	}
+	return time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// 需要裁剪的时间段，只有一个时间段且覆盖全部分片时不需要裁剪
+func (d *downloadManager) trimClips() []ffmpeg.Clip {
+	var total float64
+	for _, part := range d.meta.M3u8Info.Segments {
+		for _, seg := range part {
+			total += seg.Duration
+		}
+	}
+	clips := []ffmpeg.Clip{}
+	for _, clip := range d.meta.M3u8Info.Clips {
+		clips = append(clips, ffmpeg.Clip{Start: clip.Start, End: clip.End})
+	}
+	// 允许1毫秒的误差
+	if len(clips) == 0 || (len(clips) == 1 && clips[0].Start < 0.001 && clips[0].End > total-0.001) {
+		return nil
+	}
+	return clips
+}
+
+// --adPolicy mark 标记的广告分片按连续区间生成章节
+func (d *downloadManager) adChapters() []ffmpeg.Chapter {
+	chapters := []ffmpeg.Chapter{}
+	hasAd, lastAd := false, false
+	position := 0.0
+	for i, part := range d.meta.M3u8Info.Segments {
+		for _, seg := range part {
+			if !tool.Exists(d.segmentPath(i, seg)) {
+				continue
+			}
+			if len(chapters) == 0 || seg.Ad != lastAd {
+				title := tool.IfString(seg.Ad, lang.Lang.AdChapter, lang.Lang.ContentChapter)
+				chapters = append(chapters, ffmpeg.Chapter{Start: position, End: position, Title: title})
+				lastAd = seg.Ad
+			}
+			position += seg.Duration
+			chapters[len(chapters)-1].End = position
+			hasAd = hasAd || seg.Ad
+		}
+	}
+	if !hasAd {
+		return nil
+	}
+	return chapters
+}
+
+// 二进制合并文件
+func CombineFiles(files []string, outPath string) error {
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	for _, file := range files {
+		in, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package downloadManager
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xfy520/m3u8_cli/package/ffmpeg"
+	"github.com/xfy520/m3u8_cli/package/lang"
+	"github.com/xfy520/m3u8_cli/package/log"
+	"github.com/xfy520/m3u8_cli/package/tool"
+)
+
+// meta.json中选中的音轨或字幕，Dir不为空时需要单独下载
+type trackInfo struct {
+	Name     string `json:"name,omitempty"`
+	Language string `json:"language,omitempty"`
+	Dir      string `json:"dir,omitempty"`
+}
+
+// 单独下载的轨道使用与主视频相同的下载设置
+func (d *downloadManager) trackManager(dir string) *downloadManager {
+	track := NewDownloadManager()
+	track.DownDir = path.Join(d.DownDir, dir)
+	track.Headers = d.Headers
+	track.Threads = d.Threads
+	track.RetryCount = d.RetryCount
+	track.TimeOut = d.TimeOut
+	track.NoMerge = d.NoMerge
+	track.DisableIntegrityCheck = d.DisableIntegrityCheck
+	track.CoalesceRanges = d.CoalesceRanges
+	track.Keys = d.Keys
+	track.BinaryMerge = d.BinaryMerge
+	track.LogFile = d.LogFile
+	return track
+}
+
+// 下载其余选中的音轨与字幕，音轨单独合并，字幕拼接为一个文件，合并时作为额外的轨道
+func (d *downloadManager) downloadTracks() error {
+	for _, audio := range d.meta.M3u8Info.Audios {
+		if audio.Dir == "" {
+			continue
+		}
+		log.Info(fmt.Sprintf(lang.Lang.DownloadingTrack, audio.Name))
+		log.WriteInfoTo(d.LogFile, fmt.Sprintf(lang.Lang.DownloadingTrack, audio.Name))
+		track := d.trackManager(audio.Dir)
+		if err := track.DoDownload(); err != nil {
+			return err
+		}
+		if !d.NoMerge {
+			d.extraTracks = append(d.extraTracks, ffmpeg.Track{Path: track.outPath, Language: audio.Language, Title: audio.Name})
+		}
+	}
+	for _, subtitle := range d.meta.M3u8Info.Subtitles {
+		if subtitle.Dir == "" {
+			continue
+		}
+		log.Info(fmt.Sprintf(lang.Lang.DownloadingTrack, subtitle.Name))
+		log.WriteInfoTo(d.LogFile, fmt.Sprintf(lang.Lang.DownloadingTrack, subtitle.Name))
+		track := d.trackManager(subtitle.Dir)
+		track.NoMerge = true
+		if err := track.DoDownload(); err != nil {
+			return err
+		}
+		if d.NoMerge {
+			continue
+		}
+		file, err := track.combineSubtitles()
+		if err != nil {
+			return err
+		}
+		d.extraTracks = append(d.extraTracks, ffmpeg.Track{Path: file, Subtitle: true, Language: subtitle.Language, Title: subtitle.Name})
+	}
+	return nil
+}
+
+// 将额外的轨道封装到合并后的文件中
+func (d *downloadManager) muxTracks(outPath string) error {
+	log.Info(lang.Lang.MuxingTracks)
+	log.WriteInfoTo(d.LogFile, lang.Lang.MuxingTracks)
+	muxPath := d.DownDir + ".tracks.mp4"
+	if err := ffmpeg.MuxTracks(outPath, d.extraTracks, muxPath, d.MuxFastStart); err != nil {
+		return err
+	}
+	if err := os.Remove(outPath); err != nil {
+		return err
+	}
+	return os.Rename(muxPath, outPath)
+}
+
+// 拼接字幕分片，fMP4字幕与初始化分段一起二进制合并，WebVTT合并为一个文件
+func (d *downloadManager) combineSubtitles() (string, error) {
+	files := []string{}
+	mapIndex := 0
+	for i, part := range d.meta.M3u8Info.Segments {
+		for _, seg := range part {
+			if !tool.Exists(d.segmentPath(i, seg)) {
+				continue
+			}
+			if seg.MapIndex > 0 && seg.MapIndex != mapIndex {
+				files = append(files, d.mergeExtMapPath(seg.MapIndex))
+			}
+			mapIndex = seg.MapIndex
+			files = append(files, d.segmentPath(i, seg))
+		}
+	}
+	if len(d.meta.M3u8Info.ExtMAPs) > 0 {
+		outPath := d.DownDir + ".mp4"
+		return outPath, CombineFiles(files, outPath)
+	}
+	outPath := d.DownDir + ".vtt"
+	return outPath, CombineWebVTT(files, outPath)
+}
+
+var (
+	// X-TIMESTAMP-MAP=MPEGTS:900000,LOCAL:00:00:00.000
+	timestampMapReg = regexp.MustCompile(`MPEGTS:(\d+)`)
+	localTimeReg    = regexp.MustCompile(`LOCAL:((?:\d+:)?\d+:\d+\.\d+)`)
+	cueTimeReg      = regexp.MustCompile(`^((?:\d+:)?\d+:\d+\.\d+)\s+-->\s+((?:\d+:)?\d+:\d+\.\d+)(.*)$`)
+)
+
+// 合并WebVTT分片，只保留第一个文件头。各分片按X-TIMESTAMP-MAP换算到第一个分片的时间轴
+func CombineWebVTT(files []string, outPath string) error {
+	lines := []string{"WEBVTT", ""}
+	var base time.Duration
+	for i, file := range files {
+		byts, err := tool.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		header := true
+		var offset time.Duration
+		scanner := bufio.NewScanner(strings.NewReader(strings.TrimPrefix(tool.BytesToStr(byts), "\ufeff")))
+		for scanner.Scan() {
+			line := strings.TrimRight(scanner.Text(), "\r")
+			if header { // 文件头直到第一个空行为止
+				if strings.HasPrefix(line, "X-TIMESTAMP-MAP") {
+					offset = timestampOffset(line)
+				}
+				if line == "" {
+					header = false
+					if i == 0 {
+						base = offset
+					}
+				}
+				continue
+			}
+			if params := cueTimeReg.FindStringSubmatch(line); params != nil {
+				start, _ := parseCueTime(params[1])
+				end, _ := parseCueTime(params[2])
+				line = formatCueTime(start+offset-base) + " --> " + formatCueTime(end+offset-base) + params[3]
+			}
+			lines = append(lines, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		if len(lines) > 0 && lines[len(lines)-1] != "" {
+			lines = append(lines, "")
+		}
+	}
+	return tool.WriteFile(outPath, strings.Join(lines, "\n")+"\n")
+}
+
+// 分片时间轴相对于MPEG-TS时间戳的偏移
+func timestampOffset(line string) time.Duration {
+	var offset time.Duration
+	if params := timestampMapReg.FindStringSubmatch(line); params != nil {
+		ticks, _ := strconv.ParseInt(params[1], 10, 64)
+		offset = time.Duration(ticks) * time.Second / 90000
+	}
+	if params := localTimeReg.FindStringSubmatch(line); params != nil {
+		local, _ := parseCueTime(params[1])
+		offset -= local
+	}
+	return offset
+}
+
+// 解析 hh:mm:ss.ttt 或 mm:ss.ttt
+func parseCueTime(value string) (time.Duration, error) {
+	fields := strings.Split(value, ":")
+	var total time.Duration
+	for i, field := range fields {
+		unit := time.Minute
+		if len(fields) == 3 && i == 0 {
+			unit = time.Hour
+		}
+		if i == len(fields)-1 {
+			seconds, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return 0, err
+			}
+			total += time.Duration(seconds * float64(time.Second))
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return 0, err
+		}
+		total += time.Duration(n) * unit
+	}
+	return total, nil
+}
+
+func formatCueTime(value time.Duration) string {
+	if value < 0 {
+		value = 0
+	}
+	ms := int64((value + time.Millisecond/2) / time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
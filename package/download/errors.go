@@ -0,0 +1,15 @@
+package download
+
+// 下载错误
+type DownloadError struct {
+	Url string
+	Err error
+}
+
+func (e *DownloadError) Error() string {
+	return e.Url + ": " + e.Err.Error()
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
@@ -11,10 +11,6 @@ import (
 
 var ffmpeg_path = ""
 
-var (
-	REC_TIME string = ""
-)
-
 func Init(ffmpegPath string) error {
 	cmd := exec.Command("ffmpeg")
 	if cmd.Path != "ffmpeg" {
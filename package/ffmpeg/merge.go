@@ -0,0 +1,143 @@
+package ffmpeg
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xfy520/m3u8_cli/package/tool"
+)
+
+// 章节，时间单位为秒
+type Chapter struct {
+	Start float64
+	End   float64
+	Title string
+}
+
+// 使用concat方式将多个文件无损封装为一个文件，chapters不为空时写入章节，creationTime不为空时写入创建时间
+func Merge(files []string, outPath string, fastStart bool, creationTime string, chapters []Chapter) error {
+	if ffmpeg_path == "" {
+		return errors.New("ffmpeg not found")
+	}
+	listPath := outPath + ".txt"
+	lines := []string{}
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, "file '"+strings.ReplaceAll(abs, "'", `'\''`)+"'")
+	}
+	if err := tool.WriteFile(listPath, strings.Join(lines, "\n")+"\n"); err != nil {
+		return err
+	}
+	defer os.Remove(listPath)
+	args := []string{"-loglevel", "warning", "-y", "-f", "concat", "-safe", "0", "-i", listPath}
+	if len(chapters) > 0 {
+		metaPath := outPath + ".meta.txt"
+		if err := tool.WriteFile(metaPath, chapterMetadata(chapters)); err != nil {
+			return err
+		}
+		defer os.Remove(metaPath)
+		args = append(args, "-i", metaPath, "-map_chapters", "1")
+	}
+	args = append(args, "-map", "0", "-c", "copy")
+	if fastStart {
+		args = append(args, "-movflags", "+faststart")
+	}
+	if creationTime != "" {
+		args = append(args, "-metadata", "creation_time="+creationTime)
+	}
+	args = append(args, outPath)
+	output, err := exec.Command(ffmpeg_path, args...).CombinedOutput()
+	if err != nil {
+		return errors.New(err.Error() + ": " + strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// 生成ffmetadata格式的章节信息
+func chapterMetadata(chapters []Chapter) string {
+	lines := []string{";FFMETADATA1"}
+	for _, chapter := range chapters {
+		lines = append(lines,
+			"[CHAPTER]",
+			"TIMEBASE=1/1000",
+			"START="+strconv.FormatInt(int64(chapter.Start*1000), 10),
+			"END="+strconv.FormatInt(int64(chapter.End*1000), 10),
+			"title="+chapter.Title)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// 单独下载的音轨或字幕
+type Track struct {
+	Path     string
+	Subtitle bool
+	Language string
+	Title    string
+}
+
+// 将音轨与字幕作为额外的轨道封装到input中，音频直接复制，字幕转换为mp4支持的mov_text
+func MuxTracks(input string, tracks []Track, outPath string, fastStart bool) error {
+	if ffmpeg_path == "" {
+		return errors.New("ffmpeg not found")
+	}
+	streams, err := probeStreams(input)
+	if err != nil {
+		return err
+	}
+	args := muxTracksArgs(input, streams, tracks)
+	if fastStart {
+		args = append(args, "-movflags", "+faststart")
+	}
+	args = append(args, outPath)
+	output, err := exec.Command(ffmpeg_path, args...).CombinedOutput()
+	if err != nil {
+		return errors.New(err.Error() + ": " + strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func muxTracksArgs(input string, streams []stream, tracks []Track) []string {
+	// 额外轨道在输出中的序号排在原有的同类轨道之后
+	audio, subtitle := 0, 0
+	for _, s := range streams {
+		switch s.Type {
+		case "Audio":
+			audio++
+		case "Subtitle":
+			subtitle++
+		}
+	}
+	args := []string{"-loglevel", "warning", "-y", "-i", input}
+	for _, track := range tracks {
+		args = append(args, "-i", track.Path)
+	}
+	args = append(args, "-map", "0")
+	metadata := []string{}
+	for i, track := range tracks {
+		input := strconv.Itoa(i + 1)
+		specifier := "a:" + strconv.Itoa(audio)
+		if track.Subtitle {
+			args = append(args, "-map", input+":s?")
+			specifier = "s:" + strconv.Itoa(subtitle)
+			subtitle++
+		} else {
+			args = append(args, "-map", input+":a?")
+			audio++
+		}
+		if track.Language != "" {
+			metadata = append(metadata, "-metadata:s:"+specifier, "language="+track.Language)
+		}
+		if track.Title != "" {
+			metadata = append(metadata, "-metadata:s:"+specifier, "title="+track.Title)
+		}
+	}
+	args = append(args, "-c", "copy", "-c:s", "mov_text")
+	return append(args, metadata...)
+}
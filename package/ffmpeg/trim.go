@@ -0,0 +1,157 @@
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 裁剪的时间段，单位为秒
+type Clip struct {
+	Start float64
+	End   float64
+}
+
+// 输入文件中的一路流
+type stream struct {
+	Type    string // Video、Audio 或 Subtitle
+	Codec   string
+	PixFmt  string
+	Bitrate int // kb/s，0表示未知
+}
+
+// ffmpeg -i 输出的流信息，如 Stream #0:1[0x101](eng): Audio: aac (LC), 48000 Hz, stereo, fltp, 128 kb/s
+var (
+	streamReg  = regexp.MustCompile(`Stream #0:\d+[^:]*: (Video|Audio|Subtitle): (\w+)(.*)`)
+	pixFmtReg  = regexp.MustCompile(`, ((?:yuv|yuvj|nv|p0|gray)\w*)`)
+	bitrateReg = regexp.MustCompile(`(\d+) kb/s`)
+)
+
+// 源编码对应的编码器，未列出的编码使用通用编码器
+var (
+	videoEncoders = map[string]string{
+		"h264":       "libx264",
+		"hevc":       "libx265",
+		"mpeg2video": "mpeg2video",
+		"vp9":        "libvpx-vp9",
+		"av1":        "libsvtav1",
+	}
+	audioEncoders = map[string]string{
+		"aac":  "aac",
+		"ac3":  "ac3",
+		"eac3": "eac3",
+		"mp3":  "libmp3lame",
+		"opus": "libopus",
+		"flac": "flac",
+	}
+)
+
+// 按时间段精确裁剪，在输入前使用-ss精确定位并按源文件的编码重新编码，
+// 保留全部视频、音频与字幕流，多个时间段依次拼接为一个文件
+func Trim(input string, outPath string, clips []Clip, fastStart bool, creationTime string) error {
+	if ffmpeg_path == "" {
+		return errors.New("ffmpeg not found")
+	}
+	streams, err := probeStreams(input)
+	if err != nil {
+		return err
+	}
+	files := []string{}
+	defer func() {
+		for _, file := range files {
+			os.Remove(file)
+		}
+	}()
+	for i, clip := range clips {
+		clipPath := fmt.Sprintf("%s.clip%d.mp4", outPath, i)
+		args := []string{"-loglevel", "warning", "-y",
+			"-ss", formatSeconds(clip.Start), "-i", input, "-t", formatSeconds(clip.End - clip.Start)}
+		args = append(args, encodeArgs(streams)...)
+		args = append(args, clipPath)
+		output, err := exec.Command(ffmpeg_path, args...).CombinedOutput()
+		if err != nil {
+			return errors.New(err.Error() + ": " + strings.TrimSpace(string(output)))
+		}
+		files = append(files, clipPath)
+	}
+	// 各时间段编码参数相同，可以直接无损拼接
+	return Merge(files, outPath, fastStart, creationTime, nil)
+}
+
+// 读取输入文件的流信息，ffmpeg只指定输入时以错误退出，因此只解析输出
+func probeStreams(input string) ([]stream, error) {
+	output, _ := exec.Command(ffmpeg_path, "-hide_banner", "-i", input).CombinedOutput()
+	streams := parseStreams(string(output))
+	if len(streams) == 0 {
+		return nil, errors.New("no streams found: " + strings.TrimSpace(string(output)))
+	}
+	return streams, nil
+}
+
+func parseStreams(output string) []stream {
+	streams := []stream{}
+	for _, line := range strings.Split(output, "\n") {
+		params := streamReg.FindStringSubmatch(line)
+		if params == nil {
+			continue
+		}
+		s := stream{Type: params[1], Codec: params[2]}
+		if s.Type == "Video" {
+			if pixFmt := pixFmtReg.FindStringSubmatch(params[3]); pixFmt != nil {
+				s.PixFmt = pixFmt[1]
+			}
+		}
+		if bitrate := bitrateReg.FindStringSubmatch(params[3]); bitrate != nil {
+			s.Bitrate, _ = strconv.Atoi(bitrate[1])
+		}
+		streams = append(streams, s)
+	}
+	return streams
+}
+
+// 每路流使用与源相同的编码与像素格式(保留位深)，字幕直接复制
+func encodeArgs(streams []stream) []string {
+	args := []string{"-map", "0:v?", "-map", "0:a?", "-map", "0:s?"}
+	video, audio := 0, 0
+	for _, s := range streams {
+		switch s.Type {
+		case "Video":
+			index := strconv.Itoa(video)
+			encoder, ok := videoEncoders[s.Codec]
+			if !ok {
+				encoder = "libx264"
+			}
+			args = append(args, "-c:v:"+index, encoder)
+			if encoder == "libx264" || encoder == "libx265" {
+				args = append(args, "-crf:v:"+index, "18")
+			}
+			if s.PixFmt != "" {
+				args = append(args, "-pix_fmt:v:"+index, s.PixFmt)
+			}
+			if s.Codec == "hevc" {
+				args = append(args, "-tag:v:"+index, "hvc1")
+			}
+			video++
+		case "Audio":
+			index := strconv.Itoa(audio)
+			encoder, ok := audioEncoders[s.Codec]
+			if !ok {
+				encoder = "aac"
+			}
+			args = append(args, "-c:a:"+index, encoder)
+			if s.Bitrate > 0 && encoder != "flac" {
+				args = append(args, "-b:a:"+index, strconv.Itoa(s.Bitrate)+"k")
+			}
+			audio++
+		}
+	}
+	return append(args, "-c:s", "copy")
+}
+
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
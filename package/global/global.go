@@ -1,5 +0,0 @@
-package global
-
-var (
-	VIDEO_TYPE = ""
-)
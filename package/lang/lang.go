@@ -70,6 +70,74 @@ type Contact struct {
 	NoMerge                       string `json:"NoMerge"`
 	NoProxy                       string `json:"NoProxy"`
 	DisableIntegrityCheck         string `json:"DisableIntegrityCheck"`
+	StartDownloading              string `json:"StartDownloading"`
+	DownloadProgress              string `json:"DownloadProgress"`
+	SegmentDownloadError          string `json:"SegmentDownloadError"`
+	DownloadIncomplete            string `json:"DownloadIncomplete"`
+	StartMerging                  string `json:"StartMerging"`
+	MergeDone                     string `json:"MergeDone"`
+	KeyFormatNeedsKey             string `json:"KeyFormatNeedsKey"`
+	Key                           string `json:"Key"`
+	InvalidKey                    string `json:"InvalidKey"`
+	SampleAESDecryptError         string `json:"SampleAESDecryptError"`
+	SelectVideo                   string `json:"SelectVideo"`
+	SelectedVariant               string `json:"SelectedVariant"`
+	NoVariantMatch                string `json:"NoVariantMatch"`
+	InvalidSelector               string `json:"InvalidSelector"`
+	SelectAudio                   string `json:"SelectAudio"`
+	SelectSubtitle                string `json:"SelectSubtitle"`
+	SelectedRendition             string `json:"SelectedRendition"`
+	NoRenditionMatch              string `json:"NoRenditionMatch"`
+	RenditionRuleUnmatched        string `json:"RenditionRuleUnmatched"`
+	IsmProtected                  string `json:"IsmProtected"`
+	IsmUnsupportedCodec           string `json:"IsmUnsupportedCodec"`
+	LowLatencyLive                string `json:"LowLatencyLive"`
+	LLHLSRecorded                 string `json:"LLHLSRecorded"`
+	AdPolicy                      string `json:"AdPolicy"`
+	InvalidAdPolicy               string `json:"InvalidAdPolicy"`
+	AdBreaksFound                 string `json:"AdBreaksFound"`
+	AdChapter                     string `json:"AdChapter"`
+	ContentChapter                string `json:"ContentChapter"`
+	ClassifyParts                 string `json:"ClassifyParts"`
+	BumperDurations               string `json:"BumperDurations"`
+	InvalidBumperDurations        string `json:"InvalidBumperDurations"`
+	PartRemoved                   string `json:"PartRemoved"`
+	PartProbeError                string `json:"PartProbeError"`
+	EnableCoalesceRanges          string `json:"EnableCoalesceRanges"`
+	RangeSizeMismatch             string `json:"RangeSizeMismatch"`
+	InvalidDownloadRange          string `json:"InvalidDownloadRange"`
+	EnablePreciseTrim             string `json:"EnablePreciseTrim"`
+	StartTrimming                 string `json:"StartTrimming"`
+	LiveStreamRecording           string `json:"LiveStreamRecording"`
+	LiveRecorded                  string `json:"LiveRecorded"`
+	InvalidLiveRecDur             string `json:"InvalidLiveRecDur"`
+	LiveReloadRetry               string `json:"LiveReloadRetry"`
+	LiveGap                       string `json:"LiveGap"`
+	LiveChunkFinished             string `json:"LiveChunkFinished"`
+	LiveChunkExpired              string `json:"LiveChunkExpired"`
+	LiveSplitDur                  string `json:"LiveSplitDur"`
+	LiveSplitSize                 string `json:"LiveSplitSize"`
+	LiveSplitClock                string `json:"LiveSplitClock"`
+	LiveRetention                 string `json:"LiveRetention"`
+	InvalidLiveSplit              string `json:"InvalidLiveSplit"`
+	EnableChannelList             string `json:"EnableChannelList"`
+	LiveMaxConns                  string `json:"LiveMaxConns"`
+	LiveRate                      string `json:"LiveRate"`
+	InvalidChannelList            string `json:"InvalidChannelList"`
+	RecordingChannels             string `json:"RecordingChannels"`
+	EnableSchedule                string `json:"EnableSchedule"`
+	InvalidSchedule               string `json:"InvalidSchedule"`
+	ScheduleStarted               string `json:"ScheduleStarted"`
+	ScheduleMerged                string `json:"ScheduleMerged"`
+	LiveStartFrom                 string `json:"LiveStartFrom"`
+	InvalidLiveStartFrom          string `json:"InvalidLiveStartFrom"`
+	LiveStartBeforeWindow         string `json:"LiveStartBeforeWindow"`
+	LivePlaybackStart             string `json:"LivePlaybackStart"`
+	RangeFallback                 string `json:"RangeFallback"`
+	LiveManifestUnsupported       string `json:"LiveManifestUnsupported"`
+	DownloadingTrack              string `json:"DownloadingTrack"`
+	MuxingTracks                  string `json:"MuxingTracks"`
+	TrackNotMuxed                 string `json:"TrackNotMuxed"`
 }
 
 var Lang Contact
@@ -0,0 +1,220 @@
+package live
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xfy520/m3u8_cli/package/download"
+	"github.com/xfy520/m3u8_cli/package/lang"
+	"github.com/xfy520/m3u8_cli/package/log"
+	"github.com/xfy520/m3u8_cli/package/parser"
+	"github.com/xfy520/m3u8_cli/package/tool"
+)
+
+var (
+	ErrInvalidChannel = errors.New("invalid channel")
+	ErrChannelFailed  = errors.New("channel recording failed")
+)
+
+// 频道列表文件中的一个频道
+type Channel struct {
+	Name        string `json:"name"`
+	Url         string `json:"url"`
+	Headers     string `json:"headers,omitempty"`     // 未指定时使用 --headers
+	SelectVideo string `json:"selectVideo,omitempty"` // 与 --selectVideo 相同，未指定时选择带宽最高的清晰度
+}
+
+// 读取JSON格式的频道列表，频道名用作保存目录与日志文件名，不能重复
+func LoadChannels(file string) ([]*Channel, error) {
+	byts, err := tool.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	channels := []*Channel{}
+	if err := json.Unmarshal(byts, &channels); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for i, channel := range channels {
+		channel.Name = tool.GetFileName(strings.TrimSpace(channel.Name))
+		if channel.Name == "" || channel.Url == "" {
+			return nil, fmt.Errorf("%w: %d", ErrInvalidChannel, i)
+		}
+		if names[channel.Name] {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidChannel, channel.Name)
+		}
+		names[channel.Name] = true
+	}
+	return channels, nil
+}
+
+// 在同一进程中同时录制多个频道，各频道使用各自的录制器、目录与日志文件，
+// 共享连接数与请求速率限制。录制完成后由OnFinish解析并合并，logFile为频道的日志文件
+type multiRecorder struct {
+	Channels     []*Channel
+	DownDir      string // 各频道保存在以频道名命名的子目录中
+	LogDir       string
+	Headers      string
+	TimeOut      time.Duration
+	RetryCount   int
+	Duration     float64
+	RollDuration float64
+	RollSize     int64
+	RollInterval time.Duration
+	Retention    time.Duration
+	StartTime    time.Time
+	Limiter      *limiter
+	OnFinish     func(localUrl string, downDir string, headers string, logFile string) error
+	recorders    []*recorder
+	mu           sync.Mutex
+}
+
+func NewMultiRecorder() *multiRecorder {
+	return &multiRecorder{
+		TimeOut:    10,
+		RetryCount: 3,
+	}
+}
+
+// 录制全部频道直到各自结束，部分频道失败不影响其他频道
+func (m *multiRecorder) Record() error {
+	var wg sync.WaitGroup
+	var failedMu sync.Mutex
+	failed := []string{}
+	for _, channel := range m.Channels {
+		wg.Add(1)
+		go func(channel *Channel) {
+			defer wg.Done()
+			if err := m.record(channel); err != nil {
+				log.Error("[" + channel.Name + "] " + err.Error())
+				log.WriteError("[" + channel.Name + "] " + err.Error())
+				failedMu.Lock()
+				failed = append(failed, channel.Name)
+				failedMu.Unlock()
+			}
+		}(channel)
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %s", ErrChannelFailed, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// 结束全部频道的录制
+func (m *multiRecorder) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.recorders {
+		r.Stop()
+	}
+}
+
+func (m *multiRecorder) record(channel *Channel) error {
+	headers := channel.Headers
+	if headers == "" {
+		headers = m.Headers
+	}
+	logFile := ""
+	if m.LogDir != "" {
+		var err error
+		logFile, err = log.NewLogFile(path.Join(m.LogDir, channel.Name+"_"+time.Now().Format("2006-01-02_15-04-05.000")+".log"), channel.Url)
+		if err != nil {
+			return err
+		}
+	}
+	channelUrl, startTime := channel.Url, m.StartTime
+	if !startTime.IsZero() {
+		if playbackUrl, ok := parser.SetPlaybackStart(channelUrl, startTime); ok {
+			// 回看地址由服务器从开始时间返回分片
+			channelUrl, startTime = playbackUrl, time.Time{}
+		}
+	}
+	mediaUrl, variables, lowLatency, err := m.resolve(channel, channelUrl, headers)
+	if err != nil {
+		return err
+	}
+	r, record := NewRecorder(lowLatency)
+	r.M3u8Url = mediaUrl
+	r.Headers = headers
+	r.DownDir = path.Join(m.DownDir, channel.Name)
+	r.TimeOut = m.TimeOut
+	r.RetryCount = m.RetryCount
+	r.Duration = m.Duration
+	r.Variables = variables
+	r.RollDuration = m.RollDuration
+	r.RollSize = m.RollSize
+	r.RollInterval = m.RollInterval
+	r.StartTime = startTime
+	r.Name = channel.Name
+	r.LogFile = logFile
+	r.Limiter = m.Limiter
+	r.OnRoll = func(localUrl string, start time.Time) error {
+		if err := m.OnFinish(localUrl, path.Join(m.DownDir, ChunkName(channel.Name, start)), headers, logFile); err != nil {
+			return err
+		}
+		return RemoveExpiredChunks(m.DownDir, channel.Name, m.Retention)
+	}
+	m.mu.Lock()
+	m.recorders = append(m.recorders, r)
+	m.mu.Unlock()
+	r.warn(lang.Lang.StartParsing + mediaUrl)
+	localUrl, err := record()
+	if err != nil {
+		r.fail(err.Error())
+		return err
+	}
+	if localUrl == "" { //切分录制时各分段已单独合并
+		return nil
+	}
+	return m.OnFinish(localUrl, r.DownDir, headers, logFile)
+}
+
+// 频道地址为主列表时选择清晰度，返回媒体列表地址、主列表中定义的变量以及是否为LL-HLS
+func (m *multiRecorder) resolve(channel *Channel, uri string, headers string) (string, map[string]string, bool, error) {
+	playlist, variables, err := m.fetchPlaylist(uri, headers, nil)
+	if err != nil {
+		return "", nil, false, err
+	}
+	if master, ok := playlist.(*parser.MasterPlaylist); ok {
+		selector := &parser.VariantSelector{Pick: "best"}
+		if channel.SelectVideo != "" {
+			if selector, err = parser.ParseVariantSelector(channel.SelectVideo); err != nil {
+				return "", nil, false, err
+			}
+		}
+		variant, err := selector.Select(master.Variants)
+		if err != nil {
+			return "", nil, false, err
+		}
+		uri = resolveUrl(uri, variant.URI)
+		if playlist, _, err = m.fetchPlaylist(uri, headers, variables); err != nil {
+			return "", nil, false, err
+		}
+	}
+	media, ok := playlist.(*parser.MediaPlaylist)
+	if !ok {
+		return "", nil, false, fmt.Errorf("%w: %s", ErrInvalidChannel, channel.Name)
+	}
+	return uri, variables, media.PartInf != nil && !media.EndList, nil
+}
+
+func (m *multiRecorder) fetchPlaylist(uri string, headers string, imports map[string]string) (parser.Playlist, map[string]string, error) {
+	release := m.Limiter.acquire()
+	byts, err := download.GetWebSource(uri, headers, m.TimeOut)
+	release()
+	if err != nil {
+		return nil, nil, err
+	}
+	content, variables, err := parser.SubstituteVariables(tool.BytesToStr(byts), uri, imports)
+	if err != nil {
+		return nil, nil, err
+	}
+	playlist, err := parser.DecodePlaylist(content)
+	return playlist, variables, err
+}
@@ -0,0 +1,200 @@
+package live
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/xfy520/m3u8_cli/package/lang"
+	"github.com/xfy520/m3u8_cli/package/parser"
+)
+
+// 直播分片及本地列表存放目录
+const liveDir = "LIVE"
+
+// 下载队列长度，下载慢于刷新时刷新会在此阻塞
+const liveQueueSize = 256
+
+// 待下载的直播分片
+type liveSegment struct {
+	dsn    int64 // 分片所在的不连续序号
+	msn    int64
+	seg    *parser.Segment
+	start  int64
+	length int64
+}
+
+// 普通HLS直播录制，每半个目标时长刷新一次媒体列表，按不连续序号与分片序号去重后
+// 放入下载队列，由单独的协程按顺序下载并在每完成一个分片后更新本地m3u8
+type liveRecorder struct {
+	*recorder
+	lastDsn    int64   // 已放入队列的最后一个分片的不连续序号
+	queuedMsn  int64   // 已放入队列的最后一个分片的分片序号
+	queued     float64 // 已放入队列的时长
+	writtenDsn int64   // 已写入本地m3u8的最后一个分片的不连续序号
+}
+
+func NewLiveRecorder() *liveRecorder {
+	return &liveRecorder{
+		recorder:   newRecorder(liveDir, "live.m3u8"),
+		lastDsn:    -1,
+		queuedMsn:  -1,
+		writtenDsn: -1,
+	}
+}
+
+// 录制直到列表结束、达到录制时长或调用Stop，返回本地m3u8的file:地址
+func (r *liveRecorder) Record() (string, error) {
+	if err := os.MkdirAll(path.Join(r.DownDir, liveDir), os.ModePerm); err != nil {
+		return "", err
+	}
+	defer r.rollWg.Wait()
+	queue := make(chan liveSegment, liveQueueSize)
+	done := make(chan error, 1)
+	go func() {
+		done <- r.work(queue)
+	}()
+	err := r.refresh(queue)
+	close(queue)
+	if workErr := <-done; err == nil {
+		err = workErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return r.finish()
+}
+
+// 刷新媒体列表并将新分片放入下载队列
+func (r *liveRecorder) refresh(queue chan<- liveSegment) error {
+	first := true
+	for {
+		playlist, err := r.retryFetch(r.M3u8Url, r.TimeOut, false)
+		if err == errStopped {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if first {
+			// 默认从当前窗口的第一个分片开始录制，指定了开始时间时从该时间的分片开始
+			r.output.Version = playlist.Version
+			r.output.TargetDuration = playlist.TargetDuration
+			r.output.MediaSequence = playlist.MediaSequence
+			r.output.DiscontinuitySequence = playlist.DiscontinuitySequence
+			if !r.StartTime.IsZero() {
+				r.skipBefore(playlist, r.startIndex(playlist.Segments))
+			}
+			first = false
+		}
+		if r.enqueue(playlist, queue) || playlist.EndList {
+			return nil
+		}
+		interval := time.Duration(playlist.TargetDuration) * time.Second / 2
+		if interval <= 0 {
+			interval = time.Second
+		}
+		if r.wait(interval) {
+			return nil
+		}
+	}
+}
+
+// 将index之前的分片视为已放入队列，从窗口中第index个分片开始录制
+func (r *liveRecorder) skipBefore(playlist *parser.MediaPlaylist, index int) {
+	if index == 0 {
+		return
+	}
+	dsn := playlist.DiscontinuitySequence
+	for _, seg := range playlist.Segments[1:index] {
+		if seg.Discontinuity {
+			dsn++
+		}
+	}
+	r.lastDsn, r.queuedMsn = dsn, playlist.MediaSequence+int64(index-1)
+	r.output.MediaSequence = r.queuedMsn + 1
+	r.output.DiscontinuitySequence = dsn
+}
+
+// 将尚未录制的分片放入队列，达到录制时长时返回true
+func (r *liveRecorder) enqueue(playlist *parser.MediaPlaylist, queue chan<- liveSegment) bool {
+	dsn := playlist.DiscontinuitySequence
+	var start, length int64
+	for i, seg := range playlist.Segments {
+		msn := playlist.MediaSequence + int64(i)
+		if seg.Discontinuity && i > 0 {
+			dsn++
+		}
+		start, length = segmentRange(playlist.Segments, i, start, length)
+		// 推流重启后分片序号可能重置，但不连续序号会增加
+		if dsn < r.lastDsn || (dsn == r.lastDsn && msn <= r.queuedMsn) {
+			continue
+		}
+		if dsn == r.lastDsn && msn > r.queuedMsn+1 {
+			r.addGap(dsn, r.queuedMsn+1, msn-1, 0, gapReasonOutOfWindow)
+		}
+		if seg.Gap {
+			r.addGap(dsn, msn, msn, seg.Duration, gapReasonTag)
+			r.lastDsn, r.queuedMsn = dsn, msn
+			continue
+		}
+		select {
+		case queue <- liveSegment{dsn: dsn, msn: msn, seg: seg, start: start, length: length}:
+		case <-r.stop:
+			return true
+		}
+		r.lastDsn, r.queuedMsn = dsn, msn
+		r.queued += seg.Duration
+		if r.Duration > 0 && r.queued >= r.Duration {
+			return true
+		}
+	}
+	return false
+}
+
+// 按顺序下载队列中的分片，已移出窗口或重试后仍失败的分片记为缺失，写入本地m3u8出错或调用Stop后不再下载剩余分片
+func (r *liveRecorder) work(queue <-chan liveSegment) error {
+	var err error
+	for item := range queue {
+		if err != nil || r.stopped() {
+			continue
+		}
+		file := r.segmentPath(fmt.Sprintf("%d_%05d%s", item.dsn, item.msn, segmentExt(item.seg.URI)))
+		if err = r.download(r.resolve(item.seg.URI), file, item.start, item.length); err != nil {
+			// 单个分片下载失败不结束录制
+			reason := gapReasonNotFound
+			if !isNotFound(err) {
+				r.fail(err.Error())
+				reason = gapReasonFailed
+			}
+			r.addGap(item.dsn, item.msn, item.msn, item.seg.Duration, reason)
+			err = nil
+			continue
+		}
+		discontinuity := item.seg.Discontinuity || (r.lastMsn >= 0 && (item.dsn != r.writtenDsn || item.msn != r.lastMsn+1))
+		if err = r.addSegment(item.msn, item.seg, file, discontinuity); err != nil {
+			r.Stop()
+			continue
+		}
+		r.writtenDsn = item.dsn
+		r.info(fmt.Sprintf(lang.Lang.LiveRecorded, item.msn, r.recorded))
+	}
+	return err
+}
+
+// 分片的字节范围，未指定偏移时接续同一地址上一个分片的结尾，prevStart/prevLength为上一个分片的范围
+func segmentRange(segments []*parser.Segment, index int, prevStart int64, prevLength int64) (int64, int64) {
+	byteRange := segments[index].ByteRange
+	if byteRange == nil {
+		return 0, 0
+	}
+	switch {
+	case byteRange.Offset >= 0:
+		return byteRange.Offset, byteRange.Length
+	case index > 0 && segments[index-1].URI == segments[index].URI && prevLength > 0:
+		return prevStart + prevLength, byteRange.Length
+	default:
+		return 0, byteRange.Length
+	}
+}
@@ -0,0 +1,57 @@
+package live
+
+import (
+	"sync"
+	"time"
+)
+
+// 同时录制多个频道时共享的连接数与请求速率限制，为nil时不限制
+type limiter struct {
+	conns    chan struct{}
+	interval time.Duration // 两次请求之间的最小间隔
+	next     time.Time
+	mu       sync.Mutex
+}
+
+// maxConns为最大并发连接数，rate为每秒最多发起的请求数，为0时不限制
+func NewLimiter(maxConns int, rate float64) *limiter {
+	l := &limiter{}
+	if maxConns > 0 {
+		l.conns = make(chan struct{}, maxConns)
+	}
+	if rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return l
+}
+
+// 等待请求配额，不占用连接。用于阻塞刷新等由服务器挂起的请求，避免长时间占满连接
+func (l *limiter) throttle() {
+	if l == nil || l.interval <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(wait)
+}
+
+// 等待可用的连接与请求配额，返回释放连接的函数
+func (l *limiter) acquire() func() {
+	if l == nil {
+		return func() {}
+	}
+	l.throttle()
+	if l.conns == nil {
+		return func() {}
+	}
+	l.conns <- struct{}{}
+	return func() {
+		<-l.conns
+	}
+}
@@ -0,0 +1,267 @@
+package live
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xfy520/m3u8_cli/package/download/downloadManager"
+	"github.com/xfy520/m3u8_cli/package/lang"
+	"github.com/xfy520/m3u8_cli/package/parser"
+)
+
+var ErrNotLowLatency = errors.New("playlist is not low-latency hls")
+
+// 部分分片及拼接后的分片存放目录
+const llhlsDir = "LLHLS"
+
+// LL-HLS直播录制，使用 _HLS_msn/_HLS_part 阻塞刷新媒体列表，逐个下载部分分片，
+// 分片完成后拼接为完整分片，并生成本地m3u8交由原有的meta.json/合并流程处理
+type llhlsRecorder struct {
+	*recorder
+	playlist *parser.MediaPlaylist
+	parts    map[int64][]string // 已下载的部分分片，以分片序号为键，GAP部分分片为空字符串
+	firstMsn int64
+	nextMsn  int64
+	nextPart int64
+	skipped  bool // 上一个分片缺失，下一个分片前需要插入 #EXT-X-DISCONTINUITY
+}
+
+func NewLLHLSRecorder() *llhlsRecorder {
+	return &llhlsRecorder{
+		recorder: newRecorder(llhlsDir, "llhls.m3u8"),
+		parts:    map[int64][]string{},
+		firstMsn: -1,
+	}
+}
+
+// 录制直到列表结束、达到录制时长或调用Stop，返回本地m3u8的file:地址
+func (r *llhlsRecorder) Record() (string, error) {
+	if err := os.MkdirAll(path.Join(r.DownDir, llhlsDir), os.ModePerm); err != nil {
+		return "", err
+	}
+	defer r.rollWg.Wait()
+	for {
+		playlist, err := r.reload()
+		if err == errStopped {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if r.playlist == nil && playlist.PartInf == nil {
+			return "", ErrNotLowLatency
+		}
+		r.playlist = playlist
+		if err := r.update(); err != nil {
+			return "", err
+		}
+		if playlist.EndList || r.reachedDuration() || r.wait(r.reloadInterval()) {
+			break
+		}
+	}
+	return r.finish()
+}
+
+// 刷新媒体列表，服务器支持时请求下一个部分分片，由服务器阻塞到其可用后返回
+func (r *llhlsRecorder) reload() (*parser.MediaPlaylist, error) {
+	uri := r.M3u8Url
+	timeOut := r.TimeOut
+	blocking := r.playlist != nil && r.playlist.ServerControl != nil && r.playlist.ServerControl.CanBlockReload
+	if blocking {
+		uri = blockingReloadUrl(uri, r.nextMsn, r.nextPart)
+		// 服务器最多阻塞三倍目标时长
+		timeOut += time.Duration(r.playlist.TargetDuration * 3)
+	}
+	return r.retryFetch(uri, timeOut, blocking)
+}
+
+// 不支持阻塞刷新时按部分分片目标时长轮询
+func (r *llhlsRecorder) reloadInterval() time.Duration {
+	if r.playlist.ServerControl != nil && r.playlist.ServerControl.CanBlockReload {
+		return 0
+	}
+	if r.playlist.PartInf != nil && r.playlist.PartInf.PartTarget > 0 {
+		return time.Duration(r.playlist.PartInf.PartTarget * float64(time.Second))
+	}
+	return time.Duration(r.playlist.TargetDuration) * time.Second / 2
+}
+
+// 下载新出现的部分分片，拼接已完成的分片
+func (r *llhlsRecorder) update() error {
+	playlist := r.playlist
+	pendingMsn := playlist.MediaSequence + int64(len(playlist.Segments))
+	if r.firstMsn < 0 {
+		// 默认从最后一个完整分片开始录制，指定了开始时间时先录制窗口中该时间之后的分片
+		r.firstMsn = pendingMsn - 1
+		if !r.StartTime.IsZero() {
+			r.firstMsn = playlist.MediaSequence + int64(r.startIndex(playlist.Segments))
+		}
+		if r.firstMsn < playlist.MediaSequence {
+			r.firstMsn = playlist.MediaSequence
+		}
+		r.output.Version = playlist.Version
+		r.output.TargetDuration = playlist.TargetDuration
+		r.output.MediaSequence = r.firstMsn
+		r.output.DiscontinuitySequence = playlist.DiscontinuitySequence
+	}
+	var start, length int64
+	for i, seg := range playlist.Segments {
+		msn := playlist.MediaSequence + int64(i)
+		start, length = segmentRange(playlist.Segments, i, start, length)
+		if msn < r.firstMsn || msn <= r.lastMsn {
+			continue
+		}
+		if err := r.complete(msn, seg, start, length); err != nil {
+			return err
+		}
+		if r.reachedDuration() {
+			return nil
+		}
+	}
+	if pendingMsn >= r.firstMsn && !playlist.EndList {
+		r.downloadParts(pendingMsn, playlist.Parts)
+	}
+	r.nextMsn, r.nextPart = pendingMsn, int64(len(playlist.Parts))
+	return nil
+}
+
+// 按顺序下载尚未下载的部分分片
+func (r *llhlsRecorder) downloadParts(msn int64, parts []*parser.Part) {
+	for i := len(r.parts[msn]); i < len(parts); i++ {
+		file := ""
+		if !parts[i].Gap {
+			file = r.segmentPath(fmt.Sprintf("%d.%d.part", msn, i))
+			start, length := partRange(parts, i)
+			// 已移出窗口或下载失败的部分分片留空，拼接时改为下载完整分片
+			if err := r.download(r.resolve(parts[i].URI), file, start, length); err != nil {
+				if !isNotFound(err) {
+					r.fail(err.Error())
+				}
+				file = ""
+			}
+		}
+		r.parts[msn] = append(r.parts[msn], file)
+	}
+}
+
+// 分片完成后拼接部分分片，缺少部分分片时按start/length下载完整分片
+func (r *llhlsRecorder) complete(msn int64, seg *parser.Segment, start int64, length int64) error {
+	defer r.removeParts(msn)
+	if seg.Gap {
+		r.addGap(r.output.DiscontinuitySequence, msn, msn, seg.Duration, gapReasonTag)
+		r.lastMsn, r.skipped = msn, true
+		return nil
+	}
+	file := r.segmentPath(fmt.Sprintf("%05d%s", msn, segmentExt(seg.URI)))
+	// AES-128的部分分片各自加密，只能使用完整分片
+	assembled := false
+	if len(seg.Parts) > 0 && !isAES128(seg.Keys) {
+		r.downloadParts(msn, seg.Parts)
+		files := r.parts[msn]
+		if len(files) == len(seg.Parts) && !contains(files, "") {
+			if err := downloadManager.CombineFiles(files, file); err != nil {
+				return err
+			}
+			assembled = true
+		}
+	}
+	if !assembled {
+		if err := r.download(r.resolve(seg.URI), file, start, length); err != nil {
+			// 单个分片下载失败不结束录制
+			reason := gapReasonNotFound
+			if !isNotFound(err) {
+				r.fail(err.Error())
+				reason = gapReasonFailed
+			}
+			r.addGap(r.output.DiscontinuitySequence, msn, msn, seg.Duration, reason)
+			r.lastMsn, r.skipped = msn, true
+			return nil
+		}
+	}
+	discontinuity := seg.Discontinuity || r.skipped || (r.lastMsn >= 0 && msn != r.lastMsn+1)
+	if err := r.addSegment(msn, seg, file, discontinuity); err != nil {
+		return err
+	}
+	r.skipped = false
+	r.info(fmt.Sprintf(lang.Lang.LLHLSRecorded, msn, r.recorded))
+	return nil
+}
+
+func (r *llhlsRecorder) removeParts(msn int64) {
+	for _, file := range r.parts[msn] {
+		if file != "" {
+			os.Remove(file)
+		}
+	}
+	delete(r.parts, msn)
+}
+
+// 在列表地址上附加阻塞刷新参数
+func blockingReloadUrl(uri string, msn int64, part int64) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	query := u.Query()
+	query.Set("_HLS_msn", strconv.FormatInt(msn, 10))
+	query.Set("_HLS_part", strconv.FormatInt(part, 10))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// 部分分片的字节范围，未指定偏移时接续同一地址上一个部分分片的结尾
+func partRange(parts []*parser.Part, index int) (int64, int64) {
+	var start, length int64
+	for i := 0; i <= index; i++ {
+		byteRange := parts[i].ByteRange
+		if byteRange == nil {
+			start, length = 0, 0
+			continue
+		}
+		switch {
+		case byteRange.Offset >= 0:
+			start = byteRange.Offset
+		case i > 0 && parts[i-1].URI == parts[i].URI && length > 0:
+			start += length
+		default:
+			start = 0
+		}
+		length = byteRange.Length
+	}
+	return start, length
+}
+
+func isAES128(keys []*parser.Key) bool {
+	for _, key := range keys {
+		if key.Method == "AES-128" {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func segmentExt(uri string) string {
+	if u, err := url.Parse(uri); err == nil {
+		uri = u.Path
+	}
+	ext := path.Ext(uri)
+	if ext == "" || strings.Contains(ext, "/") {
+		return ".ts"
+	}
+	return ext
+}
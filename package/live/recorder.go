@@ -0,0 +1,405 @@
+package live
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xfy520/m3u8_cli/package/download"
+	"github.com/xfy520/m3u8_cli/package/lang"
+	"github.com/xfy520/m3u8_cli/package/log"
+	"github.com/xfy520/m3u8_cli/package/parser"
+	"github.com/xfy520/m3u8_cli/package/request"
+	"github.com/xfy520/m3u8_cli/package/tool"
+)
+
+// 刷新重试期间调用了Stop
+var errStopped = errors.New("recording stopped")
+
+// 刷新失败后的重试间隔从1秒开始翻倍，最长30秒
+const (
+	reloadBackoffMin = time.Second
+	reloadBackoffMax = 30 * time.Second
+)
+
+// 缺失分片的原因
+const (
+	gapReasonTag         = "gap tag"         // 列表中标记为 #EXT-X-GAP
+	gapReasonNotFound    = "not found"       // 分片已移出窗口，服务器返回404/410
+	gapReasonOutOfWindow = "out of window"   // 刷新间隔过长，分片未出现在列表中就已移出窗口
+	gapReasonFailed      = "download failed" // 重试后仍下载失败，如CDN短时间不可用
+)
+
+// 缺失分片报告的文件名，与meta.json位于同一目录
+const gapReportName = "gaps.json"
+
+// 录制中写出本地m3u8的最短间隔，长时间录制时避免每个分片都重写整个列表
+const playlistWriteInterval = 5 * time.Second
+
+// 缺失分片报告中连续缺失的一段分片
+type gapObj struct {
+	DiscontinuitySequence int64   `json:"discontinuitySequence"`
+	Start                 int64   `json:"start"` // 首尾分片序号
+	End                   int64   `json:"end"`
+	Duration              float64 `json:"duration"` // 移出窗口的分片时长未知，不计入
+	Reason                string  `json:"reason"`
+	Time                  string  `json:"time"` // 首次发现的时间
+}
+
+// 直播录制的公共部分：刷新媒体列表、下载分片，并在每完成一个分片后更新本地m3u8，
+// 录制中断时已完成的部分仍可以交由原有的meta.json/合并流程处理
+type recorder struct {
+	M3u8Url    string
+	Headers    string
+	DownDir    string
+	TimeOut    time.Duration
+	RetryCount int
+	Duration   float64           // 录制时长(秒)，为0时录制到 #EXT-X-ENDLIST
+	Variables  map[string]string // 主列表中定义的变量，用于 #EXT-X-DEFINE:IMPORT
+	// 从窗口中该时间的分片开始录制，早于窗口时从窗口的第一个分片开始，为零值时从默认位置开始
+	StartTime time.Time
+	// 媒体列表持续刷新失败超过此时长后结束录制
+	ReloadTimeout time.Duration
+	// 按时长(秒)、大小(字节)或整点间隔切分录制，每个分段交由OnRoll单独合并
+	RollDuration float64
+	RollSize     int64
+	RollInterval time.Duration
+	OnRoll       func(localUrl string, start time.Time) error
+	// 同时录制多个频道时的频道名、日志文件与共享的连接限制
+	Name     string
+	LogFile  string
+	Limiter  *limiter
+	dir      string // 分片与本地m3u8所在的目录名
+	name     string // 本地m3u8的文件名
+	output   *parser.MediaPlaylist
+	lastMsn  int64
+	recorded float64
+	// 上次写出本地m3u8的时间
+	writtenAt time.Time
+	stop      chan struct{}
+	stopOnce  sync.Once
+	gaps      []gapObj
+	gapsMu    sync.Mutex
+	// 当前分段的开始时间、时长、大小与分片文件
+	chunkStart    time.Time
+	chunkDuration float64
+	chunkSize     int64
+	chunkFiles    []string
+	rollWg        sync.WaitGroup
+	rollMu        sync.Mutex
+}
+
+// 按媒体列表是否为LL-HLS创建录制器，返回共用的录制设置与开始录制的函数
+func NewRecorder(lowLatency bool) (*recorder, func() (string, error)) {
+	if lowLatency {
+		llhls := NewLLHLSRecorder()
+		return llhls.recorder, llhls.Record
+	}
+	hls := NewLiveRecorder()
+	return hls.recorder, hls.Record
+}
+
+func newRecorder(dir string, name string) *recorder {
+	return &recorder{
+		TimeOut:       10,
+		RetryCount:    3,
+		ReloadTimeout: 10 * time.Minute,
+		dir:           dir,
+		name:          name,
+		output:        &parser.MediaPlaylist{},
+		lastMsn:       -1,
+		stop:          make(chan struct{}),
+	}
+}
+
+// 结束录制，已完成的分片仍会写入本地m3u8
+func (r *recorder) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+func (r *recorder) stopped() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *recorder) reachedDuration() bool {
+	return r.Duration > 0 && r.recorded >= r.Duration
+}
+
+// 等待下一次刷新，期间调用Stop时返回true
+func (r *recorder) wait(d time.Duration) bool {
+	select {
+	case <-r.stop:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+// 下载并解析媒体列表，阻塞刷新只受请求速率限制，不占用连接
+func (r *recorder) fetch(uri string, timeOut time.Duration, blocking bool) (*parser.MediaPlaylist, error) {
+	var byts []byte
+	var err error
+	if blocking {
+		r.Limiter.throttle()
+		byts, err = download.GetWebSource(uri, r.Headers, timeOut)
+	} else {
+		release := r.Limiter.acquire()
+		byts, err = download.GetWebSource(uri, r.Headers, timeOut)
+		release()
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, _, err := parser.SubstituteVariables(tool.BytesToStr(byts), r.M3u8Url, r.Variables)
+	if err != nil {
+		return nil, err
+	}
+	return parser.DecodeMediaPlaylist(content)
+}
+
+// 刷新媒体列表，失败时按退避间隔重试，直到成功、超过ReloadTimeout或调用Stop
+func (r *recorder) retryFetch(uri string, timeOut time.Duration, blocking bool) (*parser.MediaPlaylist, error) {
+	backoff := reloadBackoffMin
+	failedAt := time.Time{}
+	for {
+		playlist, err := r.fetch(uri, timeOut, blocking)
+		if err == nil {
+			return playlist, nil
+		}
+		if failedAt.IsZero() {
+			failedAt = time.Now()
+		} else if time.Since(failedAt) > r.ReloadTimeout {
+			return nil, err
+		}
+		r.warn(fmt.Sprintf(lang.Lang.LiveReloadRetry, backoff.Seconds()) + err.Error())
+		if r.wait(backoff) {
+			return nil, errStopped
+		}
+		if backoff *= 2; backoff > reloadBackoffMax {
+			backoff = reloadBackoffMax
+		}
+	}
+}
+
+// 记录缺失的分片，与上一段连续时合并，并更新缺失分片报告
+func (r *recorder) addGap(dsn int64, start int64, end int64, duration float64, reason string) {
+	r.gapsMu.Lock()
+	defer r.gapsMu.Unlock()
+	r.warn(fmt.Sprintf(lang.Lang.LiveGap, start, end, reason))
+	if n := len(r.gaps); n > 0 {
+		last := &r.gaps[n-1]
+		if last.DiscontinuitySequence == dsn && last.Reason == reason && last.End+1 == start {
+			last.End = end
+			last.Duration += duration
+			r.writeGaps()
+			return
+		}
+	}
+	r.gaps = append(r.gaps, gapObj{
+		DiscontinuitySequence: dsn,
+		Start:                 start,
+		End:                   end,
+		Duration:              duration,
+		Reason:                reason,
+		Time:                  time.Now().Format("2006-01-02 15:04:05.000"),
+	})
+	r.writeGaps()
+}
+
+// 写出缺失分片报告，每发现一段更新一次，录制中断时也能保留
+func (r *recorder) writeGaps() {
+	gaps := append([]gapObj{}, r.gaps...)
+	sort.SliceStable(gaps, func(i, j int) bool {
+		if gaps[i].DiscontinuitySequence != gaps[j].DiscontinuitySequence {
+			return gaps[i].DiscontinuitySequence < gaps[j].DiscontinuitySequence
+		}
+		return gaps[i].Start < gaps[j].Start
+	})
+	byts, err := json.Marshal(gaps)
+	if err != nil {
+		return
+	}
+	if err := writeFile(path.Join(r.DownDir, gapReportName), tool.BytesToStr(byts)); err != nil {
+		r.fail(err.Error())
+	}
+}
+
+// 输出到控制台，多频道录制时以频道名为前缀
+func (r *recorder) info(msg string) {
+	if r.Name != "" {
+		msg = "[" + r.Name + "] " + msg
+	}
+	log.Info(msg)
+}
+
+// 输出警告并写入日志，设置了LogFile时写入频道的日志文件
+func (r *recorder) warn(msg string) {
+	if r.Name != "" {
+		log.Warn("[" + r.Name + "] " + msg)
+	} else {
+		log.Warn(msg)
+	}
+	log.WriteInfoTo(r.LogFile, msg)
+}
+
+// 输出警告并写入错误日志
+func (r *recorder) fail(msg string) {
+	if r.Name != "" {
+		log.Warn("[" + r.Name + "] " + msg)
+	} else {
+		log.Warn(msg)
+	}
+	log.WriteErrorTo(r.LogFile, msg)
+}
+
+// 分片的保存路径
+func (r *recorder) segmentPath(name string) string {
+	return path.Join(r.DownDir, r.dir, name)
+}
+
+// 将已下载的分片写入本地m3u8，KEY与初始化分段仍使用原地址
+func (r *recorder) addSegment(msn int64, seg *parser.Segment, file string, discontinuity bool) error {
+	fileUrl, err := tool.PathToUrl(file)
+	if err != nil {
+		return err
+	}
+	out := &parser.Segment{
+		URI:             fileUrl,
+		Duration:        seg.Duration,
+		Title:           seg.Title,
+		Discontinuity:   discontinuity,
+		ProgramDateTime: seg.ProgramDateTime,
+		DateRanges:      seg.DateRanges,
+		Bitrate:         seg.Bitrate,
+		Tags:            seg.Tags,
+	}
+	for _, key := range seg.Keys {
+		k := *key
+		k.URI = r.resolve(k.URI)
+		// 本地列表中的序号会因跳过的分片与序号重置而与原列表不同，IV需按原序号写明
+		k.FillSequenceIV(msn)
+		out.Keys = append(out.Keys, &k)
+	}
+	if seg.Map != nil {
+		m := *seg.Map
+		m.URI = r.resolve(m.URI)
+		out.Map = &m
+	}
+	start := r.segmentTime(seg)
+	if r.shouldRoll(seg, start) {
+		if err := r.roll(); err != nil {
+			return err
+		}
+		out.Discontinuity = false
+	}
+	if len(r.output.Segments) == 0 && r.rolling() {
+		r.output.MediaSequence = msn
+	}
+	r.output.Segments = append(r.output.Segments, out)
+	if r.rolling() {
+		r.addChunkSegment(seg, file, start)
+	}
+	r.lastMsn = msn
+	r.recorded += seg.Duration
+	if time.Since(r.writtenAt) < playlistWriteInterval {
+		return nil
+	}
+	_, err = r.writePlaylist()
+	return err
+}
+
+// 写出本地m3u8，录制中每隔playlistWriteInterval更新一次，结束时再写出完整列表
+func (r *recorder) writePlaylist() (string, error) {
+	playlistPath := r.segmentPath(r.name)
+	if err := writeFile(playlistPath, r.output.Encode()); err != nil {
+		return "", err
+	}
+	r.writtenAt = time.Now()
+	return tool.PathToUrl(playlistPath)
+}
+
+// 先写入临时文件再重命名，写入中途退出时保留上一次完整的内容
+func writeFile(file string, text string) error {
+	tmpPath := file + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, tool.StrToBytes(text), 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, file)
+}
+
+// 下载到临时文件，完成后重命名
+func (r *recorder) download(uri string, file string, start int64, length int64) error {
+	var err error
+	for i := 0; i <= r.RetryCount; i++ {
+		if err = r.downloadOnce(uri, file, start, length); err == nil || isNotFound(err) {
+			return err
+		}
+		time.Sleep(time.Second)
+	}
+	return err
+}
+
+func (r *recorder) downloadOnce(uri string, file string, start int64, length int64) error {
+	release := r.Limiter.acquire()
+	defer release()
+	body, err := download.HttpDownloadStream(uri, r.Headers, r.TimeOut, start, length)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	tmpPath := file + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, body)
+	out.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return &download.DownloadError{Url: uri, Err: err}
+	}
+	return os.Rename(tmpPath, file)
+}
+
+// 相对地址以媒体列表地址为基准
+func (r *recorder) resolve(uri string) string {
+	return resolveUrl(r.M3u8Url, uri)
+}
+
+func resolveUrl(baseUrl string, uri string) string {
+	if uri == "" {
+		return uri
+	}
+	base, err := url.Parse(baseUrl)
+	if err != nil {
+		return uri
+	}
+	ref, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	return base.ResolveReference(ref).String()
+}
+
+// 分片已不存在，重试没有意义
+func isNotFound(err error) bool {
+	var statusErr *request.HTTPStatusError
+	return errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusGone)
+}
@@ -0,0 +1,157 @@
+package live
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/xfy520/m3u8_cli/package/lang"
+	"github.com/xfy520/m3u8_cli/package/log"
+	"github.com/xfy520/m3u8_cli/package/parser"
+	"github.com/xfy520/m3u8_cli/package/tool"
+)
+
+// 分段文件名中开始时间的格式
+const chunkTimeLayout = "2006-01-02_15-04-05"
+
+// 是否按时长、大小或整点切分录制
+func (r *recorder) rolling() bool {
+	return r.OnRoll != nil && (r.RollDuration > 0 || r.RollSize > 0 || r.RollInterval > 0)
+}
+
+// 分片的开始时间，没有 #EXT-X-PROGRAM-DATE-TIME 时按当前分段已录制的时长推算
+func (r *recorder) segmentTime(seg *parser.Segment) time.Time {
+	if seg.ProgramDateTime != "" {
+		if t, err := time.Parse(time.RFC3339Nano, seg.ProgramDateTime); err == nil {
+			return t
+		}
+	}
+	if r.chunkStart.IsZero() {
+		return time.Now()
+	}
+	return r.chunkStart.Add(time.Duration(r.chunkDuration * float64(time.Second)))
+}
+
+// 加入下一个分片前是否需要结束当前分段
+func (r *recorder) shouldRoll(seg *parser.Segment, start time.Time) bool {
+	if !r.rolling() || len(r.output.Segments) == 0 {
+		return false
+	}
+	switch {
+	case r.RollDuration > 0 && r.chunkDuration+seg.Duration > r.RollDuration+0.001:
+		return true
+	case r.RollSize > 0 && r.chunkSize >= r.RollSize:
+		return true
+	case r.RollInterval > 0 && !clockBoundary(r.chunkStart, r.RollInterval).Equal(clockBoundary(start, r.RollInterval)):
+		return true
+	}
+	return false
+}
+
+// 记录当前分段的时长、大小与分片文件
+func (r *recorder) addChunkSegment(seg *parser.Segment, file string, start time.Time) {
+	if r.chunkStart.IsZero() {
+		r.chunkStart = start
+	}
+	r.chunkDuration += seg.Duration
+	if info, err := os.Stat(file); err == nil {
+		r.chunkSize += info.Size()
+	}
+	r.chunkFiles = append(r.chunkFiles, file)
+}
+
+// 结束当前分段，写出其本地m3u8并在后台交由OnRoll合并，合并成功后删除分段的分片。
+// 各分段按顺序合并，合并失败时保留分片以便手动处理
+func (r *recorder) roll() error {
+	r.output.EndList = true
+	start := r.chunkStart
+	playlistPath := r.segmentPath("chunk_" + start.Local().Format(chunkTimeLayout) + ".m3u8")
+	if err := writeFile(playlistPath, r.output.Encode()); err != nil {
+		return err
+	}
+	localUrl, err := tool.PathToUrl(playlistPath)
+	if err != nil {
+		return err
+	}
+	files := r.chunkFiles
+	r.output = &parser.MediaPlaylist{
+		Version:        r.output.Version,
+		TargetDuration: r.output.TargetDuration,
+	}
+	r.chunkStart, r.chunkDuration, r.chunkSize, r.chunkFiles = time.Time{}, 0, 0, nil
+	r.rollWg.Add(1)
+	go func() {
+		defer r.rollWg.Done()
+		r.rollMu.Lock()
+		defer r.rollMu.Unlock()
+		r.warn(fmt.Sprintf(lang.Lang.LiveChunkFinished, start.Local().Format(chunkTimeLayout)))
+		if err := r.OnRoll(localUrl, start); err != nil {
+			r.fail(err.Error())
+			return
+		}
+		for _, file := range files {
+			os.Remove(file)
+		}
+		os.Remove(playlistPath)
+	}()
+	return nil
+}
+
+// 录制结束时写出本地m3u8；切分录制时合并最后一个分段并等待全部分段合并完成，返回空地址
+func (r *recorder) finish() (string, error) {
+	if !r.rolling() {
+		r.output.EndList = true
+		return r.writePlaylist()
+	}
+	if len(r.output.Segments) > 0 {
+		if err := r.roll(); err != nil {
+			return "", err
+		}
+	}
+	r.rollWg.Wait()
+	return "", nil
+}
+
+// 按本地时区对齐的整点边界，如每小时、每天零点
+func clockBoundary(t time.Time, interval time.Duration) time.Time {
+	_, offset := t.Zone()
+	zone := time.Duration(offset) * time.Second
+	return t.Add(zone).Truncate(interval).Add(-zone)
+}
+
+// 以开始时间命名的分段名
+func ChunkName(prefix string, start time.Time) string {
+	return prefix + "_" + start.Local().Format(chunkTimeLayout)
+}
+
+// 删除dir中以prefix命名、开始时间早于retention之前的分段文件及目录
+func RemoveExpiredChunks(dir string, prefix string, retention time.Duration) error {
+	if retention <= 0 {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(-retention)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix+"_") || len(name) < len(prefix)+1+len(chunkTimeLayout) {
+			continue
+		}
+		value := name[len(prefix)+1 : len(prefix)+1+len(chunkTimeLayout)]
+		start, err := time.ParseInLocation(chunkTimeLayout, value, time.Local)
+		if err != nil || !start.Before(deadline) {
+			continue
+		}
+		log.Info(lang.Lang.LiveChunkExpired + name)
+		log.WriteInfo(lang.Lang.LiveChunkExpired + name)
+		if err := os.RemoveAll(path.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package live
+
+import (
+	"time"
+
+	"github.com/xfy520/m3u8_cli/package/lang"
+	"github.com/xfy520/m3u8_cli/package/parser"
+)
+
+// 窗口中第一个结束时间晚于StartTime的分片下标，StartTime晚于窗口时返回分片数。
+// 分片的时间按 #EXT-X-PROGRAM-DATE-TIME 推算，整个窗口都没有时以窗口末尾为当前时间向前推算
+func (r *recorder) startIndex(segments []*parser.Segment) int {
+	if len(segments) == 0 {
+		return 0
+	}
+	times := segmentTimes(segments)
+	if r.StartTime.Before(times[0]) {
+		r.warn(lang.Lang.LiveStartBeforeWindow + times[0].Local().Format("2006-01-02 15:04:05"))
+		return 0
+	}
+	for i, seg := range segments {
+		if times[i].Add(time.Duration(seg.Duration * float64(time.Second))).After(r.StartTime) {
+			return i
+		}
+	}
+	return len(segments)
+}
+
+// 各分片的开始时间，没有 #EXT-X-PROGRAM-DATE-TIME 的分片按前后分片的时间与时长推算
+func segmentTimes(segments []*parser.Segment) []time.Time {
+	times := make([]time.Time, len(segments))
+	first := -1
+	for i, seg := range segments {
+		if seg.ProgramDateTime == "" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339Nano, seg.ProgramDateTime); err == nil {
+			times[i] = t
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+	if first < 0 {
+		// 没有时间时视为窗口末尾为当前时间
+		var total float64
+		for _, seg := range segments {
+			total += seg.Duration
+		}
+		first = 0
+		times[0] = time.Now().Add(-time.Duration(total * float64(time.Second)))
+	}
+	for i := first - 1; i >= 0; i-- {
+		times[i] = times[i+1].Add(-time.Duration(segments[i].Duration * float64(time.Second)))
+	}
+	for i := first + 1; i < len(segments); i++ {
+		if times[i].IsZero() {
+			times[i] = times[i-1].Add(time.Duration(segments[i-1].Duration * float64(time.Second)))
+		}
+	}
+	return times
+}
@@ -55,25 +55,32 @@ func Error(msg ...string) {
 }
 
 func InitLog(command string) error {
-	logDir := path.Dir(LogFile)
+	logFile, err := NewLogFile(LogFile, command)
+	LogFile = logFile
+	return err
+}
+
+// 新建日志文件，文件已存在时在文件名后添加序号，返回实际使用的文件
+func NewLogFile(logFile string, command string) (string, error) {
+	logDir := path.Dir(logFile)
 	if !Exists(logDir) {
 		err := os.MkdirAll(logDir, os.ModePerm)
 		if err != nil {
-			return err
+			return logFile, err
 		}
 	}
 	num := 1
-	filenameall := path.Base(LogFile)
-	filesuffix := path.Ext(LogFile)
+	filenameall := path.Base(logFile)
+	filesuffix := path.Ext(logFile)
 	fileName := filenameall[0 : len(filenameall)-len(filesuffix)]
 	for {
-		if !Exists(LogFile) {
+		if !Exists(logFile) {
 			break
 		}
-		LogFile = path.Join(logDir, fileName+"-"+string(rune(num)))
+		logFile = path.Join(logDir, fileName+"-"+string(rune(num)))
 		num += 1
 	}
-	filePath := LogFile
+	filePath := logFile
 	logs := []string{
 		"Log " + time.Now().Format("2006-01-02") + "\r\n",
 		"Save Path: " + logDir + "\r\n",
@@ -88,18 +95,18 @@ func InitLog(command string) error {
 		file, err = os.Create(filePath)
 	}
 	if err != nil {
-		return err
+		return logFile, err
 	}
 	_, err = io.WriteString(file, strings.Join(logs, ""))
 	defer file.Close()
-	return err
+	return logFile, err
 }
 
-func writeLine(log []string, msg string) error {
-	if !Exists(LogFile) {
+func writeLine(logFile string, log []string, msg string) error {
+	if !Exists(logFile) {
 		return nil
 	}
-	filePath := LogFile
+	filePath := logFile
 	countGuard.Lock()
 	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, os.ModePerm)
 	if err != nil {
@@ -114,11 +121,26 @@ func writeLine(log []string, msg string) error {
 }
 
 func WriteError(log ...string) error {
-	return writeLine(log, "ERROR")
+	return writeLine(LogFile, log, "ERROR")
 }
 
 func WriteInfo(log ...string) error {
-	return writeLine(log, "INFO")
+	return writeLine(LogFile, log, "INFO")
+}
+
+// 写入指定的日志文件，用于同时录制多个频道时各频道单独记录，为空时写入全局日志
+func WriteErrorTo(logFile string, log ...string) error {
+	if logFile == "" {
+		logFile = LogFile
+	}
+	return writeLine(logFile, log, "ERROR")
+}
+
+func WriteInfoTo(logFile string, log ...string) error {
+	if logFile == "" {
+		logFile = LogFile
+	}
+	return writeLine(logFile, log, "INFO")
 }
 
 func Exists(path string) bool {
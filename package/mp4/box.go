@@ -0,0 +1,150 @@
+package mp4
+
+import (
+	"encoding/binary"
+)
+
+// box在数据中的位置
+type Box struct {
+	Type   string
+	Offset int
+	Header int
+	Size   int
+}
+
+// box内容(不含头部)
+func (b Box) Body(data []byte) []byte {
+	return data[b.Offset+b.Header : b.Offset+b.Size]
+}
+
+// box内容起始位置
+func (b Box) Start() int {
+	return b.Offset + b.Header
+}
+
+// box结束位置
+func (b Box) End() int {
+	return b.Offset + b.Size
+}
+
+// 读取data[start:end]范围内同一层级的所有box
+func ReadBoxes(data []byte, start int, end int) []Box {
+	boxes := []Box{}
+	if end > len(data) {
+		end = len(data)
+	}
+	for start+8 <= end {
+		size := int(binary.BigEndian.Uint32(data[start:]))
+		header := 8
+		if size == 1 {
+			if start+16 > end {
+				break
+			}
+			size = int(binary.BigEndian.Uint64(data[start+8:]))
+			header = 16
+		} else if size == 0 {
+			size = end - start
+		}
+		if size < header || start+size > end {
+			break
+		}
+		boxes = append(boxes, Box{Type: string(data[start+4 : start+8]), Offset: start, Header: header, Size: size})
+		start += size
+	}
+	return boxes
+}
+
+// 读取box的子box
+func Children(data []byte, parent Box) []Box {
+	return ReadBoxes(data, parent.Start(), parent.End())
+}
+
+// 在子box中查找指定类型
+func Child(data []byte, parent Box, boxType string) (Box, bool) {
+	for _, box := range Children(data, parent) {
+		if box.Type == boxType {
+			return box, true
+		}
+	}
+	return Box{}, false
+}
+
+// 按路径查找box，如 Find(data, "moov", "trak")
+func Find(data []byte, path ...string) (Box, bool) {
+	boxes := ReadBoxes(data, 0, len(data))
+	var found Box
+	for _, boxType := range path {
+		ok := false
+		for _, box := range boxes {
+			if box.Type == boxType {
+				found, ok = box, true
+				break
+			}
+		}
+		if !ok {
+			return Box{}, false
+		}
+		boxes = Children(data, found)
+	}
+	return found, len(path) > 0
+}
+
+// 改写box类型，长度不变
+func Rename(data []byte, box Box, boxType string) {
+	copy(data[box.Offset+4:box.Offset+8], boxType)
+}
+
+// sidx中的一个分段引用
+type SidxReference struct {
+	Size     int64
+	Duration int64
+}
+
+// sidx内容，Anchor为sidx之后第一个字节相对于data的偏移
+type Sidx struct {
+	Timescale   int64
+	FirstOffset int64
+	Anchor      int64
+	References  []SidxReference
+}
+
+// 解析data中第一个sidx box
+func ReadSidx(data []byte) (*Sidx, bool) {
+	box, ok := Find(data, "sidx")
+	if !ok {
+		return nil, false
+	}
+	body := box.Body(data)
+	if len(body) < 12 {
+		return nil, false
+	}
+	sidx := &Sidx{Anchor: int64(box.End())}
+	sidx.Timescale = int64(binary.BigEndian.Uint32(body[8:]))
+	pos := 12
+	if body[0] == 0 {
+		if len(body) < pos+8 {
+			return nil, false
+		}
+		sidx.FirstOffset = int64(binary.BigEndian.Uint32(body[pos+4:]))
+		pos += 8
+	} else {
+		if len(body) < pos+16 {
+			return nil, false
+		}
+		sidx.FirstOffset = int64(binary.BigEndian.Uint64(body[pos+8:]))
+		pos += 16
+	}
+	if len(body) < pos+4 {
+		return nil, false
+	}
+	count := int(binary.BigEndian.Uint16(body[pos+2:]))
+	pos += 4
+	for i := 0; i < count && pos+12 <= len(body); i++ {
+		sidx.References = append(sidx.References, SidxReference{
+			Size:     int64(binary.BigEndian.Uint32(body[pos:]) & 0x7fffffff),
+			Duration: int64(binary.BigEndian.Uint32(body[pos+4:])),
+		})
+		pos += 12
+	}
+	return sidx, true
+}
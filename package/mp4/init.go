@@ -0,0 +1,260 @@
+package mp4
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUnsupportedCodec = errors.New("unsupported codec")
+	ErrCodecPrivateData = errors.New("invalid codec private data")
+)
+
+// 生成初始化分段所需的轨道信息
+type TrackInfo struct {
+	TrackID       uint32
+	Type          string // video 或 audio
+	Codec         string // avc1、hvc1、mp4a、ac-3、ec-3
+	Timescale     uint32
+	Width         int
+	Height        int
+	Channels      int
+	SampleRate    int
+	BitsPerSample int
+	Language      string
+	CodecPrivate  []byte
+}
+
+// 矩阵单位阵
+var unityMatrix = []byte{
+	0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0x40, 0, 0, 0,
+}
+
+// 生成仅含一个轨道的fMP4初始化分段(ftyp+moov)
+func BuildInit(track *TrackInfo) ([]byte, error) {
+	sampleEntry, err := buildSampleEntry(track)
+	if err != nil {
+		return nil, err
+	}
+	trackID := track.TrackID
+	if trackID == 0 {
+		trackID = 1
+	}
+	ftyp := MakeBox("ftyp", []byte("isom"), u32(1), []byte("isomiso6mp41dash"))
+	mvhd := MakeFullBox("mvhd", 0, 0,
+		u32(0), u32(0), u32(track.Timescale), u32(0),
+		u32(0x00010000), u16(0x0100), zeros(10), unityMatrix, zeros(24), u32(trackID+1))
+
+	volume, width, height := 0, 0, 0
+	handler, handlerName := "vide", "VideoHandler"
+	mediaHeader := MakeFullBox("vmhd", 0, 1, zeros(8))
+	if track.Type == "audio" {
+		volume = 0x0100
+		handler, handlerName = "soun", "SoundHandler"
+		mediaHeader = MakeFullBox("smhd", 0, 0, zeros(4))
+	} else {
+		width, height = track.Width, track.Height
+	}
+	tkhd := MakeFullBox("tkhd", 0, 7,
+		u32(0), u32(0), u32(trackID), zeros(4), u32(0), zeros(8),
+		u16(0), u16(0), u16(volume), zeros(2), unityMatrix,
+		u32(uint32(width)<<16), u32(uint32(height)<<16))
+	mdhd := MakeFullBox("mdhd", 0, 0, u32(0), u32(0), u32(track.Timescale), u32(0), u16(packLanguage(track.Language)), u16(0))
+	hdlr := MakeFullBox("hdlr", 0, 0, zeros(4), []byte(handler), zeros(12), []byte(handlerName), zeros(1))
+	dinf := MakeBox("dinf", MakeFullBox("dref", 0, 0, u32(1), MakeFullBox("url ", 0, 1)))
+	stbl := MakeBox("stbl",
+		MakeFullBox("stsd", 0, 0, u32(1), sampleEntry),
+		MakeFullBox("stts", 0, 0, u32(0)),
+		MakeFullBox("stsc", 0, 0, u32(0)),
+		MakeFullBox("stsz", 0, 0, u32(0), u32(0)),
+		MakeFullBox("stco", 0, 0, u32(0)))
+	trak := MakeBox("trak", tkhd, MakeBox("mdia", mdhd, hdlr, MakeBox("minf", mediaHeader, dinf, stbl)))
+	mvex := MakeBox("mvex", MakeFullBox("trex", 0, 0, u32(trackID), u32(1), u32(0), u32(0), u32(0)))
+	return append(ftyp, MakeBox("moov", mvhd, trak, mvex)...), nil
+}
+
+func buildSampleEntry(track *TrackInfo) ([]byte, error) {
+	switch track.Codec {
+	case "avc1":
+		config, err := AVCConfig(track.CodecPrivate)
+		if err != nil {
+			return nil, err
+		}
+		return videoSampleEntry("avc1", track, MakeBox("avcC", config)), nil
+	case "hvc1":
+		config, err := HEVCConfig(track.CodecPrivate)
+		if err != nil {
+			return nil, err
+		}
+		return videoSampleEntry("hvc1", track, MakeBox("hvcC", config)), nil
+	case "mp4a":
+		config := track.CodecPrivate
+		if len(config) == 0 {
+			config = AACConfig(2, track.SampleRate, track.Channels)
+		}
+		return audioSampleEntry("mp4a", track, esds(config)), nil
+	case "ac-3":
+		return audioSampleEntry("ac-3", track, MakeBox("dac3", track.CodecPrivate)), nil
+	case "ec-3":
+		return audioSampleEntry("ec-3", track, MakeBox("dec3", track.CodecPrivate)), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedCodec, track.Codec)
+}
+
+func videoSampleEntry(codec string, track *TrackInfo, config []byte) []byte {
+	return MakeBox(codec,
+		zeros(6), u16(1), zeros(16),
+		u16(track.Width), u16(track.Height),
+		u32(0x00480000), u32(0x00480000), zeros(4), u16(1),
+		zeros(32), u16(0x0018), u16(0xffff),
+		config)
+}
+
+func audioSampleEntry(codec string, track *TrackInfo, config []byte) []byte {
+	bits := track.BitsPerSample
+	if bits == 0 {
+		bits = 16
+	}
+	return MakeBox(codec,
+		zeros(6), u16(1), zeros(8),
+		u16(track.Channels), u16(bits), zeros(4),
+		u32(uint32(track.SampleRate)<<16),
+		config)
+}
+
+// esds描述AAC的AudioSpecificConfig
+func esds(config []byte) []byte {
+	descriptor := func(tag byte, payload ...[]byte) []byte {
+		size := 0
+		for _, p := range payload {
+			size += len(p)
+		}
+		out := []byte{tag, byte(size)}
+		for _, p := range payload {
+			out = append(out, p...)
+		}
+		return out
+	}
+	decoderConfig := descriptor(0x04, u8(0x40), u8(0x15), u24(0), u32(0), u32(0), descriptor(0x05, config))
+	return MakeFullBox("esds", 0, 0, descriptor(0x03, u16(1), u8(0), decoderConfig, descriptor(0x06, u8(0x02))))
+}
+
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// 根据采样率与声道数生成AudioSpecificConfig
+func AACConfig(objectType int, sampleRate int, channels int) []byte {
+	index := 4
+	for i, rate := range aacSampleRates {
+		if rate == sampleRate {
+			index = i
+		}
+	}
+	return []byte{byte(objectType<<3 | index>>1), byte((index&1)<<7 | channels<<3)}
+}
+
+// 拆分Annex B格式的NAL单元
+func SplitNALUnits(data []byte) [][]byte {
+	units := [][]byte{}
+	start := -1
+	for i := 0; i+3 <= len(data); i++ {
+		if data[i] == 0 && data[i+1] == 0 && data[i+2] == 1 {
+			if start >= 0 {
+				end := i
+				for end > start && data[end-1] == 0 {
+					end--
+				}
+				units = append(units, data[start:end])
+			}
+			start = i + 3
+			i += 2
+		}
+	}
+	if start >= 0 && start < len(data) {
+		units = append(units, data[start:])
+	}
+	return units
+}
+
+// 由SPS/PPS生成avcC
+func AVCConfig(codecPrivate []byte) ([]byte, error) {
+	var sps, pps [][]byte
+	for _, nal := range SplitNALUnits(codecPrivate) {
+		switch nal[0] & 0x1f {
+		case 7:
+			sps = append(sps, nal)
+		case 8:
+			pps = append(pps, nal)
+		}
+	}
+	if len(sps) == 0 || len(sps[0]) < 4 {
+		return nil, ErrCodecPrivateData
+	}
+	config := []byte{1, sps[0][1], sps[0][2], sps[0][3], 0xff, 0xe0 | byte(len(sps))}
+	for _, nal := range sps {
+		config = append(append(config, u16(len(nal))...), nal...)
+	}
+	config = append(config, byte(len(pps)))
+	for _, nal := range pps {
+		config = append(append(config, u16(len(nal))...), nal...)
+	}
+	return config, nil
+}
+
+// 由VPS/SPS/PPS生成hvcC，profile信息取自SPS中的profile_tier_level
+func HEVCConfig(codecPrivate []byte) ([]byte, error) {
+	arrays := map[byte][][]byte{}
+	for _, nal := range SplitNALUnits(codecPrivate) {
+		if len(nal) < 2 {
+			continue
+		}
+		nalType := (nal[0] >> 1) & 0x3f
+		arrays[nalType] = append(arrays[nalType], nal)
+	}
+	sps := arrays[33]
+	if len(sps) == 0 || len(sps[0]) < 15 {
+		return nil, ErrCodecPrivateData
+	}
+	// NAL头2字节，之后1字节为vps_id/max_sub_layers/temporal_id_nesting，随后12字节为general profile
+	profile := sps[0][3:15]
+	config := []byte{1}
+	config = append(config, profile...)
+	config = append(config, 0xf0, 0x00, 0xfc, 0xfd, 0xf8, 0xf8, 0x00, 0x00, 0x0f)
+	count := 0
+	for _, nalType := range []byte{32, 33, 34} {
+		if len(arrays[nalType]) > 0 {
+			count++
+		}
+	}
+	config = append(config, byte(count))
+	for _, nalType := range []byte{32, 33, 34} {
+		if len(arrays[nalType]) == 0 {
+			continue
+		}
+		config = append(config, 0x80|nalType)
+		config = append(config, u16(len(arrays[nalType]))...)
+		for _, nal := range arrays[nalType] {
+			config = append(append(config, u16(len(nal))...), nal...)
+		}
+	}
+	return config, nil
+}
+
+// ISO-639-2语言代码打包为15位
+func packLanguage(language string) int {
+	language = strings.ToLower(language)
+	if len(language) != 3 {
+		language = "und"
+	}
+	value := 0
+	for i := 0; i < 3; i++ {
+		c := int(language[i]) - 0x60
+		if c < 1 || c > 26 {
+			return packLanguage("und")
+		}
+		value = value<<5 | c
+	}
+	return value
+}
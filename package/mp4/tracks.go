@@ -0,0 +1,93 @@
+package mp4
+
+import (
+	"encoding/binary"
+)
+
+// 读取moov中各轨道的基本信息，加密轨道的编码取自frma
+func ReadTracks(data []byte) []TrackInfo {
+	tracks := []TrackInfo{}
+	moov, ok := Find(data, "moov")
+	if !ok {
+		return tracks
+	}
+	for _, trak := range Children(data, moov) {
+		if trak.Type != "trak" {
+			continue
+		}
+		track := TrackInfo{}
+		if tkhd, ok := Child(data, trak, "tkhd"); ok {
+			body := tkhd.Body(data)
+			if len(body) > 0 && body[0] == 1 && len(body) >= 96 {
+				track.TrackID = binary.BigEndian.Uint32(body[20:])
+				track.Width = int(binary.BigEndian.Uint32(body[88:]) >> 16)
+				track.Height = int(binary.BigEndian.Uint32(body[92:]) >> 16)
+			} else if len(body) >= 84 {
+				track.TrackID = binary.BigEndian.Uint32(body[12:])
+				track.Width = int(binary.BigEndian.Uint32(body[76:]) >> 16)
+				track.Height = int(binary.BigEndian.Uint32(body[80:]) >> 16)
+			}
+		}
+		mdia, ok := Child(data, trak, "mdia")
+		if !ok {
+			continue
+		}
+		if mdhd, ok := Child(data, mdia, "mdhd"); ok {
+			body := mdhd.Body(data)
+			if len(body) > 0 && body[0] == 1 && len(body) >= 24 {
+				track.Timescale = binary.BigEndian.Uint32(body[20:])
+			} else if len(body) >= 16 {
+				track.Timescale = binary.BigEndian.Uint32(body[12:])
+			}
+		}
+		if hdlr, ok := Child(data, mdia, "hdlr"); ok {
+			body := hdlr.Body(data)
+			if len(body) >= 12 {
+				switch string(body[8:12]) {
+				case "vide":
+					track.Type = "video"
+				case "soun":
+					track.Type = "audio"
+				default:
+					track.Type = string(body[8:12])
+				}
+			}
+		}
+		track.Codec = sampleEntryCodec(data, mdia)
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
+
+// stsd中第一个样本描述的类型
+func sampleEntryCodec(data []byte, mdia Box) string {
+	box := mdia
+	for _, boxType := range []string{"minf", "stbl", "stsd"} {
+		child, ok := Child(data, box, boxType)
+		if !ok {
+			return ""
+		}
+		box = child
+	}
+	entries := ReadBoxes(data, box.Start()+8, box.End())
+	if len(entries) == 0 {
+		return ""
+	}
+	entry := entries[0]
+	if entry.Type != "encv" && entry.Type != "enca" {
+		return entry.Type
+	}
+	skip := 78
+	if entry.Type == "enca" {
+		skip = 28
+	}
+	for _, sinf := range ReadBoxes(data, entry.Start()+skip, entry.End()) {
+		if sinf.Type != "sinf" {
+			continue
+		}
+		if frma, ok := Child(data, sinf, "frma"); ok && len(frma.Body(data)) >= 4 {
+			return string(frma.Body(data)[:4])
+		}
+	}
+	return entry.Type
+}
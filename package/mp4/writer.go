@@ -0,0 +1,50 @@
+package mp4
+
+import (
+	"encoding/binary"
+)
+
+// 生成box，内容按顺序拼接
+func MakeBox(boxType string, payloads ...[]byte) []byte {
+	size := 8
+	for _, payload := range payloads {
+		size += len(payload)
+	}
+	box := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(box, uint32(size))
+	copy(box[4:], boxType)
+	for _, payload := range payloads {
+		box = append(box, payload...)
+	}
+	return box
+}
+
+// 生成带version与flags的full box
+func MakeFullBox(boxType string, version byte, flags uint32, payloads ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return MakeBox(boxType, append([][]byte{header}, payloads...)...)
+}
+
+func u8(v int) []byte {
+	return []byte{byte(v)}
+}
+
+func u16(v int) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, uint16(v))
+	return b
+}
+
+func u24(v int) []byte {
+	return []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func zeros(n int) []byte {
+	return make([]byte, n)
+}
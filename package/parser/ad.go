@@ -0,0 +1,153 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/xfy520/m3u8_cli/package/tool"
+)
+
+// 广告处理方式
+const (
+	AdPolicyKeep  = "keep"  // 保留广告分片
+	AdPolicyStrip = "strip" // 删除广告分片
+	AdPolicyMark  = "mark"  // 保留广告分片，在meta.json中标记并在合并时写入章节
+)
+
+// 判断 --adPolicy 取值是否有效
+func IsAdPolicy(policy string) bool {
+	return policy == AdPolicyKeep || policy == AdPolicyStrip || policy == AdPolicyMark
+}
+
+// meta.json中的广告时段，StartIndex/EndIndex为首尾分片的序号
+type adBreakObj struct {
+	Id              string  `json:"id,omitempty"`
+	Source          string  `json:"source"`
+	StartIndex      int64   `json:"startIndex"`
+	EndIndex        int64   `json:"endIndex"`
+	Count           int64   `json:"count"`
+	Duration        float64 `json:"duration"`
+	PlannedDuration float64 `json:"plannedDuration,omitempty"`
+	Scte35          string  `json:"scte35,omitempty"`
+	Stripped        bool    `json:"stripped,omitempty"`
+}
+
+// 根据 CUE-OUT/CUE-IN、SCTE-35 与 DATERANGE 标记记录广告时段
+type adTracker struct {
+	breaks  []*adBreakObj
+	current *adBreakObj
+	scte35  string // 位于广告开始标记之前的SCTE-35信息
+}
+
+func newAdTracker() *adTracker {
+	return &adTracker{}
+}
+
+// 广告开始，已处于广告中时视为同一广告的重复标记
+func (a *adTracker) cueOut(source string, id string, planned float64, scte35 string) {
+	if scte35 == "" {
+		scte35 = a.scte35
+	}
+	a.scte35 = ""
+	if a.current != nil {
+		if a.current.Id == "" {
+			a.current.Id = id
+		}
+		if a.current.PlannedDuration == 0 {
+			a.current.PlannedDuration = planned
+		}
+		if a.current.Scte35 == "" {
+			a.current.Scte35 = scte35
+		}
+		return
+	}
+	a.current = &adBreakObj{Id: id, Source: source, StartIndex: -1, EndIndex: -1, PlannedDuration: planned, Scte35: scte35}
+	a.breaks = append(a.breaks, a.current)
+}
+
+// 广告持续，直播从广告中途开始时以此作为广告开始
+func (a *adTracker) cueCont(source string, planned float64, scte35 string) {
+	if a.current == nil && len(a.breaks) == 0 {
+		a.cueOut(source, "", planned, scte35)
+	}
+}
+
+// 广告结束，id不为空时只结束对应的广告
+func (a *adTracker) cueIn(id string) {
+	if a.current == nil || (id != "" && a.current.Id != "" && a.current.Id != id) {
+		return
+	}
+	a.current = nil
+}
+
+// SCTE35-OUT开始广告，SCTE35-IN结束广告，其余同ID的DATERANGE用于补充时长
+func (a *adTracker) dateRange(dateRange *DateRange) {
+	duration := dateRange.Duration
+	if duration == 0 {
+		duration = dateRange.PlannedDuration
+	}
+	switch {
+	case dateRange.SCTE35Out != "":
+		a.cueOut("daterange", dateRange.ID, duration, dateRange.SCTE35Out)
+	case dateRange.SCTE35In != "":
+		a.cueIn(dateRange.ID)
+	case a.current != nil && a.current.Id == dateRange.ID && duration > 0:
+		a.current.PlannedDuration = duration
+	}
+}
+
+// 记录分片，返回该分片是否属于广告；缺少结束标记时按计划时长结束广告
+func (a *adTracker) segment(index int64, duration float64) bool {
+	if a.current == nil {
+		return false
+	}
+	current := a.current
+	if current.StartIndex < 0 {
+		current.StartIndex = index
+	}
+	current.EndIndex = index
+	current.Count++
+	current.Duration += duration
+	// 允许0.1秒的误差
+	if current.PlannedDuration > 0 && current.Duration >= current.PlannedDuration-0.1 {
+		a.current = nil
+	}
+	return true
+}
+
+// 已包含分片的广告时段
+func (a *adTracker) result(stripped bool) []adBreakObj {
+	breaks := []adBreakObj{}
+	for _, adBreak := range a.breaks {
+		if adBreak.Count > 0 {
+			adBreak.Stripped = stripped
+			breaks = append(breaks, *adBreak)
+		}
+	}
+	return breaks
+}
+
+// 解析 #EXT-X-CUE-OUT:30、#EXT-X-CUE-OUT:DURATION=30 或 #EXT-X-CUE-OUT-CONT:10/30 中的时长
+func cueDuration(value string) float64 {
+	for _, attr := range tool.ParseTagAttributes(value) {
+		if strings.EqualFold(attr.Key, "DURATION") {
+			duration, _ := strconv.ParseFloat(attr.Value, 64)
+			return duration
+		}
+	}
+	if index := strings.Index(value, "/"); index != -1 {
+		value = value[index+1:]
+	}
+	duration, _ := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	return duration
+}
+
+// CUE-OUT标签中附带的SCTE-35信息
+func cueScte35(value string) string {
+	for _, attr := range tool.ParseTagAttributes(value) {
+		if strings.EqualFold(attr.Key, "SCTE35") || strings.EqualFold(attr.Key, "CUE") {
+			return attr.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,194 @@
+package parser
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/xfy520/m3u8_cli/package/decrypt"
+	"github.com/xfy520/m3u8_cli/package/download"
+	"github.com/xfy520/m3u8_cli/package/lang"
+	"github.com/xfy520/m3u8_cli/package/log"
+	"github.com/xfy520/m3u8_cli/package/probe"
+)
+
+// 片头时长比较允许的误差(秒)
+const bumperTolerance = 0.1
+
+// 探测时最多读取的分片字节数
+const probeLimit = 1024 * 1024
+
+// meta.json中被移除的分部
+type removedPartObj struct {
+	Index    int     `json:"index"`
+	Count    int     `json:"count"`
+	Duration float64 `json:"duration"`
+	Reason   string  `json:"reason"`
+	SegUri   string  `json:"segUri,omitempty"`
+}
+
+// 分部的特征
+type partProfile struct {
+	duration float64
+	host     string
+	media    string // 探测失败时为空
+}
+
+// 探测每个分部的第一个分片，移除与主体内容特征不同或时长与片头一致的分部
+func (p *m3u8Parser) classifyParts(parts [][]segInfoObj, extMAPs []string) ([][]segInfoObj, []removedPartObj) {
+	profiles := make([]partProfile, len(parts))
+	// 各分部使用各自的初始化分段，每个只下载一次
+	inits := map[int][]byte{}
+	for i, part := range parts {
+		for _, seg := range part {
+			profiles[i].duration += seg.Duration
+		}
+		if len(part) == 0 {
+			continue
+		}
+		if u, err := url.Parse(part[0].SegUri); err == nil {
+			profiles[i].host = u.Host
+		}
+		mapIndex := part[0].MapIndex
+		if _, ok := inits[mapIndex]; !ok && mapIndex > 0 && mapIndex <= len(extMAPs) {
+			uri, byteRange := splitExtMap(extMAPs[mapIndex-1])
+			inits[mapIndex], _ = p.probeBytes(uri, byteRange, segInfoObj{})
+		}
+		init := inits[mapIndex]
+		data, err := p.probeBytes(part[0].SegUri, byteRangeString(part[0]), part[0])
+		if err == nil {
+			var info *probe.MediaInfo
+			if info, err = probe.Probe(data, init); err == nil {
+				profiles[i].media = info.String()
+			}
+		}
+		if err != nil {
+			log.Warn(fmt.Sprintf(lang.Lang.PartProbeError, i) + err.Error())
+		}
+	}
+	hosts := map[string]float64{}
+	medias := map[string]float64{}
+	for _, profile := range profiles {
+		hosts[profile.host] += profile.duration
+		if profile.media != "" {
+			medias[profile.media] += profile.duration
+		}
+	}
+	dominantHost, dominantMedia := dominant(hosts), dominant(medias)
+	kept := [][]segInfoObj{}
+	removed := []removedPartObj{}
+	for i, part := range parts {
+		reason := ""
+		profile := profiles[i]
+		switch {
+		case p.isBumper(profile.duration):
+			reason = "bumper duration " + formatFloat(profile.duration)
+		case profile.media != "" && profile.media != dominantMedia:
+			reason = "media " + profile.media + " != " + dominantMedia
+		case len(hosts) > 1 && profile.host != dominantHost:
+			reason = "host " + profile.host + " != " + dominantHost
+		}
+		if reason == "" || len(part) == 0 {
+			kept = append(kept, part)
+			continue
+		}
+		removedPart := removedPartObj{Index: i, Count: len(part), Duration: profile.duration, Reason: reason, SegUri: part[0].SegUri}
+		removed = append(removed, removedPart)
+		log.Warn(fmt.Sprintf(lang.Lang.PartRemoved, i, removedPart.Count, removedPart.Duration, reason))
+		log.WriteInfoTo(p.LogFile, fmt.Sprintf(lang.Lang.PartRemoved, i, removedPart.Count, removedPart.Duration, reason))
+	}
+	// 全部被移除说明判断不可靠，保持原样
+	if len(kept) == 0 {
+		return parts, nil
+	}
+	return kept, removed
+}
+
+// 下载分片开头用于探测，AES-128加密的分片先解密
+func (p *m3u8Parser) probeBytes(uri string, byteRange string, seg segInfoObj) ([]byte, error) {
+	var startByte, expectByte int64
+	if byteRange != "" {
+		t := strings.Split(byteRange, "@")
+		expectByte, _ = strconv.ParseInt(t[0], 10, 64)
+		if len(t) == 2 {
+			startByte, _ = strconv.ParseInt(t[1], 10, 64)
+		}
+	}
+	body, err := download.HttpDownloadStream(uri, p.Headers, 60, startByte, expectByte)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, probeLimit))
+	if err != nil {
+		return nil, err
+	}
+	if seg.Method != "AES-128" || seg.Key == "" {
+		return data, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(seg.Key)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := decrypt.ParseIV(seg.Iv)
+	if err != nil {
+		return nil, err
+	}
+	return decrypt.AES128CBC(data[:len(data)/16*16], key, iv)
+}
+
+func byteRangeString(seg segInfoObj) string {
+	if seg.ExpectByte <= 0 {
+		return ""
+	}
+	return strconv.FormatInt(seg.ExpectByte, 10) + "@" + strconv.FormatInt(seg.StartByte, 10)
+}
+
+// 拆分meta.json中 uri|length@offset 形式的初始化分段
+func splitExtMap(value string) (string, string) {
+	if index := strings.Index(value, "|"); index != -1 {
+		return value[:index], value[index+1:]
+	}
+	return value, ""
+}
+
+// 总时长最长的特征
+func dominant(durations map[string]float64) string {
+	best, bestDuration := "", -1.0
+	for key, duration := range durations {
+		if duration > bestDuration || (duration == bestDuration && key < best) {
+			best, bestDuration = key, duration
+		}
+	}
+	return best
+}
+
+func (p *m3u8Parser) isBumper(duration float64) bool {
+	for _, bumper := range p.BumperDurations {
+		if math.Abs(duration-bumper) <= bumperTolerance {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析 --bumperDurations，以逗号分隔的秒数
+func ParseBumperDurations(value string) ([]float64, error) {
+	durations := []float64{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		duration, err := strconv.ParseFloat(item, 64)
+		if err != nil {
+			return nil, err
+		}
+		durations = append(durations, duration)
+	}
+	return durations, nil
+}
@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/xfy520/m3u8_cli/package/tags"
+	"github.com/xfy520/m3u8_cli/package/tool"
+)
+
+var ErrUndefinedVariable = errors.New("undefined variable")
+
+// 变量引用 {$name}
+var variableReg = regexp.MustCompile(`\{\$([A-Za-z0-9_-]+)\}`)
+
+// 带引号的属性值与十六进制属性值(如 IV=0x{$iv})
+var attributeValueReg = regexp.MustCompile(`"[^"]*"|=0[xX](?:[0-9A-Fa-f]|\{\$[A-Za-z0-9_-]+\})+`)
+
+// 处理 #EXT-X-DEFINE 并替换分片地址行、带引号属性与十六进制属性中的变量，
+// IMPORT 从主列表定义的变量imports中导入，QUERYPARAM 从列表地址的查询参数中导入。
+// 返回替换后的内容以及列表中定义的全部变量
+func SubstituteVariables(content string, playlistUrl string, imports map[string]string) (string, map[string]string, error) {
+	variables := map[string]string{}
+	if !strings.Contains(content, tags.EXT_X_DEFINE) {
+		return content, variables, nil
+	}
+	var query url.Values
+	if u, err := url.Parse(playlistUrl); err == nil {
+		query = u.Query()
+	}
+	var err error
+	replace := func(s string) string {
+		return variableReg.ReplaceAllStringFunc(s, func(ref string) string {
+			name := ref[2 : len(ref)-1]
+			value, ok := variables[name]
+			if !ok && err == nil {
+				err = fmt.Errorf("%w: %s", ErrUndefinedVariable, name)
+			}
+			return value
+		})
+	}
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, tags.EXT_X_DEFINE+":"):
+			attributes := strings.TrimPrefix(trimmed, tags.EXT_X_DEFINE+":")
+			if name := tool.GetTagAttribute(attributes, "NAME"); name != "" {
+				variables[name] = tool.GetTagAttribute(attributes, "VALUE")
+			} else if name := tool.GetTagAttribute(attributes, "IMPORT"); name != "" {
+				value, ok := imports[name]
+				if !ok {
+					return "", nil, fmt.Errorf("%w: %s", ErrUndefinedVariable, name)
+				}
+				variables[name] = value
+			} else if name := tool.GetTagAttribute(attributes, "QUERYPARAM"); name != "" {
+				if _, ok := query[name]; !ok {
+					return "", nil, fmt.Errorf("%w: %s", ErrUndefinedVariable, name)
+				}
+				variables[name] = query.Get(name)
+			}
+		case strings.HasPrefix(trimmed, "#"):
+			lines[i] = attributeValueReg.ReplaceAllStringFunc(line, replace)
+		default:
+			lines[i] = replace(line)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return strings.Join(lines, "\n"), variables, nil
+}
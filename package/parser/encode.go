@@ -0,0 +1,363 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/xfy520/m3u8_cli/package/tags"
+	"github.com/xfy520/m3u8_cli/package/tool"
+)
+
+// 属性列表拼接
+type attributeWriter struct {
+	attrs []string
+}
+
+func (w *attributeWriter) quoted(key string, value string) {
+	if value != "" {
+		w.attrs = append(w.attrs, key+`="`+value+`"`)
+	}
+}
+
+func (w *attributeWriter) plain(key string, value string) {
+	if value != "" {
+		w.attrs = append(w.attrs, key+"="+value)
+	}
+}
+
+func (w *attributeWriter) int(key string, value int64) {
+	if value != 0 {
+		w.plain(key, strconv.FormatInt(value, 10))
+	}
+}
+
+func (w *attributeWriter) float(key string, value float64) {
+	if value != 0 {
+		w.plain(key, formatFloat(value))
+	}
+}
+
+func (w *attributeWriter) bool(key string, value bool) {
+	if value {
+		w.plain(key, "YES")
+	}
+}
+
+func (w *attributeWriter) other(attrs []tool.Attribute) {
+	for _, attr := range attrs {
+		if attr.Quoted {
+			w.attrs = append(w.attrs, attr.Key+`="`+attr.Value+`"`)
+		} else {
+			w.attrs = append(w.attrs, attr.Key+"="+attr.Value)
+		}
+	}
+}
+
+func (w *attributeWriter) String() string {
+	return strings.Join(w.attrs, ",")
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func (b *ByteRange) String() string {
+	if b.Offset < 0 {
+		return strconv.FormatInt(b.Length, 10)
+	}
+	return strconv.FormatInt(b.Length, 10) + "@" + strconv.FormatInt(b.Offset, 10)
+}
+
+func (s *Start) String() string {
+	w := &attributeWriter{}
+	w.plain("TIME-OFFSET", formatFloat(s.TimeOffset))
+	w.bool("PRECISE", s.Precise)
+	w.other(s.Other)
+	return tags.EXT_X_START + ":" + w.String()
+}
+
+func (k *Key) attributes() string {
+	w := &attributeWriter{}
+	w.plain("METHOD", k.Method)
+	w.quoted("URI", k.URI)
+	w.plain("IV", k.IV)
+	w.quoted("KEYFORMAT", k.KeyFormat)
+	w.quoted("KEYFORMATVERSIONS", k.KeyFormatVersions)
+	w.other(k.Other)
+	return w.String()
+}
+
+func (k *Key) String() string {
+	return tags.EXT_X_KEY + ":" + k.attributes()
+}
+
+func (m *Map) String() string {
+	w := &attributeWriter{}
+	w.quoted("URI", m.URI)
+	if m.ByteRange != nil {
+		w.quoted("BYTERANGE", m.ByteRange.String())
+	}
+	w.other(m.Other)
+	return tags.EXT_X_MAP + ":" + w.String()
+}
+
+func (d *DateRange) String() string {
+	w := &attributeWriter{}
+	w.quoted("ID", d.ID)
+	w.quoted("CLASS", d.Class)
+	w.quoted("START-DATE", d.StartDate)
+	w.quoted("END-DATE", d.EndDate)
+	w.float("DURATION", d.Duration)
+	w.float("PLANNED-DURATION", d.PlannedDuration)
+	w.plain("SCTE35-CMD", d.SCTE35Cmd)
+	w.plain("SCTE35-OUT", d.SCTE35Out)
+	w.plain("SCTE35-IN", d.SCTE35In)
+	w.bool("END-ON-NEXT", d.EndOnNext)
+	w.other(d.Other)
+	return tags.EXT_X_DATERANGE + ":" + w.String()
+}
+
+func (p *Part) String() string {
+	w := &attributeWriter{}
+	w.plain("DURATION", formatFloat(p.Duration))
+	w.quoted("URI", p.URI)
+	w.bool("INDEPENDENT", p.Independent)
+	if p.ByteRange != nil {
+		w.quoted("BYTERANGE", p.ByteRange.String())
+	}
+	w.bool("GAP", p.Gap)
+	w.other(p.Other)
+	return tags.EXT_X_PART + ":" + w.String()
+}
+
+func (p *PartInf) String() string {
+	w := &attributeWriter{}
+	w.plain("PART-TARGET", formatFloat(p.PartTarget))
+	w.other(p.Other)
+	return tags.EXT_X_PART_INF + ":" + w.String()
+}
+
+func (s *ServerControl) String() string {
+	w := &attributeWriter{}
+	w.bool("CAN-BLOCK-RELOAD", s.CanBlockReload)
+	w.float("CAN-SKIP-UNTIL", s.CanSkipUntil)
+	w.bool("CAN-SKIP-DATERANGES", s.CanSkipDateRanges)
+	w.float("HOLD-BACK", s.HoldBack)
+	w.float("PART-HOLD-BACK", s.PartHoldBack)
+	w.other(s.Other)
+	return tags.EXT_X_SERVER_CONTROL + ":" + w.String()
+}
+
+func (h *PreloadHint) String() string {
+	w := &attributeWriter{}
+	w.plain("TYPE", h.Type)
+	w.quoted("URI", h.URI)
+	w.int("BYTERANGE-START", h.ByteRangeStart)
+	w.int("BYTERANGE-LENGTH", h.ByteRangeLength)
+	w.other(h.Other)
+	return tags.EXT_X_PRELOAD_HINT + ":" + w.String()
+}
+
+func (r *RenditionReport) String() string {
+	w := &attributeWriter{}
+	w.quoted("URI", r.URI)
+	w.plain("LAST-MSN", strconv.FormatInt(r.LastMsn, 10))
+	if r.LastPart >= 0 {
+		w.plain("LAST-PART", strconv.FormatInt(r.LastPart, 10))
+	}
+	w.other(r.Other)
+	return tags.EXT_X_RENDITION_REPORT + ":" + w.String()
+}
+
+func (v *Variant) String() string {
+	w := &attributeWriter{}
+	w.int("BANDWIDTH", v.Bandwidth)
+	w.int("AVERAGE-BANDWIDTH", v.AverageBandwidth)
+	w.quoted("CODECS", v.Codecs)
+	w.plain("RESOLUTION", v.Resolution)
+	w.float("FRAME-RATE", v.FrameRate)
+	w.plain("HDCP-LEVEL", v.HDCPLevel)
+	w.plain("VIDEO-RANGE", v.VideoRange)
+	w.quoted("AUDIO", v.Audio)
+	w.quoted("VIDEO", v.Video)
+	w.quoted("SUBTITLES", v.Subtitles)
+	if v.ClosedCaptions == "NONE" {
+		w.plain("CLOSED-CAPTIONS", v.ClosedCaptions)
+	} else {
+		w.quoted("CLOSED-CAPTIONS", v.ClosedCaptions)
+	}
+	if v.IFrame {
+		w.quoted("URI", v.URI)
+		w.other(v.Other)
+		return tags.EXT_X_I_FRAME_STREAM_INF + ":" + w.String()
+	}
+	w.other(v.Other)
+	return tags.EXT_X_STREAM_INF + ":" + w.String() + "\n" + v.URI
+}
+
+func (r *Rendition) String() string {
+	w := &attributeWriter{}
+	w.plain("TYPE", r.Type)
+	w.quoted("URI", r.URI)
+	w.quoted("GROUP-ID", r.GroupID)
+	w.quoted("LANGUAGE", r.Language)
+	w.quoted("ASSOC-LANGUAGE", r.AssocLanguage)
+	w.quoted("NAME", r.Name)
+	w.bool("DEFAULT", r.Default)
+	w.bool("AUTOSELECT", r.AutoSelect)
+	w.bool("FORCED", r.Forced)
+	w.quoted("INSTREAM-ID", r.InstreamID)
+	w.quoted("CHARACTERISTICS", r.Characteristics)
+	w.quoted("CHANNELS", r.Channels)
+	w.other(r.Other)
+	return tags.EXT_X_MEDIA + ":" + w.String()
+}
+
+func (s *SessionData) String() string {
+	w := &attributeWriter{}
+	w.quoted("DATA-ID", s.DataID)
+	w.quoted("VALUE", s.Value)
+	w.quoted("URI", s.URI)
+	w.quoted("LANGUAGE", s.Language)
+	w.other(s.Other)
+	return tags.EXT_X_SESSION_DATA + ":" + w.String()
+}
+
+// 判断两组KEY是否一致，一致时无需重复输出#EXT-X-KEY
+func sameKeys(a []*Key, b []*Key) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] && a[i].attributes() != b[i].attributes() {
+			return false
+		}
+	}
+	return true
+}
+
+// 输出主播放列表
+func (p *MasterPlaylist) Encode() string {
+	lines := []string{tags.EXT_M3U}
+	if p.Version > 0 {
+		lines = append(lines, tags.EXT_X_VERSION+":"+strconv.FormatInt(p.Version, 10))
+	}
+	if p.IndependentSegments {
+		lines = append(lines, tags.EXT_IS_INDEPENDENT_SEGMENTS)
+	}
+	if p.Start != nil {
+		lines = append(lines, p.Start.String())
+	}
+	lines = append(lines, p.Tags...)
+	for _, data := range p.SessionData {
+		lines = append(lines, data.String())
+	}
+	for _, key := range p.SessionKeys {
+		lines = append(lines, tags.EXT_X_SESSION_KEY+":"+key.attributes())
+	}
+	for _, rendition := range p.Renditions {
+		lines = append(lines, rendition.Tags...)
+		lines = append(lines, rendition.String())
+	}
+	for _, variant := range p.Variants {
+		lines = append(lines, variant.Tags...)
+		lines = append(lines, variant.String())
+	}
+	for _, variant := range p.IFrameVariants {
+		lines = append(lines, variant.Tags...)
+		lines = append(lines, variant.String())
+	}
+	lines = append(lines, p.TrailingTags...)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// 输出媒体播放列表
+func (p *MediaPlaylist) Encode() string {
+	lines := []string{tags.EXT_M3U}
+	if p.Version > 0 {
+		lines = append(lines, tags.EXT_X_VERSION+":"+strconv.FormatInt(p.Version, 10))
+	}
+	lines = append(lines, tags.EXT_X_TARGETDURATION+":"+strconv.FormatInt(p.TargetDuration, 10))
+	if p.MediaSequence != 0 {
+		lines = append(lines, tags.EXT_X_MEDIA_SEQUENCE+":"+strconv.FormatInt(p.MediaSequence, 10))
+	}
+	if p.DiscontinuitySequence != 0 {
+		lines = append(lines, tags.EXT_X_DISCONTINUITY_SEQUENCE+":"+strconv.FormatInt(p.DiscontinuitySequence, 10))
+	}
+	if p.PlaylistType != "" {
+		lines = append(lines, tags.EXT_X_PLAYLIST_TYPE+":"+p.PlaylistType)
+	}
+	if p.AllowCache != "" {
+		lines = append(lines, tags.EXT_X_ALLOW_CACHE+":"+p.AllowCache)
+	}
+	if p.IFramesOnly {
+		lines = append(lines, tags.EXT_I_FRAMES_ONLY)
+	}
+	if p.IndependentSegments {
+		lines = append(lines, tags.EXT_IS_INDEPENDENT_SEGMENTS)
+	}
+	if p.Start != nil {
+		lines = append(lines, p.Start.String())
+	}
+	if p.ServerControl != nil {
+		lines = append(lines, p.ServerControl.String())
+	}
+	if p.PartInf != nil {
+		lines = append(lines, p.PartInf.String())
+	}
+	lines = append(lines, p.Tags...)
+	var (
+		lastKeys []*Key
+		lastMap  *Map
+	)
+	for _, seg := range p.Segments {
+		if seg.Discontinuity {
+			lines = append(lines, tags.EXT_X_DISCONTINUITY)
+		}
+		if !sameKeys(lastKeys, seg.Keys) {
+			for _, key := range seg.Keys {
+				lines = append(lines, key.String())
+			}
+			lastKeys = seg.Keys
+		}
+		if seg.Map != nil && (lastMap == nil || (seg.Map != lastMap && seg.Map.String() != lastMap.String())) {
+			lines = append(lines, seg.Map.String())
+			lastMap = seg.Map
+		}
+		if seg.ProgramDateTime != "" {
+			lines = append(lines, tags.EXT_X_PROGRAM_DATE_TIME+":"+seg.ProgramDateTime)
+		}
+		for _, dateRange := range seg.DateRanges {
+			lines = append(lines, dateRange.String())
+		}
+		if seg.Gap {
+			lines = append(lines, tags.EXT_X_GAP)
+		}
+		if seg.Bitrate != 0 {
+			lines = append(lines, tags.EXT_X_BITRATE+":"+strconv.FormatInt(seg.Bitrate, 10))
+		}
+		lines = append(lines, seg.Tags...)
+		for _, part := range seg.Parts {
+			lines = append(lines, part.String())
+		}
+		if seg.ByteRange != nil {
+			lines = append(lines, tags.EXT_X_BYTERANGE+":"+seg.ByteRange.String())
+		}
+		lines = append(lines, tags.EXTINF+":"+formatFloat(seg.Duration)+","+seg.Title)
+		lines = append(lines, seg.URI)
+	}
+	lines = append(lines, p.TrailingTags...)
+	for _, part := range p.Parts {
+		lines = append(lines, part.String())
+	}
+	for _, hint := range p.PreloadHints {
+		lines = append(lines, hint.String())
+	}
+	for _, report := range p.RenditionReports {
+		lines = append(lines, report.String())
+	}
+	if p.EndList {
+		lines = append(lines, tags.EXT_X_ENDLIST)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
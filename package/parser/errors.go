@@ -0,0 +1,53 @@
+package parser
+
+import (
+	"errors"
+
+	"github.com/xfy520/m3u8_cli/package/lang"
+)
+
+var (
+	ErrInvalidM3u8 = errors.New(lang.Lang.InvalidM3u8Error)
+	ErrEmptyM3u8   = errors.New(lang.Lang.ParseError)
+	// 直播的mpd/ism转换后的本地列表不会更新，无法按HLS直播录制
+	ErrLiveManifest = errors.New(lang.Lang.LiveManifestUnsupported)
+)
+
+// 解析错误
+type ParseError struct {
+	Url string
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	msg := lang.Lang.ParseError
+	if e.Url != "" {
+		msg += " " + e.Url
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// KEY获取或解析错误
+type KeyError struct {
+	Uri string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	msg := lang.Lang.DownloadingM3u8Key + " " + e.Uri
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
@@ -1,5 +1,175 @@
 package parser
 
-func IqJsonParser(downDir string, mpdContent string) (string, error) {
-	return "", nil
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/xfy520/m3u8_cli/package/tags"
+)
+
+var (
+	ErrIqNoProgram = errors.New("iq json has no program")
+	ErrIqNoStream  = errors.New("iq json has no playable stream")
+)
+
+// iq.com接口返回的json，节目信息位于 payload 下的 program 中
+// 每条视频/音频带有内嵌的m3u8文本，或以 fs 列出的分片地址
+type iqProgram struct {
+	Video []iqStream `json:"video"`
+	Audio []iqStream `json:"audio"`
+}
+
+type iqStream struct {
+	Bid      json.Number `json:"bid"`
+	Lid      json.Number `json:"lid"`
+	Scrsz    string      `json:"scrsz"`
+	Vsize    json.Number `json:"vsize"`
+	Duration json.Number `json:"duration"`
+	Lang     string      `json:"lang"`
+	Name     string      `json:"name"`
+	M3u8     string      `json:"m3u8"`
+	M3u8Url  string      `json:"m3u8Url"`
+	Fs       []iqFile    `json:"fs"`
+}
+
+type iqFile struct {
+	L string      `json:"l"`
+	D json.Number `json:"d"`
+	B json.Number `json:"b"`
+}
+
+// 解析iq.com的payload json，生成本地m3u8并返回主列表的file:地址
+func IqJsonParser(downDir string, jsonContent string) (string, error) {
+	program, host, err := findIqProgram(jsonContent)
+	if err != nil {
+		return "", err
+	}
+	streams := []*mediaStream{}
+	for i, stream := range program.Video {
+		if media := iqMediaStream("video", i, stream, host); media != nil {
+			streams = append(streams, media)
+		}
+	}
+	for i, stream := range program.Audio {
+		if media := iqMediaStream("audio", i, stream, host); media != nil {
+			streams = append(streams, media)
+		}
+	}
+	if len(streams) == 0 {
+		return "", ErrIqNoStream
+	}
+	return writeStreamPlaylists(path.Join(downDir, localPlaylistDir), streams)
+}
+
+// 依次尝试 payload.program、payload.data.program、payload.data 等位置
+func findIqProgram(jsonContent string) (*iqProgram, string, error) {
+	var root struct {
+		Payload map[string]json.RawMessage `json:"payload"`
+	}
+	decoder := json.NewDecoder(strings.NewReader(jsonContent))
+	decoder.UseNumber()
+	if err := decoder.Decode(&root); err != nil {
+		return nil, "", err
+	}
+	candidates := []map[string]json.RawMessage{root.Payload}
+	for len(candidates) > 0 {
+		node := candidates[0]
+		candidates = candidates[1:]
+		host := ""
+		if raw, ok := node["dd"]; ok {
+			json.Unmarshal(raw, &host)
+		}
+		for _, key := range []string{"program", "data", "dash", "wm_a"} {
+			raw, ok := node[key]
+			if !ok {
+				continue
+			}
+			program := &iqProgram{}
+			if err := unmarshalNumber(raw, program); err == nil && len(program.Video)+len(program.Audio) > 0 {
+				return program, host, nil
+			}
+			child := map[string]json.RawMessage{}
+			if err := json.Unmarshal(raw, &child); err == nil {
+				candidates = append(candidates, child)
+			}
+		}
+	}
+	return nil, "", ErrIqNoProgram
+}
+
+func unmarshalNumber(raw json.RawMessage, v interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// 将一条视频或音频转换为媒体流，没有内容的条目返回nil
+func iqMediaStream(streamType string, i int, stream iqStream, host string) *mediaStream {
+	media := &mediaStream{
+		Key:  fmt.Sprintf("%s|%d", streamType, i),
+		Type: streamType,
+		Lang: stream.Lang,
+	}
+	switch {
+	case strings.HasPrefix(strings.TrimSpace(stream.M3u8), tags.EXT_M3U):
+		media.Playlist = stream.M3u8
+	case stream.M3u8Url != "":
+		media.URI = stream.M3u8Url
+	case len(stream.Fs) > 0:
+		for _, file := range stream.Fs {
+			uri := file.L
+			if strings.HasPrefix(uri, "/") && host != "" {
+				uri = strings.TrimSuffix(host, "/") + uri
+			}
+			ms, _ := file.D.Int64()
+			media.Segments = append(media.Segments, &Segment{URI: uri, Duration: float64(ms) / 1000})
+		}
+	default:
+		return nil
+	}
+	if t := strings.Split(stream.Scrsz, "x"); len(t) == 2 {
+		media.Width, media.Height = t[0], t[1]
+	}
+	media.Bandwidth = iqBandwidth(stream, media)
+	name := []string{}
+	if stream.Name != "" {
+		name = append(name, stream.Name)
+	}
+	if stream.Lid.String() != "" {
+		name = append(name, "lid "+stream.Lid.String())
+	}
+	if stream.Bid.String() != "" {
+		name = append(name, "bid "+stream.Bid.String())
+	}
+	media.Name = strings.Join(name, " ")
+	return media
+}
+
+// 由文件大小与时长估算带宽，缺少信息时以bid排序
+func iqBandwidth(stream iqStream, media *mediaStream) int64 {
+	size, _ := stream.Vsize.Int64()
+	seconds := 0.0
+	if duration, err := stream.Duration.Float64(); err == nil {
+		seconds = duration
+	}
+	if seconds == 0 {
+		for _, seg := range media.Segments {
+			seconds += seg.Duration
+		}
+	}
+	if size == 0 {
+		for _, file := range stream.Fs {
+			b, _ := file.B.Int64()
+			size += b
+		}
+	}
+	if size > 0 && seconds > 0 {
+		return int64(float64(size*8) / seconds)
+	}
+	bid, _ := strconv.ParseInt(stream.Bid.String(), 10, 64)
+	return bid * 1000
 }
@@ -0,0 +1,311 @@
+package parser
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/xfy520/m3u8_cli/package/lang"
+	"github.com/xfy520/m3u8_cli/package/log"
+	"github.com/xfy520/m3u8_cli/package/mp4"
+	"github.com/xfy520/m3u8_cli/package/tool"
+)
+
+var ErrNoQualityLevel = errors.New("ism has no quality level")
+
+// Smooth Streaming默认时间刻度
+const ismDefaultTimeScale = 10000000
+
+type ismManifest struct {
+	XMLName       xml.Name         `xml:"SmoothStreamingMedia"`
+	TimeScale     *int64           `xml:"TimeScale,attr"`
+	Duration      int64            `xml:"Duration,attr"`
+	IsLive        string           `xml:"IsLive,attr"`
+	StreamIndexes []ismStreamIndex `xml:"StreamIndex"`
+	Protection    *struct{}        `xml:"Protection"`
+}
+
+type ismStreamIndex struct {
+	Type          string            `xml:"Type,attr"`
+	Name          string            `xml:"Name,attr"`
+	Url           string            `xml:"Url,attr"`
+	Language      string            `xml:"Language,attr"`
+	TimeScale     *int64            `xml:"TimeScale,attr"`
+	MaxWidth      int               `xml:"MaxWidth,attr"`
+	MaxHeight     int               `xml:"MaxHeight,attr"`
+	QualityLevels []ismQualityLevel `xml:"QualityLevel"`
+	Chunks        []ismChunk        `xml:"c"`
+}
+
+type ismQualityLevel struct {
+	Bitrate          int64  `xml:"Bitrate,attr"`
+	FourCC           string `xml:"FourCC,attr"`
+	MaxWidth         int    `xml:"MaxWidth,attr"`
+	MaxHeight        int    `xml:"MaxHeight,attr"`
+	CodecPrivateData string `xml:"CodecPrivateData,attr"`
+	SamplingRate     int    `xml:"SamplingRate,attr"`
+	Channels         int    `xml:"Channels,attr"`
+	BitsPerSample    int    `xml:"BitsPerSample,attr"`
+}
+
+type ismChunk struct {
+	T *int64 `xml:"t,attr"`
+	D *int64 `xml:"d,attr"`
+	R *int64 `xml:"r,attr"`
+}
+
+// 分片的起始时间与时长
+type ismFragment struct {
+	Time     int64
+	Duration int64
+}
+
+// ism解析器，将Smooth Streaming清单转换为本地m3u8，并根据CodecPrivateData生成初始化分段
+type ismParser struct {
+	DownDir  string
+	IsmUrl   string
+	BaseUrl  string
+	LogFile  string
+	manifest ismManifest
+}
+
+func NewIsmParser() *ismParser {
+	return &ismParser{}
+}
+
+// 解析ism清单，生成本地m3u8并返回主列表的file:地址
+func IsmParse(downDir string, ismUrl string, ismContent string, BaseUrl string, logFile string) (string, error) {
+	ip := NewIsmParser()
+	ip.DownDir = downDir
+	ip.IsmUrl = ismUrl
+	ip.BaseUrl = BaseUrl
+	ip.LogFile = logFile
+	return ip.Parse(ismContent)
+}
+
+func (ip *ismParser) Parse(ismContent string) (string, error) {
+	decoder := xml.NewDecoder(strings.NewReader(ismContent))
+	// 内容已转换为UTF-8，忽略声明中的编码
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	if err := decoder.Decode(&ip.manifest); err != nil {
+		return "", err
+	}
+	if ip.manifest.Protection != nil {
+		log.Warn(lang.Lang.IsmProtected)
+		log.WriteInfoTo(ip.LogFile, lang.Lang.IsmProtected)
+	}
+	dir := path.Join(ip.DownDir, localPlaylistDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	base := ip.IsmUrl
+	if ip.BaseUrl != "" {
+		base = ip.BaseUrl
+	}
+	streams := []*mediaStream{}
+	for _, index := range ip.manifest.StreamIndexes {
+		if index.Type != "video" && index.Type != "audio" {
+			continue
+		}
+		timescale := ip.timeScale(index)
+		fragments := ip.fragments(index, timescale)
+		for _, level := range index.QualityLevels {
+			track, err := ismTrack(index, level, timescale)
+			if err != nil {
+				log.Warn(fmt.Sprintf(lang.Lang.IsmUnsupportedCodec, level.FourCC))
+				continue
+			}
+			init, err := mp4.BuildInit(track)
+			if err != nil {
+				log.Warn(fmt.Sprintf(lang.Lang.IsmUnsupportedCodec, level.FourCC))
+				continue
+			}
+			initPath := path.Join(dir, fmt.Sprintf("%s_%d_init.mp4", index.Type, len(streams)))
+			if err := os.WriteFile(initPath, init, 0666); err != nil {
+				return "", err
+			}
+			initUrl, err := tool.PathToUrl(initPath)
+			if err != nil {
+				return "", err
+			}
+			stream := &mediaStream{
+				Key:       index.Name + "|" + strconv.FormatInt(level.Bitrate, 10),
+				Type:      index.Type,
+				Bandwidth: level.Bitrate,
+				Codecs:    ismCodecString(track),
+				Lang:      index.Language,
+				Live:      strings.ToUpper(ip.manifest.IsLive) == "TRUE",
+			}
+			if track.Type == "video" && track.Width > 0 && track.Height > 0 {
+				stream.Width, stream.Height = strconv.Itoa(track.Width), strconv.Itoa(track.Height)
+			}
+			if track.Channels > 0 {
+				stream.Channels = strconv.Itoa(track.Channels)
+			}
+			stream.Name = strings.TrimSpace(index.Name + " " + fmt.Sprintf("%d Kbps", level.Bitrate/1000))
+			initMap := &Map{URI: initUrl}
+			for _, fragment := range fragments {
+				stream.Segments = append(stream.Segments, &Segment{
+					URI:      resolveURL(base, expandIsmTemplate(index.Url, level.Bitrate, fragment.Time)),
+					Duration: float64(fragment.Duration) / float64(timescale),
+					Map:      initMap,
+				})
+			}
+			streams = append(streams, stream)
+		}
+	}
+	if len(streams) == 0 {
+		return "", ErrNoQualityLevel
+	}
+	return writeStreamPlaylists(dir, streams)
+}
+
+func (ip *ismParser) timeScale(index ismStreamIndex) int64 {
+	if index.TimeScale != nil && *index.TimeScale > 0 {
+		return *index.TimeScale
+	}
+	if ip.manifest.TimeScale != nil && *ip.manifest.TimeScale > 0 {
+		return *ip.manifest.TimeScale
+	}
+	return ismDefaultTimeScale
+}
+
+// 展开<c>元素，t缺省时接续上一分片，d缺省时取下一分片的t，r为相同时长的分片个数
+func (ip *ismParser) fragments(index ismStreamIndex, timescale int64) []ismFragment {
+	fragments := []ismFragment{}
+	t := int64(0)
+	for i, chunk := range index.Chunks {
+		if chunk.T != nil {
+			t = *chunk.T
+		}
+		d := int64(0)
+		switch {
+		case chunk.D != nil:
+			d = *chunk.D
+		case i+1 < len(index.Chunks) && index.Chunks[i+1].T != nil:
+			d = *index.Chunks[i+1].T - t
+		case ip.manifest.Duration > 0:
+			d = ip.manifest.Duration*timescale/ismTimeScaleOf(ip.manifest.TimeScale) - t
+		}
+		repeat := int64(1)
+		if chunk.R != nil && *chunk.R > 1 {
+			repeat = *chunk.R
+		}
+		for j := int64(0); j < repeat; j++ {
+			fragments = append(fragments, ismFragment{Time: t, Duration: d})
+			t += d
+		}
+	}
+	return fragments
+}
+
+func ismTimeScaleOf(value *int64) int64 {
+	if value != nil && *value > 0 {
+		return *value
+	}
+	return ismDefaultTimeScale
+}
+
+// 根据QualityLevel生成轨道信息
+func ismTrack(index ismStreamIndex, level ismQualityLevel, timescale int64) (*mp4.TrackInfo, error) {
+	codecPrivate, err := hex.DecodeString(level.CodecPrivateData)
+	if err != nil {
+		return nil, err
+	}
+	track := &mp4.TrackInfo{
+		TrackID:       1,
+		Type:          index.Type,
+		Timescale:     uint32(timescale),
+		Width:         level.MaxWidth,
+		Height:        level.MaxHeight,
+		Channels:      level.Channels,
+		SampleRate:    level.SamplingRate,
+		BitsPerSample: level.BitsPerSample,
+		Language:      index.Language,
+		CodecPrivate:  codecPrivate,
+	}
+	if track.Width == 0 {
+		track.Width, track.Height = index.MaxWidth, index.MaxHeight
+	}
+	switch strings.ToUpper(level.FourCC) {
+	case "H264", "AVC1", "DAVC", "X264":
+		track.Codec = "avc1"
+	case "HEVC", "H265", "HVC1", "HEV1":
+		track.Codec = "hvc1"
+	case "AACL", "AACH", "AAC", "MP4A":
+		track.Codec = "mp4a"
+	case "AC-3", "AC3":
+		track.Codec = "ac-3"
+	case "EC-3", "EC3":
+		track.Codec = "ec-3"
+	default:
+		return nil, mp4.ErrUnsupportedCodec
+	}
+	return track, nil
+}
+
+// 生成CODECS属性
+func ismCodecString(track *mp4.TrackInfo) string {
+	switch track.Codec {
+	case "avc1":
+		for _, nal := range mp4.SplitNALUnits(track.CodecPrivate) {
+			if nal[0]&0x1f == 7 && len(nal) >= 4 {
+				return fmt.Sprintf("avc1.%02X%02X%02X", nal[1], nal[2], nal[3])
+			}
+		}
+	case "mp4a":
+		if len(track.CodecPrivate) > 0 {
+			return fmt.Sprintf("mp4a.40.%d", track.CodecPrivate[0]>>3)
+		}
+		return "mp4a.40.2"
+	}
+	return track.Codec
+}
+
+// 替换 {bitrate} 与 {start time}
+func expandIsmTemplate(template string, bitrate int64, t int64) string {
+	replacer := strings.NewReplacer(
+		"{bitrate}", strconv.FormatInt(bitrate, 10),
+		"{Bitrate}", strconv.FormatInt(bitrate, 10),
+		"{start time}", strconv.FormatInt(t, 10),
+		"{start_time}", strconv.FormatInt(t, 10),
+	)
+	return replacer.Replace(template)
+}
+
+// 判断内容是否为Smooth Streaming清单
+func isIsmContent(content string) bool {
+	return strings.Contains(content, "<SmoothStreamingMedia")
+}
+
+// 部分IIS返回带BOM的UTF-16清单，转换为UTF-8
+func decodeUTF16(content string) string {
+	byts := []byte(content)
+	if len(byts) < 2 || len(byts)%2 != 0 {
+		return content
+	}
+	var order binary.ByteOrder
+	switch {
+	case byts[0] == 0xff && byts[1] == 0xfe:
+		order = binary.LittleEndian
+	case byts[0] == 0xfe && byts[1] == 0xff:
+		order = binary.BigEndian
+	default:
+		return content
+	}
+	units := make([]uint16, 0, len(byts)/2-1)
+	for i := 2; i+1 < len(byts); i += 2 {
+		units = append(units, order.Uint16(byts[i:]))
+	}
+	return string(utf16.Decode(units))
+}
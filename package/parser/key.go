@@ -0,0 +1,169 @@
+package parser
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/xfy520/m3u8_cli/package/decode"
+	"github.com/xfy520/m3u8_cli/package/download"
+	"github.com/xfy520/m3u8_cli/package/lang"
+	"github.com/xfy520/m3u8_cli/package/log"
+	"github.com/xfy520/m3u8_cli/package/tags"
+	"github.com/xfy520/m3u8_cli/package/tool"
+)
+
+var ErrKeyLength = errors.New("AES-128 key must be 16 bytes")
+
+// 无法解密的加密方式在分片信息中的后缀
+const unsupportedSuffix = "(NOTSUPPORTED)"
+
+// 解析#EXT-X-KEY并获取KEY内容，相同URI的KEY只获取一次
+func (p *m3u8Parser) ParseKey(line string) (*Key, error) {
+	key := decodeKey(strings.TrimPrefix(line, tags.EXT_X_KEY+":"))
+	if key.Method == "" || key.Method == "NONE" {
+		return &Key{Method: "NONE"}, nil
+	}
+	identity := key.KeyFormat == "" || key.KeyFormat == "identity"
+	if !isSupportedMethod(key.Method) || (key.Method == "AES-128" && !identity) {
+		log.Error(fmt.Sprintf(lang.Lang.NotSupportMethodError, key.Method))
+		key.Method = key.Method + unsupportedSuffix
+		return key, nil
+	}
+	if !identity {
+		// SAMPLE-AES的DRM格式KEY无法直接获取，需通过 --key 指定
+		log.WriteInfoTo(p.LogFile, fmt.Sprintf(lang.Lang.KeyFormatNeedsKey, key.KeyFormat))
+		return key, nil
+	}
+	if _, err := p.ResolveKey(key.URI); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// 根据URI获取KEY，支持 http(s)、base64:、file:、十六进制字符串与本地文件
+func (p *m3u8Parser) ResolveKey(uri string) ([]byte, error) {
+	if value, ok := p.keyCache[uri]; ok {
+		return value, nil
+	}
+	if !p.downloadingM3u8KeyTip {
+		log.Warn(lang.Lang.DownloadingM3u8Key)
+		p.downloadingM3u8KeyTip = true
+	}
+	log.WriteInfoTo(p.LogFile, lang.Lang.DownloadingM3u8Key+" "+uri)
+	value, err := p.fetchKey(uri)
+	if err != nil {
+		return nil, &KeyError{Uri: uri, Err: err}
+	}
+	if len(value) != 16 {
+		return nil, &KeyError{Uri: uri, Err: ErrKeyLength}
+	}
+	p.keyCache[uri] = value
+	return value, nil
+}
+
+func (p *m3u8Parser) fetchKey(uri string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(uri, "base64:"):
+		return base64.StdEncoding.DecodeString(strings.TrimPrefix(uri, "base64:"))
+	case strings.HasPrefix(uri, "data:") && strings.Contains(uri, ";base64,"):
+		return base64.StdEncoding.DecodeString(uri[strings.Index(uri, ";base64,")+8:])
+	case strings.HasPrefix(uri, "file:"):
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, err
+		}
+		return tool.ReadFile(tool.UrlToPath(u))
+	case isHexKey(uri):
+		return hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(uri, "0x"), "0X"))
+	case !strings.HasPrefix(uri, "http") && tool.Exists(uri) && tool.IsFile(uri):
+		return tool.ReadFile(uri)
+	}
+	keyUrl := uri
+	if !strings.HasPrefix(keyUrl, "http") {
+		keyUrl = p.CombineURL(p.BaseUrl, uri)
+	}
+	if strings.Contains(keyUrl, "imooc.com/") {
+		byts, err := download.GetWebSource(keyUrl, p.Headers, 60)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(decode.ImoocDecodeKey(tool.BytesToStr(byts)))
+	}
+	byts, err := download.HttpDownloadFileToBytes(keyUrl, p.Headers, 60)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeKey(byts), nil
+}
+
+// 部分站点以十六进制或base64文本形式返回KEY
+func normalizeKey(byts []byte) []byte {
+	text := strings.TrimSpace(tool.BytesToStr(byts))
+	if len(byts) != 16 && isHexKey(text) {
+		if value, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(text, "0x"), "0X")); err == nil {
+			return value
+		}
+	}
+	if len(byts) != 16 && len(text) == 24 {
+		if value, err := base64.StdEncoding.DecodeString(text); err == nil {
+			return value
+		}
+	}
+	return byts
+}
+
+// 支持解密的加密方式
+func isSupportedMethod(method string) bool {
+	return method == "AES-128" || method == "SAMPLE-AES" || method == "SAMPLE-AES-CTR"
+}
+
+// 保留的分片中存在无法解密的分片时只能二进制合并
+func hasUnsupportedKey(parts [][]segInfoObj) bool {
+	for _, part := range parts {
+		for _, seg := range part {
+			if strings.HasSuffix(seg.Method, unsupportedSuffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// KEY是否已获取到内容
+func (p *m3u8Parser) keyResolved(key *Key) bool {
+	if key == nil || !isSupportedMethod(key.Method) {
+		return false
+	}
+	_, ok := p.keyCache[key.URI]
+	return ok
+}
+
+func isIdentityKey(line string) bool {
+	keyFormat := tool.GetTagAttribute(line, "KEYFORMAT")
+	return keyFormat == "" || keyFormat == "identity"
+}
+
+func isHexKey(text string) bool {
+	text = strings.TrimPrefix(strings.TrimPrefix(text, "0x"), "0X")
+	if len(text) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(text)
+	return err == nil
+}
+
+// 未指定IV时，以128位大端序的媒体序列号作为IV
+func sequenceIV(mediaSequence int64) string {
+	return fmt.Sprintf("0x%032x", mediaSequence)
+}
+
+// 未指定IV时按分片的媒体序列号写入IV，分片写入序列号不连续的列表(如直播录制的本地列表)后仍能正确解密
+func (k *Key) FillSequenceIV(mediaSequence int64) {
+	if k.IV == "" && isSupportedMethod(k.Method) {
+		k.IV = sequenceIV(mediaSequence)
+	}
+}
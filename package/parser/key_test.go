@@ -0,0 +1,87 @@
+package parser
+
+import "testing"
+
+func TestSequenceIV(t *testing.T) {
+	for _, c := range []struct {
+		msn  int64
+		want string
+	}{
+		{0, "0x00000000000000000000000000000000"},
+		{1, "0x00000000000000000000000000000001"},
+		{255, "0x000000000000000000000000000000ff"},
+		{1 << 40, "0x00000000000000000000010000000000"},
+	} {
+		if got := sequenceIV(c.msn); got != c.want {
+			t.Errorf("sequenceIV(%d) = %s, want %s", c.msn, got, c.want)
+		}
+	}
+}
+
+// 去除广告后，其余分片的IV仍然使用各自的媒体序列号
+func TestSequenceIVAcrossAdRemoval(t *testing.T) {
+	const key = `#EXT-X-KEY:METHOD=AES-128,URI="data:text/plain;base64,MDEyMzQ1Njc4OWFiY2RlZg=="` + "\n"
+	for _, c := range []struct {
+		name    string
+		content string
+		want    []string // 保留的分片地址与对应的媒体序列号
+		ivs     []int64
+	}{
+		{
+			name: "plain",
+			content: "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXT-X-MEDIA-SEQUENCE:7\n" + key +
+				"#EXTINF:10,\na.ts\n#EXTINF:10,\nb.ts\n#EXT-X-ENDLIST\n",
+			want: []string{"a.ts", "b.ts"},
+			ivs:  []int64{7, 8},
+		},
+		{
+			name: "uplynk",
+			content: "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXT-X-MEDIA-SEQUENCE:10\n" + key +
+				"#UPLYNK-SEGMENT:x,00000000,segment\n#EXTINF:10,\na.ts\n" +
+				"#UPLYNK-SEGMENT:y,00000000,ad\n#EXTINF:10,\nad0.ts\n#EXTINF:10,\nad1.ts\n" +
+				"#UPLYNK-SEGMENT:x,00000001,segment\n#EXTINF:10,\nb.ts\n#EXT-X-ENDLIST\n",
+			want: []string{"a.ts", "b.ts"},
+			ivs:  []int64{10, 13},
+		},
+		{
+			name: "youku",
+			content: "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXT-X-MEDIA-SEQUENCE:20\n" + key +
+				"#EXTINF:10,\na.ts\n#EXTINF:10,\nad/x.ts?ccode=0902&duration=10\n#EXTINF:10,\nb.ts\n#EXT-X-ENDLIST\n",
+			want: []string{"a.ts", "b.ts"},
+			ivs:  []int64{20, 22},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			segs := allSegments(parseMeta(t, c.content, NewParseOptions()))
+			if len(segs) != len(c.want) {
+				t.Fatalf("got %d segments, want %d", len(segs), len(c.want))
+			}
+			for i, seg := range segs {
+				if seg.SegUri != "http://example.com/"+c.want[i] {
+					t.Errorf("segment %d uri = %s, want %s", i, seg.SegUri, c.want[i])
+				}
+				if seg.Iv != sequenceIV(c.ivs[i]) {
+					t.Errorf("segment %d iv = %s, want %s", i, seg.Iv, sequenceIV(c.ivs[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestFillSequenceIV(t *testing.T) {
+	for _, c := range []struct {
+		key  Key
+		want string
+	}{
+		{Key{Method: "AES-128"}, sequenceIV(42)},
+		{Key{Method: "SAMPLE-AES"}, sequenceIV(42)},
+		{Key{Method: "AES-128", IV: "0x01"}, "0x01"},
+		{Key{Method: "NONE"}, ""},
+	} {
+		key := c.key
+		key.FillSequenceIV(42)
+		if key.IV != c.want {
+			t.Errorf("%s %q: iv = %q, want %q", c.key.Method, c.key.IV, key.IV, c.want)
+		}
+	}
+}
@@ -1,5 +1,790 @@
 package parser
 
-func MpdParse(downDir string, mpdUrl string, mpdContent string, BaseUrl string) string {
-	return ""
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xfy520/m3u8_cli/package/download"
+	"github.com/xfy520/m3u8_cli/package/mp4"
+	"github.com/xfy520/m3u8_cli/package/tool"
+)
+
+// mpd、ism等转换生成的m3u8存放目录(位于下载目录中)
+const localPlaylistDir = "Playlists"
+
+var (
+	ErrNoPeriod       = errors.New("mpd has no period")
+	ErrNoRepresention = errors.New("mpd has no representation")
+	ErrSidx           = errors.New("invalid sidx")
+)
+
+type mpdRoot struct {
+	XMLName                   xml.Name    `xml:"MPD"`
+	Type                      string      `xml:"type,attr"`
+	MediaPresentationDuration string      `xml:"mediaPresentationDuration,attr"`
+	AvailabilityStartTime     string      `xml:"availabilityStartTime,attr"`
+	TimeShiftBufferDepth      string      `xml:"timeShiftBufferDepth,attr"`
+	BaseURL                   []string    `xml:"BaseURL"`
+	Periods                   []mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	ID              string              `xml:"id,attr"`
+	Start           string              `xml:"start,attr"`
+	Duration        string              `xml:"duration,attr"`
+	BaseURL         []string            `xml:"BaseURL"`
+	SegmentTemplate *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *mpdSegmentList     `xml:"SegmentList"`
+	SegmentBase     *mpdSegmentBase     `xml:"SegmentBase"`
+	AdaptationSets  []mpdAdaptationSet  `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	ID                 string                 `xml:"id,attr"`
+	ContentType        string                 `xml:"contentType,attr"`
+	MimeType           string                 `xml:"mimeType,attr"`
+	Lang               string                 `xml:"lang,attr"`
+	Label              string                 `xml:"label,attr"`
+	Codecs             string                 `xml:"codecs,attr"`
+	Width              string                 `xml:"width,attr"`
+	Height             string                 `xml:"height,attr"`
+	FrameRate          string                 `xml:"frameRate,attr"`
+	BaseURL            []string               `xml:"BaseURL"`
+	Roles              []mpdDescriptor        `xml:"Role"`
+	AudioChannels      []mpdDescriptor        `xml:"AudioChannelConfiguration"`
+	ContentProtections []mpdContentProtection `xml:"ContentProtection"`
+	SegmentTemplate    *mpdSegmentTemplate    `xml:"SegmentTemplate"`
+	SegmentList        *mpdSegmentList        `xml:"SegmentList"`
+	SegmentBase        *mpdSegmentBase        `xml:"SegmentBase"`
+	Representations    []mpdRepresentation    `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID                 string                 `xml:"id,attr"`
+	Bandwidth          int64                  `xml:"bandwidth,attr"`
+	Codecs             string                 `xml:"codecs,attr"`
+	MimeType           string                 `xml:"mimeType,attr"`
+	Width              string                 `xml:"width,attr"`
+	Height             string                 `xml:"height,attr"`
+	FrameRate          string                 `xml:"frameRate,attr"`
+	BaseURL            []string               `xml:"BaseURL"`
+	AudioChannels      []mpdDescriptor        `xml:"AudioChannelConfiguration"`
+	ContentProtections []mpdContentProtection `xml:"ContentProtection"`
+	SegmentTemplate    *mpdSegmentTemplate    `xml:"SegmentTemplate"`
+	SegmentList        *mpdSegmentList        `xml:"SegmentList"`
+	SegmentBase        *mpdSegmentBase        `xml:"SegmentBase"`
+}
+
+type mpdDescriptor struct {
+	SchemeIdUri string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+type mpdContentProtection struct {
+	SchemeIdUri string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+	DefaultKID  string `xml:"default_KID,attr"`
+}
+
+type mpdSegmentTemplate struct {
+	Media                  string       `xml:"media,attr"`
+	Initialization         string       `xml:"initialization,attr"`
+	StartNumber            *int64       `xml:"startNumber,attr"`
+	Timescale              *int64       `xml:"timescale,attr"`
+	Duration               *int64       `xml:"duration,attr"`
+	PresentationTimeOffset *int64       `xml:"presentationTimeOffset,attr"`
+	Timeline               *mpdTimeline `xml:"SegmentTimeline"`
+}
+
+type mpdTimeline struct {
+	S []mpdTimelineS `xml:"S"`
+}
+
+type mpdTimelineS struct {
+	T *int64 `xml:"t,attr"`
+	D int64  `xml:"d,attr"`
+	R int64  `xml:"r,attr"`
+}
+
+type mpdSegmentList struct {
+	Timescale      *int64          `xml:"timescale,attr"`
+	Duration       *int64          `xml:"duration,attr"`
+	StartNumber    *int64          `xml:"startNumber,attr"`
+	Initialization *mpdURL         `xml:"Initialization"`
+	Timeline       *mpdTimeline    `xml:"SegmentTimeline"`
+	SegmentURLs    []mpdSegmentURL `xml:"SegmentURL"`
+}
+
+type mpdSegmentBase struct {
+	IndexRange     string  `xml:"indexRange,attr"`
+	Timescale      *int64  `xml:"timescale,attr"`
+	Initialization *mpdURL `xml:"Initialization"`
+}
+
+type mpdURL struct {
+	SourceURL string `xml:"sourceURL,attr"`
+	Range     string `xml:"range,attr"`
+}
+
+type mpdSegmentURL struct {
+	Media      string `xml:"media,attr"`
+	MediaRange string `xml:"mediaRange,attr"`
+}
+
+// 一条媒体流(mpd的Representation或ism的QualityLevel)，多个Period中相同的Representation合并为一条
+type mediaStream struct {
+	Key        string
+	Type       string
+	Bandwidth  int64
+	Codecs     string
+	Width      string
+	Height     string
+	FrameRate  string
+	Lang       string
+	Name       string
+	Channels   string
+	Role       string
+	Scheme     string
+	KID        string
+	Segments   []*Segment
+	Playlist   string // 已有的m3u8内容，非空时直接写入
+	URI        string // 远程m3u8地址，非空时不生成本地文件
+	Live       bool
+	periodSeen bool
+}
+
+// mpd解析器，将mpd转换为本地m3u8(主列表+各媒体列表)，后续流程与m3u8一致
+type mpdParser struct {
+	DownDir string
+	MpdUrl  string
+	BaseUrl string
+	Headers string
+	mpd     mpdRoot
+	now     time.Time
+}
+
+func NewMpdParser() *mpdParser {
+	return &mpdParser{now: time.Now()}
+}
+
+// 解析mpd，生成本地m3u8并返回主列表的file:地址
+func MpdParse(downDir string, mpdUrl string, mpdContent string, BaseUrl string, headers string) (string, error) {
+	mp := NewMpdParser()
+	mp.DownDir = downDir
+	mp.MpdUrl = mpdUrl
+	mp.BaseUrl = BaseUrl
+	mp.Headers = headers
+	return mp.Parse(mpdContent)
+}
+
+func (mp *mpdParser) Parse(mpdContent string) (string, error) {
+	if err := xml.Unmarshal([]byte(mpdContent), &mp.mpd); err != nil {
+		return "", err
+	}
+	if len(mp.mpd.Periods) == 0 {
+		return "", ErrNoPeriod
+	}
+	streams, err := mp.streams()
+	if err != nil {
+		return "", err
+	}
+	if len(streams) == 0 {
+		return "", ErrNoRepresention
+	}
+	return writeStreamPlaylists(path.Join(mp.DownDir, localPlaylistDir), streams)
+}
+
+// 按Period遍历所有Representation，生成各自的分片列表
+func (mp *mpdParser) streams() ([]*mediaStream, error) {
+	streams := []*mediaStream{}
+	index := map[string]*mediaStream{}
+	live := mp.mpd.Type == "dynamic"
+	mpdBase := mp.MpdUrl
+	if mp.BaseUrl != "" {
+		mpdBase = mp.BaseUrl
+	}
+	mpdBase = resolveBaseURL(mpdBase, mp.mpd.BaseURL)
+	periodStart := 0.0
+	for i, period := range mp.mpd.Periods {
+		if period.Start != "" {
+			periodStart = parseISODuration(period.Start)
+		}
+		periodDuration := mp.periodDuration(i, periodStart)
+		periodBase := resolveBaseURL(mpdBase, period.BaseURL)
+		for _, stream := range streams {
+			stream.periodSeen = false
+		}
+		for _, set := range period.AdaptationSets {
+			setBase := resolveBaseURL(periodBase, set.BaseURL)
+			setTemplate := mergeSegmentTemplate(period.SegmentTemplate, set.SegmentTemplate)
+			for _, rep := range set.Representations {
+				stream := newMpdStream(set, rep)
+				stream.Live = live
+				base := resolveBaseURL(setBase, rep.BaseURL)
+				template := mergeSegmentTemplate(setTemplate, rep.SegmentTemplate)
+				segments, err := mp.segments(base, rep, template, firstSegmentList(rep.SegmentList, set.SegmentList, period.SegmentList),
+					firstSegmentBase(rep.SegmentBase, set.SegmentBase, period.SegmentBase), periodStart, periodDuration)
+				if err != nil {
+					return nil, err
+				}
+				if existing, ok := index[stream.Key]; ok && !existing.periodSeen {
+					// 新的Period以不连续标记开始
+					if len(segments) > 0 && len(existing.Segments) > 0 {
+						segments[0].Discontinuity = true
+					}
+					existing.Segments = append(existing.Segments, segments...)
+					existing.periodSeen = true
+					continue
+				}
+				stream.Segments = segments
+				stream.periodSeen = true
+				index[stream.Key] = stream
+				streams = append(streams, stream)
+			}
+		}
+		periodStart += periodDuration
+	}
+	return streams, nil
+}
+
+// Period时长：自身duration，或下一个Period的start，或整个节目的时长
+func (mp *mpdParser) periodDuration(i int, periodStart float64) float64 {
+	period := mp.mpd.Periods[i]
+	if period.Duration != "" {
+		return parseISODuration(period.Duration)
+	}
+	if i+1 < len(mp.mpd.Periods) && mp.mpd.Periods[i+1].Start != "" {
+		return parseISODuration(mp.mpd.Periods[i+1].Start) - periodStart
+	}
+	if mp.mpd.MediaPresentationDuration != "" {
+		return parseISODuration(mp.mpd.MediaPresentationDuration) - periodStart
+	}
+	return 0
+}
+
+func newMpdStream(set mpdAdaptationSet, rep mpdRepresentation) *mediaStream {
+	stream := &mediaStream{
+		Bandwidth: rep.Bandwidth,
+		Codecs:    tool.IfString(rep.Codecs != "", rep.Codecs, set.Codecs),
+		Width:     tool.IfString(rep.Width != "", rep.Width, set.Width),
+		Height:    tool.IfString(rep.Height != "", rep.Height, set.Height),
+		FrameRate: tool.IfString(rep.FrameRate != "", rep.FrameRate, set.FrameRate),
+		Lang:      set.Lang,
+	}
+	stream.Type = mpdContentType(tool.IfString(rep.MimeType != "", rep.MimeType, set.MimeType), set.ContentType, stream.Codecs)
+	channels := rep.AudioChannels
+	if len(channels) == 0 {
+		channels = set.AudioChannels
+	}
+	if len(channels) > 0 {
+		stream.Channels = channels[0].Value
+	}
+	for _, role := range set.Roles {
+		stream.Role = role.Value
+	}
+	protections := append(append([]mpdContentProtection{}, set.ContentProtections...), rep.ContentProtections...)
+	for _, protection := range protections {
+		if protection.SchemeIdUri == "urn:mpeg:dash:mp4protection:2011" {
+			stream.Scheme = protection.Value
+		}
+		if protection.DefaultKID != "" {
+			stream.KID = strings.ToLower(strings.ReplaceAll(protection.DefaultKID, "-", ""))
+		}
+	}
+	if stream.Scheme == "" && len(protections) > 0 {
+		stream.Scheme = "cenc"
+	}
+	name := []string{}
+	if set.Label != "" {
+		name = append(name, set.Label)
+	} else if stream.Lang != "" {
+		name = append(name, stream.Lang)
+	}
+	name = append(name, fmt.Sprintf("%d Kbps", stream.Bandwidth/1000))
+	if stream.Codecs != "" {
+		name = append(name, stream.Codecs)
+	}
+	stream.Name = strings.Join(name, " ")
+	// Representation没有id时以类型与属性区分
+	stream.Key = rep.ID
+	if stream.Key == "" {
+		stream.Key = strings.Join([]string{stream.Type, stream.Lang, stream.Codecs, strconv.FormatInt(stream.Bandwidth, 10)}, "|")
+	}
+	return stream
+}
+
+func mpdContentType(mimeType string, contentType string, codecs string) string {
+	value := strings.ToLower(mimeType + " " + contentType)
+	switch {
+	case strings.Contains(value, "video"):
+		return "video"
+	case strings.Contains(value, "audio"):
+		return "audio"
+	case strings.Contains(value, "text"), strings.Contains(codecs, "stpp"), strings.Contains(codecs, "wvtt"):
+		return "text"
+	}
+	return "video"
+}
+
+// 生成Representation在一个Period中的分片
+func (mp *mpdParser) segments(base string, rep mpdRepresentation, template *mpdSegmentTemplate, list *mpdSegmentList, segBase *mpdSegmentBase, periodStart float64, periodDuration float64) ([]*Segment, error) {
+	switch {
+	case template != nil && template.Media != "":
+		return mp.templateSegments(base, rep, template, periodStart, periodDuration), nil
+	case list != nil:
+		return mp.listSegments(base, list, periodDuration), nil
+	case segBase != nil:
+		return mp.baseSegments(base, segBase, periodDuration)
+	}
+	// 只有BaseURL时整个文件为一个分片
+	return []*Segment{{URI: base, Duration: periodDuration}}, nil
+}
+
+func (mp *mpdParser) templateSegments(base string, rep mpdRepresentation, template *mpdSegmentTemplate, periodStart float64, periodDuration float64) []*Segment {
+	timescale := int64Value(template.Timescale, 1)
+	number := int64Value(template.StartNumber, 1)
+	offset := int64Value(template.PresentationTimeOffset, 0)
+	var initMap *Map
+	if template.Initialization != "" {
+		initMap = &Map{URI: resolveURL(base, expandTemplate(template.Initialization, rep, 0, 0))}
+	}
+	segments := []*Segment{}
+	add := func(num int64, t int64, d int64) {
+		segments = append(segments, &Segment{
+			URI:      resolveURL(base, expandTemplate(template.Media, rep, num, t)),
+			Duration: float64(d) / float64(timescale),
+			Map:      initMap,
+		})
+	}
+	if template.Timeline != nil {
+		t := offset
+		end := offset + int64(periodDuration*float64(timescale))
+		for i, s := range template.Timeline.S {
+			if s.T != nil {
+				t = *s.T
+			}
+			if s.D <= 0 {
+				continue
+			}
+			repeat := s.R
+			if repeat < 0 {
+				// r=-1表示重复到下一个S或Period结束
+				next := end
+				if i+1 < len(template.Timeline.S) && template.Timeline.S[i+1].T != nil {
+					next = *template.Timeline.S[i+1].T
+				}
+				if mp.mpd.Type == "dynamic" && next <= t {
+					next = offset + int64(mp.liveEdge(periodStart)*float64(timescale))
+				}
+				repeat = int64(math.Ceil(float64(next-t)/float64(s.D))) - 1
+			}
+			for j := int64(0); j <= repeat; j++ {
+				add(number, t, s.D)
+				number++
+				t += s.D
+			}
+		}
+		return segments
+	}
+	duration := int64Value(template.Duration, 0)
+	if duration <= 0 {
+		return segments
+	}
+	segDuration := float64(duration) / float64(timescale)
+	first, count := number, int64(math.Ceil(periodDuration/segDuration))
+	if mp.mpd.Type == "dynamic" {
+		// 直播按当前时间计算可用分片
+		latest := int64(mp.liveEdge(periodStart)/segDuration) - 1
+		window := int64(math.Ceil(mp.timeShiftBuffer() / segDuration))
+		if latest-window+1 > 0 {
+			first = number + latest - window + 1
+		}
+		count = number + latest - first + 1
+	}
+	for i := int64(0); i < count; i++ {
+		num := first + i
+		add(num, offset+(num-number)*duration, duration)
+	}
+	return segments
+}
+
+// 直播已发布的时长(相对于Period起点)
+func (mp *mpdParser) liveEdge(periodStart float64) float64 {
+	start, err := time.Parse(time.RFC3339, mp.mpd.AvailabilityStartTime)
+	if err != nil {
+		return 0
+	}
+	return mp.now.Sub(start).Seconds() - periodStart
+}
+
+func (mp *mpdParser) timeShiftBuffer() float64 {
+	if mp.mpd.TimeShiftBufferDepth != "" {
+		return parseISODuration(mp.mpd.TimeShiftBufferDepth)
+	}
+	return 60
+}
+
+func (mp *mpdParser) listSegments(base string, list *mpdSegmentList, periodDuration float64) []*Segment {
+	timescale := int64Value(list.Timescale, 1)
+	duration := int64Value(list.Duration, 0)
+	var initMap *Map
+	if list.Initialization != nil {
+		initMap = &Map{URI: resolveURL(base, list.Initialization.SourceURL), ByteRange: parseMpdRange(list.Initialization.Range)}
+	}
+	durations := []float64{}
+	if list.Timeline != nil {
+		for _, s := range list.Timeline.S {
+			for j := int64(0); j <= s.R; j++ {
+				durations = append(durations, float64(s.D)/float64(timescale))
+			}
+		}
+	}
+	segments := []*Segment{}
+	for i, segUrl := range list.SegmentURLs {
+		seg := &Segment{URI: resolveURL(base, segUrl.Media), ByteRange: parseMpdRange(segUrl.MediaRange), Map: initMap}
+		switch {
+		case i < len(durations):
+			seg.Duration = durations[i]
+		case duration > 0:
+			seg.Duration = float64(duration) / float64(timescale)
+		default:
+			seg.Duration = periodDuration / float64(len(list.SegmentURLs))
+		}
+		segments = append(segments, seg)
+	}
+	return segments
+}
+
+// SegmentBase需读取sidx获得各分段的字节范围
+func (mp *mpdParser) baseSegments(base string, segBase *mpdSegmentBase, periodDuration float64) ([]*Segment, error) {
+	var initMap *Map
+	if segBase.Initialization != nil {
+		initUrl := base
+		if segBase.Initialization.SourceURL != "" {
+			initUrl = resolveURL(base, segBase.Initialization.SourceURL)
+		}
+		initMap = &Map{URI: initUrl, ByteRange: parseMpdRange(segBase.Initialization.Range)}
+	}
+	indexRange := parseMpdRange(segBase.IndexRange)
+	if indexRange == nil {
+		return []*Segment{{URI: base, Duration: periodDuration, Map: initMap}}, nil
+	}
+	if initMap == nil && indexRange.Offset > 0 {
+		initMap = &Map{URI: base, ByteRange: &ByteRange{Length: indexRange.Offset, Offset: 0}}
+	}
+	body, err := download.HttpDownloadStream(base, mp.Headers, 60, indexRange.Offset, indexRange.Length)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	sidx, ok := mp4.ReadSidx(data)
+	if !ok || sidx.Timescale == 0 {
+		return nil, ErrSidx
+	}
+	segments := []*Segment{}
+	offset := indexRange.Offset + sidx.Anchor + sidx.FirstOffset
+	for _, ref := range sidx.References {
+		segments = append(segments, &Segment{
+			URI:       base,
+			Duration:  float64(ref.Duration) / float64(sidx.Timescale),
+			ByteRange: &ByteRange{Length: ref.Size, Offset: offset},
+			Map:       initMap,
+		})
+		offset += ref.Size
+	}
+	return segments, nil
+}
+
+// 为每条媒体流生成m3u8，并生成引用它们的主列表，返回主列表的file:地址
+func writeStreamPlaylists(dir string, streams []*mediaStream) (string, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	master := &MasterPlaylist{Version: 6}
+	hasVideo := false
+	for _, stream := range streams {
+		if stream.Type == "video" {
+			hasVideo = true
+		}
+	}
+	defaultAudio := bestStream(streams, "audio")
+	for i, stream := range streams {
+		uri, err := stream.writePlaylist(path.Join(dir, fmt.Sprintf("%s_%d.m3u8", stream.Type, i)))
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case stream.Type == "video" || (!hasVideo && stream.Type == "audio"):
+			variant := &Variant{
+				URI:       uri,
+				Bandwidth: stream.Bandwidth,
+				Codecs:    stream.Codecs,
+			}
+			if stream.Width != "" && stream.Height != "" {
+				variant.Resolution = stream.Width + "x" + stream.Height
+			}
+			variant.FrameRate = parseFrameRate(stream.FrameRate)
+			if hasVideo && defaultAudio != nil {
+				variant.Audio = "audio"
+			}
+			master.Variants = append(master.Variants, variant)
+		case stream.Type == "audio":
+			master.Renditions = append(master.Renditions, &Rendition{
+				Type:       "AUDIO",
+				URI:        uri,
+				GroupID:    "audio",
+				Language:   stream.Lang,
+				Name:       stream.Name,
+				Channels:   stream.Channels,
+				Default:    stream == defaultAudio,
+				AutoSelect: true,
+			})
+		case stream.Type == "text":
+			master.Renditions = append(master.Renditions, &Rendition{
+				Type:     "SUBTITLES",
+				URI:      uri,
+				GroupID:  "subs",
+				Language: stream.Lang,
+				Name:     stream.Name,
+				Forced:   stream.Role == "forced-subtitle",
+			})
+		}
+	}
+	hasSubs := false
+	for _, rendition := range master.Renditions {
+		if rendition.Type == "SUBTITLES" {
+			hasSubs = true
+		}
+	}
+	if hasSubs {
+		for _, variant := range master.Variants {
+			variant.Subtitles = "subs"
+		}
+	}
+	masterPath := path.Join(dir, "master.m3u8")
+	if err := tool.WriteFile(masterPath, master.Encode()); err != nil {
+		return "", err
+	}
+	return tool.PathToUrl(masterPath)
+}
+
+// 写入媒体列表并返回其地址
+func (s *mediaStream) writePlaylist(playlistPath string) (string, error) {
+	if s.URI != "" {
+		return s.URI, nil
+	}
+	content := s.Playlist
+	if content == "" {
+		content = s.mediaPlaylist().Encode()
+	}
+	if err := tool.WriteFile(playlistPath, content); err != nil {
+		return "", err
+	}
+	return tool.PathToUrl(playlistPath)
+}
+
+// 生成媒体列表
+func (s *mediaStream) mediaPlaylist() *MediaPlaylist {
+	playlist := &MediaPlaylist{Version: 6, EndList: !s.Live, Segments: s.Segments}
+	if !s.Live {
+		playlist.PlaylistType = "VOD"
+	}
+	var keys []*Key
+	if s.Scheme != "" {
+		// 加密内容交由 --key 按KID解密
+		method := "SAMPLE-AES-CTR"
+		if s.Scheme == "cbcs" || s.Scheme == "cbc1" {
+			method = "SAMPLE-AES"
+		}
+		keys = []*Key{{Method: method, URI: "data:text/plain;kid=" + s.KID, KeyFormat: "urn:mpeg:dash:mp4protection:2011"}}
+	}
+	maxDuration := 0.0
+	for _, seg := range s.Segments {
+		seg.Keys = keys
+		if seg.Duration > maxDuration {
+			maxDuration = seg.Duration
+		}
+	}
+	playlist.TargetDuration = int64(math.Ceil(maxDuration))
+	return playlist
+}
+
+func bestStream(streams []*mediaStream, streamType string) *mediaStream {
+	var best *mediaStream
+	for _, stream := range streams {
+		if stream.Type == streamType && (best == nil || stream.Bandwidth > best.Bandwidth) {
+			best = stream
+		}
+	}
+	return best
+}
+
+// 子级的SegmentTemplate属性覆盖父级
+func mergeSegmentTemplate(parent *mpdSegmentTemplate, child *mpdSegmentTemplate) *mpdSegmentTemplate {
+	if parent == nil {
+		return child
+	}
+	if child == nil {
+		return parent
+	}
+	merged := *parent
+	if child.Media != "" {
+		merged.Media = child.Media
+	}
+	if child.Initialization != "" {
+		merged.Initialization = child.Initialization
+	}
+	if child.StartNumber != nil {
+		merged.StartNumber = child.StartNumber
+	}
+	if child.Timescale != nil {
+		merged.Timescale = child.Timescale
+	}
+	if child.Duration != nil {
+		merged.Duration = child.Duration
+	}
+	if child.PresentationTimeOffset != nil {
+		merged.PresentationTimeOffset = child.PresentationTimeOffset
+	}
+	if child.Timeline != nil {
+		merged.Timeline = child.Timeline
+	}
+	return &merged
+}
+
+func firstSegmentList(lists ...*mpdSegmentList) *mpdSegmentList {
+	for _, list := range lists {
+		if list != nil {
+			return list
+		}
+	}
+	return nil
+}
+
+func firstSegmentBase(bases ...*mpdSegmentBase) *mpdSegmentBase {
+	for _, base := range bases {
+		if base != nil {
+			return base
+		}
+	}
+	return nil
+}
+
+var templateRegexp = regexp.MustCompile(`\$(RepresentationID|Number|Time|Bandwidth)(%0(\d+)d)?\$`)
+
+// 替换 $RepresentationID$、$Number$、$Time$、$Bandwidth$ 及 $$
+func expandTemplate(template string, rep mpdRepresentation, number int64, t int64) string {
+	parts := strings.Split(template, "$$")
+	for i, part := range parts {
+		parts[i] = templateRegexp.ReplaceAllStringFunc(part, func(match string) string {
+			sub := templateRegexp.FindStringSubmatch(match)
+			if sub[1] == "RepresentationID" {
+				return rep.ID
+			}
+			value := number
+			switch sub[1] {
+			case "Time":
+				value = t
+			case "Bandwidth":
+				value = rep.Bandwidth
+			}
+			if sub[3] != "" {
+				width, _ := strconv.Atoi(sub[3])
+				return fmt.Sprintf("%0*d", width, value)
+			}
+			return strconv.FormatInt(value, 10)
+		})
+	}
+	return strings.Join(parts, "$")
+}
+
+// 逐级解析BaseURL
+func resolveBaseURL(base string, baseUrls []string) string {
+	if len(baseUrls) == 0 || strings.TrimSpace(baseUrls[0]) == "" {
+		return base
+	}
+	return resolveURL(base, strings.TrimSpace(baseUrls[0]))
+}
+
+func resolveURL(base string, ref string) string {
+	baseUrl, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	refUrl, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return baseUrl.ResolveReference(refUrl).String()
+}
+
+// 将 "start-end" 转换为字节范围
+func parseMpdRange(value string) *ByteRange {
+	t := strings.Split(strings.TrimSpace(value), "-")
+	if len(t) != 2 {
+		return nil
+	}
+	start, err := strconv.ParseInt(t[0], 10, 64)
+	if err != nil {
+		return nil
+	}
+	end, err := strconv.ParseInt(t[1], 10, 64)
+	if err != nil || end < start {
+		return nil
+	}
+	return &ByteRange{Length: end - start + 1, Offset: start}
+}
+
+var isoDurationRegexp = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)Y)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// 解析ISO 8601时长，如 PT1H2M3.5S，返回秒数
+func parseISODuration(value string) float64 {
+	sub := isoDurationRegexp.FindStringSubmatch(strings.TrimSpace(value))
+	if sub == nil {
+		return 0
+	}
+	units := []float64{365 * 86400, 30 * 86400, 86400, 3600, 60, 1}
+	seconds := 0.0
+	for i, unit := range units {
+		if sub[i+1] != "" {
+			number, _ := strconv.ParseFloat(sub[i+1], 64)
+			seconds += number * unit
+		}
+	}
+	return seconds
+}
+
+// 帧率可能为分数形式，如 30000/1001
+func parseFrameRate(value string) float64 {
+	if index := strings.Index(value, "/"); index != -1 {
+		a, _ := strconv.ParseFloat(value[:index], 64)
+		b, _ := strconv.ParseFloat(value[index+1:], 64)
+		if b == 0 {
+			return 0
+		}
+		return math.Round(a/b*1000) / 1000
+	}
+	number, _ := strconv.ParseFloat(value, 64)
+	return number
+}
+
+func int64Value(value *int64, def int64) int64 {
+	if value == nil {
+		return def
+	}
+	return *value
 }
@@ -0,0 +1,27 @@
+package parser
+
+import "time"
+
+// 解析时选择分片与处理广告的设置，每个解析器各自持有，互不影响
+type ParseOptions struct {
+	RangeStart      int64 // --downloadRange 指定的分片序号范围，RangeEnd为-1时到结尾
+	RangeEnd        int64
+	DateStart       time.Time // --downloadRange 指定的绝对时间范围，为零值时不限制
+	DateEnd         time.Time
+	TimeRanges      []TimeRange // --downloadRange 指定的时长范围
+	DelAd           bool        // 按URL特征删除优酷等的广告分片
+	AdPolicy        string      // 按广告标记处理分片的方式
+	ClassifyParts   bool        // 按分部特征移除广告与片头
+	BumperDurations []float64   // 片头时长(秒)
+	AudioOnly       bool        // 只下载音轨
+}
+
+func NewParseOptions() ParseOptions {
+	return ParseOptions{
+		RangeEnd:        -1,
+		TimeRanges:      []TimeRange{},
+		DelAd:           true,
+		AdPolicy:        AdPolicyKeep,
+		BumperDurations: []float64{},
+	}
+}
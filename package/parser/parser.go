@@ -2,8 +2,9 @@ package parser
 
 import (
 	"bufio"
+	"encoding/base64"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -17,9 +18,6 @@ import (
 
 	"github.com/xfy520/m3u8_cli/package/decode"
 	"github.com/xfy520/m3u8_cli/package/download"
-	"github.com/xfy520/m3u8_cli/package/download/downloadManager"
-	"github.com/xfy520/m3u8_cli/package/ffmpeg"
-	"github.com/xfy520/m3u8_cli/package/global"
 	"github.com/xfy520/m3u8_cli/package/lang"
 	"github.com/xfy520/m3u8_cli/package/log"
 	"github.com/xfy520/m3u8_cli/package/request"
@@ -32,25 +30,16 @@ type audio struct {
 	Language string
 	Uri      string
 	Channels string
-	ToString func() string
+	ToString func() string `json:"-"`
 }
 
 type subtitle struct {
 	Name     string
 	Language string
 	Uri      string
-	ToString func() string
+	ToString func() string `json:"-"`
 }
 
-var (
-	hasAd            = false
-	RangeStart int64 = 0
-	RangeEnd   int64 = -1
-	DelAd            = true
-	DurStart         = ""
-	DurEnd           = ""
-)
-
 type segInfoObj struct {
 	ExpectByte int64   `json:"expectByte"`
 	StartByte  int64   `json:"startByte"`
@@ -60,6 +49,10 @@ type segInfoObj struct {
 	Iv         string  `json:"iv,omitempty"`
 	Duration   float64 `json:"duration"`
 	SegUri     string  `json:"segUri,omitempty"`
+	Ad         bool    `json:"ad,omitempty"`
+	MapIndex   int     `json:"map,omitempty"` // ExtMAPs中的序号，从1开始，0表示没有初始化分段
+	// 分片的开始时间，由#EXT-X-PROGRAM-DATE-TIME按时长推算，遇到不连续标记时重新开始
+	ProgramDateTime string `json:"programDateTime,omitempty"`
 }
 
 type jsonResultObj struct {
@@ -70,15 +63,48 @@ type jsonResultObj struct {
 }
 
 type jsonM3u8InfoObj struct {
-	OriginalCount  int64          `json:"originalCount"`
-	Count          int64          `json:"count"`
-	Vod            bool           `json:"vod"`
-	TargetDuration int64          `json:"targetDuration"`
-	TotalDuration  float64        `json:"totalDuration"`
-	Audio          string         `json:"audio,omitempty"`
-	Sub            string         `json:"sub,omitempty"`
-	ExtMAP         string         `json:"extMAP,omitempty"`
-	Segments       [][]segInfoObj `json:"segments,omitempty"`
+	OriginalCount  int64            `json:"originalCount"`
+	Count          int64            `json:"count"`
+	Vod            bool             `json:"vod"`
+	TargetDuration int64            `json:"targetDuration"`
+	TotalDuration  float64          `json:"totalDuration"`
+	PartTarget     float64          `json:"partTarget,omitempty"`
+	RecTime        string           `json:"recTime,omitempty"`     // 第一个分片的EXT-X-PROGRAM-DATE-TIME，作为录制时间
+	BinaryMerge    bool             `json:"binaryMerge,omitempty"` // 存在无法解密的分片，需要二进制合并
+	Audios         []jsonMediaObj   `json:"audios,omitempty"`
+	Subtitles      []jsonMediaObj   `json:"subtitles,omitempty"`
+	ExtMAP         string           `json:"extMAP,omitempty"`
+	ExtMAPs        []string         `json:"extMAPs,omitempty"`
+	Segments       [][]segInfoObj   `json:"segments,omitempty"`
+	AdBreaks       []adBreakObj     `json:"adBreaks,omitempty"`
+	RemovedParts   []removedPartObj `json:"removedParts,omitempty"`
+	Clips          []clipObj        `json:"clips,omitempty"`
+}
+
+// meta.json中选中的音轨或字幕
+type jsonMediaObj struct {
+	GroupId         string `json:"groupId,omitempty"`
+	Name            string `json:"name,omitempty"`
+	Language        string `json:"language,omitempty"`
+	Channels        string `json:"channels,omitempty"`
+	Characteristics string `json:"characteristics,omitempty"`
+	Default         bool   `json:"default,omitempty"`
+	Forced          bool   `json:"forced,omitempty"`
+	Uri             string `json:"uri,omitempty"`
+	Dir             string `json:"dir,omitempty"` // 单独下载时的目录，相对于下载目录
+}
+
+func newMediaObj(rendition *Rendition) jsonMediaObj {
+	return jsonMediaObj{
+		GroupId:         rendition.GroupID,
+		Name:            rendition.Name,
+		Language:        rendition.Language,
+		Channels:        rendition.Channels,
+		Characteristics: rendition.Characteristics,
+		Default:         rendition.Default,
+		Forced:          rendition.Forced,
+		Uri:             rendition.URI,
+	}
 }
 
 func newAudio(Name string, Language string, Uri string, Channels string) *audio {
@@ -96,16 +122,22 @@ func newSubtitle(Name string, Language string, Uri string) *subtitle {
 type m3u8Parser struct {
 	downloadingM3u8KeyTip bool
 	lastKeyLine           string
-	m3u8CurrentKey        []string
+	m3u8CurrentKey        *Key
+	userKey               *Key
+	keyCache              map[string][]byte
 	m3u8SavePath          string
 	jsonSavePath          string
-	bestBandwidth         int64
+	variants              []*Variant
+	renditions            []*Rendition
+	audios                []*Rendition
+	subtitles             []*Rendition
 	bestUrl               string
 	bestUrlAudio          string
 	bestUrlSub            string
 	audioUrl              string
 	subUrl                string
 	extLists              []string
+	variables             map[string]string // 主列表中#EXT-X-DEFINE定义的变量
 	BaseUrl               string
 	M3u8Url               string
 	DownDir               string
@@ -114,9 +146,17 @@ type m3u8Parser struct {
 	KeyFile               string
 	KeyBase64             string
 	LiveStream            bool
+	manifest              string // 由mpd或ism转换为本地m3u8时的原始格式
+	lowLatency            bool
+	live                  bool
 	KeyIV                 string
+	VideoSelector         *VariantSelector
+	AudioSelector         *RenditionSelector
+	SubtitleSelector      *RenditionSelector
 	media_audio_group     map[string][]audio
 	media_sub_group       map[string][]subtitle
+	LogFile               string // 为空时写入全局日志
+	ParseOptions
 }
 
 func NewM3u8Parser() *m3u8Parser {
@@ -125,28 +165,32 @@ func NewM3u8Parser() *m3u8Parser {
 		media_audio_group:     map[string][]audio{},
 		media_sub_group:       map[string][]subtitle{},
 		lastKeyLine:           "",
-		m3u8CurrentKey:        []string{"NONE", "", ""},
+		m3u8CurrentKey:        &Key{Method: "NONE"},
+		keyCache:              map[string][]byte{},
 		m3u8SavePath:          "",
 		jsonSavePath:          "",
-		bestBandwidth:         0,
 		bestUrl:               "",
 		bestUrlAudio:          "",
 		bestUrlSub:            "",
 		audioUrl:              "",
 		subUrl:                "",
 		extLists:              []string{},
+		ParseOptions:          NewParseOptions(),
 	}
 }
 
-func (p *m3u8Parser) M3u8Parse() {
-	ffmpeg.REC_TIME = ""
+func (p *m3u8Parser) M3u8Parse() error {
 	p.m3u8SavePath = path.Join(p.DownDir, "raw.m3u8")
 	p.jsonSavePath = path.Join(p.DownDir, "meta.json")
 	if !tool.Exists(p.DownDir) {
-		tool.Check(os.MkdirAll(p.DownDir, os.ModePerm))
+		if err := os.MkdirAll(p.DownDir, os.ModePerm); err != nil {
+			return err
+		}
 	}
 
 	p.extLists = []string{}
+	p.variants = []*Variant{}
+	p.renditions = []*Rendition{}
 
 	p.media_audio_group = make(map[string][]audio)
 	p.media_sub_group = map[string][]subtitle{}
@@ -158,17 +202,25 @@ func (p *m3u8Parser) M3u8Parse() {
 		segments       []segInfoObj = []segInfoObj{}
 		segInfo        segInfoObj   = segInfoObj{}
 		m3u8Content    string       = ""
-		extMAP         []string     = []string{"", ""}
-		extList        []string     = []string{}
+		extMAPs        []string     = []string{}
+		mapIndex       int          = 0
 		segIndex       int64        = 0
+		msn            int64        = 0 // 媒体序列号，每个分片地址加一，去除广告时也不回退
 		startIndex     int64        = 0
 		targetDuration int64        = 0
 		totalDuration  float64      = 0
+		partTarget     float64      = 0
 		expectSegment  bool         = false
-		expectPlaylist bool         = false
 		isEndlist      bool         = false
 		isAd           bool         = false
-		isM3u          bool         = false
+		ads            *adTracker   = newAdTracker()
+		adSegment      bool         = false
+		adCount        int64        = 0
+		adDuration     float64      = 0
+		hasAd          bool         = false
+		recTime        string       = ""
+		// 按分部特征移除广告与片头，Disney+的片头使用独立的分部
+		classify bool = p.ClassifyParts || strings.Contains(p.M3u8Url, "media.dssott.com/")
 	)
 
 	if strings.Contains(p.M3u8Url, ".cntv.") {
@@ -178,265 +230,355 @@ func (p *m3u8Parser) M3u8Parse() {
 	if strings.HasPrefix(p.M3u8Url, "http") {
 		if strings.Contains(p.M3u8Url, "nfmovies.com/hls") {
 			infbytes, err := download.HttpDownloadFileToBytes(p.M3u8Url, p.Headers, 60)
-			tool.Check(err)
+			if err != nil {
+				return &ParseError{Url: p.M3u8Url, Err: err}
+			}
 			m3u8Content = decode.NfmoviesDecryptM3u8(infbytes)
 		} else if strings.Contains(p.M3u8Url, "hls.ddyunp.com/ddyun") || strings.Contains(p.M3u8Url, "hls.90mm.me/ddyun") {
 			m3u8Url, err := decode.GetVaildM3u8Url(p.M3u8Url)
-			tool.Check(err)
+			if err != nil {
+				return &ParseError{Url: p.M3u8Url, Err: err}
+			}
 			infbytes, err := download.HttpDownloadFileToBytes(m3u8Url, p.Headers, 60)
-			tool.Check(err)
+			if err != nil {
+				return &ParseError{Url: p.M3u8Url, Err: err}
+			}
 			m3u8Content = decode.DdyunDecryptM3u8(infbytes)
 		} else {
 			infbytes, err := download.GetWebSource(p.M3u8Url, p.Headers, 60)
-			tool.Check(err)
+			if err != nil {
+				return &ParseError{Url: p.M3u8Url, Err: err}
+			}
 			m3u8Content = tool.BytesToStr(infbytes)
 		}
 	} else if strings.HasPrefix(p.M3u8Url, "file:") {
 		u, err := url.Parse(p.M3u8Url)
-		tool.Check(err)
-		uri := u.Path
-		sysType := runtime.GOOS
-		uri = tool.IfString(sysType == "windows", uri[:1], uri)
-		infbytes, err := tool.ReadFile(uri)
-		tool.Check(err)
+		if err != nil {
+			return &ParseError{Url: p.M3u8Url, Err: err}
+		}
+		infbytes, err := tool.ReadFile(tool.UrlToPath(u))
+		if err != nil {
+			return &ParseError{Url: p.M3u8Url, Err: err}
+		}
 		m3u8Content = tool.BytesToStr(infbytes)
 	} else if tool.Exists(p.M3u8Url) {
 		infbytes, err := tool.ReadFile(p.M3u8Url)
-		tool.Check(err)
+		if err != nil {
+			return &ParseError{Url: p.M3u8Url, Err: err}
+		}
 		m3u8Content = tool.BytesToStr(infbytes)
 		if !strings.Contains(m3u8Content, "\\") {
 			_, filename, _, _ := runtime.Caller(1)
 			p.M3u8Url = path.Join(path.Dir(filename), p.M3u8Url)
 		}
 		u, err := url.Parse(p.M3u8Url)
-		tool.Check(err)
+		if err != nil {
+			return &ParseError{Url: p.M3u8Url, Err: err}
+		}
 		p.M3u8Url = u.String()
 	}
 
 	if m3u8Content == "" {
-		tool.Check(errors.New(lang.Lang.ParseError))
+		return &ParseError{Url: p.M3u8Url, Err: ErrEmptyM3u8}
 	}
 
-	if strings.Contains(p.M3u8Url, "tlivecloud-playback-cdn.ysp.cctv.cn") && strings.Contains(p.M3u8Url, "endtime") {
+	if isPlaybackUrl(p.M3u8Url, time.Now()) { //结束时间已过的回看地址
 		isEndlist = true
 	}
 
 	if strings.Contains(p.M3u8Url, "imooc.com/") {
 		m3u8Data, err := decode.ImoocDecodeM3u8(m3u8Content)
-		tool.Check(err)
+		if err != nil {
+			return &ParseError{Url: p.M3u8Url, Err: err}
+		}
 		m3u8Content = m3u8Data
 	}
 
-	// mpd暂定
+	m3u8Content = decodeUTF16(m3u8Content)
+
+	// mpd转换为本地m3u8后按m3u8流程解析
 	if strings.Contains(m3u8Content, "</MPD>") && strings.Contains(m3u8Content, "<MPD") {
 		mpdSavePath := path.Join(p.DownDir, "dash.mpd")
-		tool.WriteFile(mpdSavePath, m3u8Content)
-		req, err := request.New(p.M3u8Url, http.MethodGet, 5, false)
-		tool.Check(err)
-		req.SetHeaders(p.Headers)
-		m3u8Url, err := req.Get302()
-		tool.Check(err)
-		p.M3u8Url = m3u8Url
+		if err := tool.WriteFile(mpdSavePath, m3u8Content); err != nil {
+			return err
+		}
+		if strings.HasPrefix(p.M3u8Url, "http") {
+			req, err := request.New(p.M3u8Url, http.MethodGet, 5, false)
+			if err != nil {
+				return &ParseError{Url: p.M3u8Url, Err: err}
+			}
+			if err := req.SetHeaders(p.Headers); err != nil {
+				return &ParseError{Url: p.M3u8Url, Err: err}
+			}
+			m3u8Url, err := req.Get302()
+			if err != nil {
+				return &ParseError{Url: p.M3u8Url, Err: err}
+			}
+			p.M3u8Url = m3u8Url
+		}
 		// 分析mpd文件
-		newUrl := MpdParse(p.DownDir, p.M3u8Url, m3u8Content, p.BaseUrl)
+		newUrl, err := MpdParse(p.DownDir, p.M3u8Url, m3u8Content, p.BaseUrl, p.Headers)
+		if err != nil {
+			return &ParseError{Url: p.M3u8Url, Err: err}
+		}
+		p.manifest = "mpd"
 		p.M3u8Url = newUrl
+		p.BaseUrl = ""
+		u, err := url.Parse(p.M3u8Url)
+		if err != nil {
+			return &ParseError{Url: p.M3u8Url, Err: err}
+		}
+		byt, err := tool.ReadFile(tool.UrlToPath(u))
+		if err != nil {
+			return &ParseError{Url: p.M3u8Url, Err: err}
+		}
+		m3u8Content = tool.BytesToStr(byt)
 	}
 
-	// iq暂定
-	if strings.HasPrefix(m3u8Content, `{"payload"`) {
+	// ism同样转换为本地m3u8
+	if isIsmContent(m3u8Content) {
+		if err := tool.WriteFile(path.Join(p.DownDir, "manifest.ism"), m3u8Content); err != nil {
+			return err
+		}
+		newUrl, err := IsmParse(p.DownDir, p.M3u8Url, m3u8Content, p.BaseUrl, p.LogFile)
+		if err != nil {
+			return &ParseError{Url: p.M3u8Url, Err: err}
+		}
+		p.manifest = "ism"
+		p.M3u8Url = newUrl
+		p.BaseUrl = ""
+		u, err := url.Parse(p.M3u8Url)
+		if err != nil {
+			return &ParseError{Url: p.M3u8Url, Err: err}
+		}
+		byt, err := tool.ReadFile(tool.UrlToPath(u))
+		if err != nil {
+			return &ParseError{Url: p.M3u8Url, Err: err}
+		}
+		m3u8Content = tool.BytesToStr(byt)
+	}
+
+	// iq的json转换为本地m3u8
+	if strings.HasPrefix(strings.TrimSpace(m3u8Content), `{"payload"`) {
 		iqJsonPath := path.Join(p.DownDir, "iq.json")
-		tool.WriteFile(iqJsonPath, m3u8Content)
+		if err := tool.WriteFile(iqJsonPath, m3u8Content); err != nil {
+			return err
+		}
 		// 分析json文件
 		newUrl, err := IqJsonParser(p.DownDir, m3u8Content)
-		tool.Check(err)
+		if err != nil {
+			return &ParseError{Url: p.M3u8Url, Err: err}
+		}
 		p.M3u8Url = newUrl
+		p.BaseUrl = ""
 		u, err := url.Parse(p.M3u8Url)
-		tool.Check(err)
-		sysType := runtime.GOOS
-		pat := tool.IfString(sysType == "windows", u.Path[:1], u.Path)
-		byt, err := tool.ReadFile(pat)
-		tool.Check(err)
+		if err != nil {
+			return &ParseError{Url: p.M3u8Url, Err: err}
+		}
+		byt, err := tool.ReadFile(tool.UrlToPath(u))
+		if err != nil {
+			return &ParseError{Url: p.M3u8Url, Err: err}
+		}
 		m3u8Content = tool.BytesToStr(byt)
 	}
 
-	tool.WriteFile(p.m3u8SavePath, m3u8Content)
-
-	// //针对优酷#EXT-X-VERSION:7杜比视界片源修正，暂定
-	if strings.Contains(m3u8Content, "#EXT-X-DISCONTINUITY") && strings.Contains(m3u8Content, "#EXT-X-MAP") && strings.Contains(m3u8Content, "ott.cibntv.net") && strings.Contains(m3u8Content, "ccode=") {
-		reg := regexp.MustCompile("#EXT-X-DISCONTINUITY\\s+#EXT-X-MAP:URI=\\\"(.*?)\\\",BYTERANGE=\\\"(.*?)\\\"")
-		_ = reg.FindAllString(m3u8Content, -1)
+	if err := tool.WriteFile(p.m3u8SavePath, m3u8Content); err != nil {
+		return err
 	}
 
-	//针对Disney+修正，暂定
-	if strings.Contains(m3u8Content, "#EXT-X-DISCONTINUITY") && strings.Contains(m3u8Content, "#EXT-X-MAP") && strings.Contains(p.M3u8Url, "media.dssott.com/") {
-		_ = regexp.MustCompile("#EXT-X-MAP:URI=\\\".*?BUMPER/[\\s\\S]+?#EXT-X-DISCONTINUITY")
+	// 替换#EXT-X-DEFINE定义的变量，主列表中的变量供媒体列表IMPORT
+	m3u8Content, variables, err := SubstituteVariables(m3u8Content, p.M3u8Url, p.variables)
+	if err != nil {
+		return &ParseError{Url: p.M3u8Url, Err: err}
 	}
-
-	if strings.Contains(m3u8Content, "#EXT-X-DISCONTINUITY") && strings.Contains(m3u8Content, "#EXT-X-MAP") && (strings.Contains(p.M3u8Url, ".apple.com/")) {
-		// Regex.IsMatch(m3u8Content, "#EXT-X-MAP.*\\.apple\\.com/")
-		_ = regexp.MustCompile(`(#EXT-X-KEY:[\\s\\S]*?)(#EXT-X-DISCONTINUITY|#EXT-X-ENDLIST)`)
+	if strings.Contains(m3u8Content, tags.EXT_X_STREAM_INF) {
+		p.variables = variables
 	}
 
 	// 如果BaseUrl为空则截取字符串充当
 	if p.BaseUrl == "" {
 		matched, err := regexp.MatchString("#YUMING\\|(.*)", m3u8Content)
-		tool.Check(err)
+		if err != nil {
+			return &ParseError{Url: p.M3u8Url, Err: err}
+		}
 		if matched {
 			reg := regexp.MustCompile(`#YUMING\\|(.*)`)
 			temp := reg.FindAllString(m3u8Content, -1)
 			p.BaseUrl = temp[0]
 		} else {
 			baseUrl, err := getBaseUrl(p.M3u8Url, p.Headers)
-			tool.Check(err)
+			if err != nil {
+				return &ParseError{Url: p.M3u8Url, Err: err}
+			}
 			p.BaseUrl = baseUrl
 		}
 	}
 
+	// 使用外部KEY时覆盖m3u8中的KEY内容
+	p.userKey = nil
 	if p.KeyBase64 != "" {
-		line := tool.IfString(p.KeyIV == "", `#EXT-X-KEY:METHOD=AES-128,URI="base64:`+p.KeyBase64+`"`,
-			`#EXT-X-KEY:METHOD=AES-128,URI="base64:`+p.KeyBase64+`",IV=0x`+strings.ReplaceAll(p.KeyIV, "0x", ""))
-		p.m3u8CurrentKey = p.ParseKey(line)
+		p.userKey = &Key{Method: "AES-128", URI: "base64:" + p.KeyBase64}
+	} else if p.KeyFile != "" {
+		p.userKey = &Key{Method: "AES-128", URI: p.KeyFile}
+	}
+	if p.userKey != nil {
+		if p.KeyIV != "" {
+			p.userKey.IV = "0x" + strings.TrimPrefix(strings.TrimPrefix(p.KeyIV, "0x"), "0X")
+		}
+		if _, err := p.ResolveKey(p.userKey.URI); err != nil {
+			return err
+		}
+		p.m3u8CurrentKey = p.userKey
 	}
 
-	if p.KeyFile != "" {
-		u, _ := url.Parse(p.KeyFile)
-		line := tool.IfString(p.KeyIV == "", `#EXT-X-KEY:METHOD=AES-128,URI="`+u.String()+`"`,
-			`#EXT-X-KEY:METHOD=AES-128,URI="`+u.String()+`",IV=0x`+strings.ReplaceAll(p.KeyIV, "0x", ""))
-		p.m3u8CurrentKey = p.ParseKey(line)
+	// 主列表与媒体列表的头部信息按播放列表模型解析，分片仍逐行处理以便处理KEY与广告标记
+	playlist, err := DecodePlaylist(m3u8Content)
+	if err != nil {
+		log.WriteErrorTo(p.LogFile, lang.Lang.InvalidM3u8Error)
+		return &ParseError{Url: p.M3u8Url, Err: ErrInvalidM3u8}
+	}
+	if master, ok := playlist.(*MasterPlaylist); ok {
+		p.readMasterPlaylist(master)
+	} else if media, ok := playlist.(*MediaPlaylist); ok {
+		targetDuration = media.TargetDuration
+		segIndex, startIndex, msn = media.MediaSequence, media.MediaSequence, media.MediaSequence
+		if media.PartInf != nil {
+			partTarget = media.PartInf.PartTarget
+		}
 	}
 
 	scanner := bufio.NewScanner(strings.NewReader(m3u8Content))
 	var (
-		segDuration float64 = 0
-		segUrl      string  = ""
-		expectByte  int64   = -1 //parm n
-		startByte   int64   = 0  //parm o
+		segDuration float64   = 0
+		segUrl      string    = ""
+		rangeOffset int64     = -1 //BYTERANGE中的o，-1表示未指定
+		rangeUri    string    = "" //上一个按字节范围下载的分片地址
+		rangeEnd    int64     = 0  //上一个分片字节范围的结束位置
+		segDate     time.Time      //下一个分片的开始时间，零值表示未知
+		dateTagged  bool           //下一个分片带有#EXT-X-PROGRAM-DATE-TIME
+		keyLine     bool      = false
 	)
 	for scanner.Scan() {
 		line := scanner.Text()
 		if line == "" {
 			continue
 		}
-		if strings.HasPrefix(line, tags.EXT_M3U) {
-			isM3u = true
-		} else if strings.HasPrefix(line, tags.EXT_X_BYTERANGE) { //只下载部分字节
-			t := strings.Split(strings.ReplaceAll(line, tags.EXT_X_BYTERANGE+":", ""), "@")
-			if len(t) > 0 {
-				if len(t) == 1 {
-					expectByte, _ = strconv.ParseInt(t[0], 10, 64)
-					segInfo.ExpectByte = expectByte
-				}
-				if len(t) == 2 {
-					expectByte, _ = strconv.ParseInt(t[0], 10, 64)
-					startByte, _ = strconv.ParseInt(t[1], 10, 64)
-					segInfo.ExpectByte = expectByte
-					segInfo.StartByte = startByte
-				}
-			}
-			expectSegment = true
-		} else if strings.HasPrefix(line, "#UPLYNK-SEGMENT") { //国家地理去广告
+		if strings.HasPrefix(line, "#UPLYNK-SEGMENT") { //国家地理去广告
 			if strings.Contains(line, ",ad") {
 				isAd = true
 			} else if strings.Contains(line, ",segment") {
 				isAd = false
 			}
-		} else if isAd { //国家地理去广告
+		} else if isAd { //国家地理去广告，广告分片的标签(包括BYTERANGE)一并跳过
+			if !strings.HasPrefix(line, "#") {
+				msn++
+			}
 			continue
-		} else if strings.HasPrefix(line, tags.EXT_X_TARGETDURATION) { //解析定义的分段长度
-			targetDuration, _ = strconv.ParseInt(strings.TrimSpace(strings.ReplaceAll(line, tags.EXT_X_TARGETDURATION+":", "")), 10, 64)
-		} else if strings.HasPrefix(line, tags.EXT_X_MEDIA_SEQUENCE) { // 解析起始编号
-			segI, _ := strconv.ParseInt(strings.ReplaceAll(line, tags.EXT_X_MEDIA_SEQUENCE+":", ""), 10, 64)
-			segIndex = segI
-			startIndex = segIndex
+		} else if strings.HasPrefix(line, tags.EXT_X_BYTERANGE) { //只下载部分字节
+			t := strings.Split(strings.TrimSpace(strings.ReplaceAll(line, tags.EXT_X_BYTERANGE+":", "")), "@")
+			segInfo.ExpectByte, _ = strconv.ParseInt(t[0], 10, 64)
+			rangeOffset = -1
+			if len(t) == 2 {
+				rangeOffset, _ = strconv.ParseInt(t[1], 10, 64)
+			}
+			expectSegment = true
+		} else if strings.HasPrefix(line, tags.EXT_X_TARGETDURATION) {
+		} else if strings.HasPrefix(line, tags.EXT_X_MEDIA_SEQUENCE) {
 		} else if strings.HasPrefix(line, tags.EXT_X_DISCONTINUITY_SEQUENCE) {
 		} else if strings.HasPrefix(line, tags.EXT_X_PROGRAM_DATE_TIME) {
-			if ffmpeg.REC_TIME != "" {
-				ffmpeg.REC_TIME = strings.TrimSpace(strings.ReplaceAll(line, tags.EXT_X_PROGRAM_DATE_TIME+":", ""))
+			value := strings.TrimSpace(strings.ReplaceAll(line, tags.EXT_X_PROGRAM_DATE_TIME+":", ""))
+			if recTime == "" { //以第一个分片的时间作为录制时间
+				recTime = value
+			}
+			if date, err := parseDateTime(value); err == nil {
+				segDate = date
+				dateTagged = true
 			}
 		} else if strings.HasPrefix(line, tags.EXT_X_DISCONTINUITY) { //解析不连续标记，需要单独合并（timestamp不同）
+			if !dateTagged { //不连续处的时间无法推算
+				segDate = time.Time{}
+			}
 			if hasAd && len(parts) > 0 { //修复优酷去除广告后的遗留问题
 				// segments = parts[len(parts)-1]
 				parts = append(parts[:len(parts)-1], parts[len(parts):]...)
 				hasAd = false
 				continue
 			}
-			if !hasAd && len(segments) > 1 { //常规情况的#EXT-X-DISCONTINUITY标记，新建part
+			if !hasAd && (len(segments) > 1 || (classify && len(segments) > 0)) { //常规情况的#EXT-X-DISCONTINUITY标记，新建part；分类时单个分片也作为独立的分部
 				parts = append(parts, segments)
 				segments = []segInfoObj{}
 			}
-		} else if strings.HasPrefix(line, tags.EXT_X_CUE_OUT) {
-		} else if strings.HasPrefix(line, tags.EXT_X_CUE_OUT_START) {
+		} else if strings.HasPrefix(line, tags.EXT_X_CUE_OUT) { //广告持续
+			value := strings.TrimPrefix(strings.TrimPrefix(line, tags.EXT_X_CUE_OUT), ":")
+			ads.cueCont("cue", cueDuration(value), cueScte35(value))
+		} else if strings.HasPrefix(line, tags.EXT_X_CUE_OUT_START) { //广告开始
+			value := strings.TrimPrefix(strings.TrimPrefix(line, tags.EXT_X_CUE_OUT_START), ":")
+			ads.cueOut("cue", "", cueDuration(value), cueScte35(value))
+		} else if strings.HasPrefix(line, tags.EXT_X_CUE_END) { //广告结束
+			ads.cueIn("")
 		} else if strings.HasPrefix(line, tags.EXT_X_CUE_SPAN) {
+			ads.cueCont("cue", 0, "")
+		} else if strings.HasPrefix(line, tags.EXT_X_SCTE35) { //SCTE-35信息，与随后的广告开始标记关联
+			ads.scte35 = strings.TrimSpace(strings.TrimPrefix(line, tags.EXT_X_SCTE35+":"))
+		} else if strings.HasPrefix(line, tags.EXT_X_DATERANGE) {
+			ads.dateRange(decodeDateRange(strings.TrimPrefix(line, tags.EXT_X_DATERANGE+":")))
 		} else if strings.HasPrefix(line, tags.EXT_X_VERSION) {
 		} else if strings.HasPrefix(line, tags.EXT_X_ALLOW_CACHE) {
+		} else if strings.HasPrefix(line, tags.EXT_X_PART_INF) {
+		} else if strings.HasPrefix(line, tags.EXT_X_PART) { //部分分片由直播录制拼接，此处只使用完整分片
+		} else if strings.HasPrefix(line, tags.EXT_X_PRELOAD_HINT) {
+		} else if strings.HasPrefix(line, tags.EXT_X_SERVER_CONTROL) {
+		} else if strings.HasPrefix(line, tags.EXT_X_RENDITION_REPORT) {
 		} else if strings.HasPrefix(line, tags.EXT_X_KEY) { //解析KEY
-			if p.KeyFile != "" || p.KeyBase64 != "" {
-				if p.m3u8CurrentKey[2] == "" && strings.Contains(line, "IV=0x") {
-					temp := tool.GetTagAttribute(strings.ReplaceAll(line, tags.EXT_X_KEY+":", ""), "IV")
-					p.m3u8CurrentKey[2] = temp
+			if p.userKey != nil {
+				lineKey := decodeKey(strings.TrimPrefix(line, tags.EXT_X_KEY+":"))
+				key := *p.userKey
+				if lineKey.Method == "NONE" {
+					key = Key{Method: "NONE"}
+				} else if isSupportedMethod(lineKey.Method) {
+					key.Method = lineKey.Method
+				}
+				if key.Method != "NONE" && key.IV == "" {
+					key.IV = lineKey.IV
 				}
+				p.m3u8CurrentKey = &key
+			} else if keyLine && p.keyResolved(p.m3u8CurrentKey) && !isIdentityKey(line) {
+				// 同一位置存在多种KEYFORMAT时，优先使用identity格式
+				continue
 			} else {
-				p.m3u8CurrentKey = p.ParseKey(line)
+				key, err := p.ParseKey(line)
+				if err != nil {
+					return err
+				}
+				p.m3u8CurrentKey = key
 				p.lastKeyLine = line
 			}
+			keyLine = true
 		} else if strings.HasPrefix(line, tags.EXTINF) { // 解析分片时长(暂时不考虑标题属性)
 			tmp := strings.Split(strings.ReplaceAll(line, tags.EXTINF+":", ""), ",")
 			segDuration, _ = strconv.ParseFloat(tmp[0], 64)
 			segInfo.Index = segIndex
-			segInfo.Method = p.m3u8CurrentKey[0]
+			segInfo.Method = p.m3u8CurrentKey.Method
+			keyLine = false
 
-			if p.m3u8CurrentKey[0] != "NONE" { //是否有加密，有的话写入KEY和IV
-				segInfo.Key = p.m3u8CurrentKey[1]
-				if p.m3u8CurrentKey[2] == "" {
-					// 暂定
-					segInfo.Iv = "0x" + strconv.FormatInt(segIndex, 16)
+			if isSupportedMethod(p.m3u8CurrentKey.Method) { //是否有加密，有的话写入KEY和IV
+				if value, ok := p.keyCache[p.m3u8CurrentKey.URI]; ok {
+					segInfo.Key = base64.StdEncoding.EncodeToString(value)
+				}
+				if p.m3u8CurrentKey.IV == "" {
+					segInfo.Iv = sequenceIV(msn)
 				} else {
-					segInfo.Iv = p.m3u8CurrentKey[2]
+					segInfo.Iv = p.m3u8CurrentKey.IV
 				}
 			}
 			totalDuration += segDuration
 			segInfo.Duration = segDuration
+			adSegment = ads.segment(segIndex, segDuration)
 			expectSegment = true
 			segIndex++
-		} else if strings.HasPrefix(line, tags.EXT_X_STREAM_INF) { //解析STREAM属性
-			expectPlaylist = true
-			bandwidth := tool.GetTagAttribute(line, "BANDWIDTH")
-			average_bandwidth := tool.GetTagAttribute(line, "AVERAGE-BANDWIDTH")
-			codecs := tool.GetTagAttribute(line, "CODECS")
-			resolution := tool.GetTagAttribute(line, "RESOLUTION")
-			frame_rate := tool.GetTagAttribute(line, "FRAME-RATE")
-			hdcp_level := tool.GetTagAttribute(line, "HDCP-LEVEL")
-			_audio := tool.GetTagAttribute(line, "AUDIO")
-			video := tool.GetTagAttribute(line, "VIDEO")
-			subtitles := tool.GetTagAttribute(line, "SUBTITLES")
-			closed_captions := tool.GetTagAttribute(line, "CLOSED-CAPTIONS")
-			extList = []string{bandwidth, average_bandwidth, codecs, resolution,
-				frame_rate, hdcp_level, _audio, video, subtitles, closed_captions}
+		} else if strings.HasPrefix(line, tags.EXT_X_STREAM_INF) { //主列表由readMasterPlaylist解析
 		} else if strings.HasPrefix(line, tags.EXT_X_I_FRAME_STREAM_INF) {
 		} else if strings.HasPrefix(line, tags.EXT_X_MEDIA) {
-			groupId := tool.GetTagAttribute(line, "GROUP-ID")
-			if tool.GetTagAttribute(line, "TYPE") == "AUDIO" {
-				channels := tool.GetTagAttribute(line, "CHANNELS")
-				language := tool.GetTagAttribute(line, "LANGUAGE")
-				name := tool.GetTagAttribute(line, "NAME")
-				uri := p.CombineURL(p.BaseUrl, tool.GetTagAttribute(line, "URI"))
-				_audio := newAudio(name, language, uri, channels)
-				if p.media_audio_group[groupId] == nil {
-					p.media_audio_group[groupId] = []audio{*_audio}
-				} else {
-					p.media_audio_group[groupId] = append(p.media_audio_group[groupId], *_audio)
-				}
-			} else if tool.GetTagAttribute(line, "TYPE") == "SUBTITLES" {
-				language := tool.GetTagAttribute(line, "LANGUAGE")
-				name := tool.GetTagAttribute(line, "NAME")
-				uri := p.CombineURL(p.BaseUrl, tool.GetTagAttribute(line, "URI"))
-				sub := newSubtitle(name, language, uri)
-				if p.media_sub_group[groupId] == nil {
-					p.media_sub_group[groupId] = []subtitle{*sub}
-				} else {
-					p.media_sub_group[groupId] = append(p.media_sub_group[groupId], *sub)
-				}
-			}
 		} else if strings.HasPrefix(line, tags.EXT_X_PLAYLIST_TYPE) {
 		} else if strings.HasPrefix(line, tags.EXT_I_FRAMES_ONLY) {
 		} else if strings.HasPrefix(line, tags.EXT_IS_INDEPENDENT_SEGMENTS) {
@@ -446,23 +588,27 @@ func (p *m3u8Parser) M3u8Parse() {
 			}
 			segments = []segInfoObj{}
 			isEndlist = true
-		} else if strings.HasPrefix(line, tags.EXT_X_MAP) { //#EXT-X-MAP
-			if extMAP[0] == "" {
-				extMAP[0] = tool.GetTagAttribute(line, "URI")
-				if strings.Contains(line, "BYTERANGE") {
-					extMAP[1] = tool.GetTagAttribute(line, "BYTERANGE")
-				}
-				if !strings.HasPrefix(extMAP[0], "http") {
-					extMAP[0] = p.CombineURL(p.BaseUrl, extMAP[0])
-				}
-			} else {
-				if len(segments) > 0 {
-					parts = append(parts, segments)
+		} else if strings.HasPrefix(line, tags.EXT_X_MAP) { //#EXT-X-MAP，每个初始化分段只记录一次
+			value := p.CombineURL(p.BaseUrl, tool.GetTagAttribute(line, "URI"))
+			if byteRange := tool.GetTagAttribute(line, "BYTERANGE"); byteRange != "" {
+				value += "|" + byteRange
+			}
+			index := 0
+			for i, extMAP := range extMAPs {
+				if extMAP == value {
+					index = i + 1
 				}
+			}
+			if index == 0 {
+				extMAPs = append(extMAPs, value)
+				index = len(extMAPs)
+			}
+			// 初始化分段改变时需要单独合并
+			if index != mapIndex && len(segments) > 0 {
+				parts = append(parts, segments)
 				segments = []segInfoObj{}
-				isEndlist = true
-				break
 			}
+			mapIndex = index
 		} else if strings.HasPrefix(line, tags.EXT_X_START) {
 		} else if strings.HasPrefix(line, "#") { //评论行不解析
 			continue
@@ -485,98 +631,83 @@ func (p *m3u8Parser) M3u8Parse() {
 				}
 			}
 			segInfo.SegUri = segUrl
-			segments = append(segments, segInfo)
+			segInfo.MapIndex = mapIndex
+			// 未指定o时紧接上一个分片的字节范围
+			if segInfo.ExpectByte > 0 {
+				if rangeOffset >= 0 {
+					segInfo.StartByte = rangeOffset
+				} else if rangeUri == segUrl {
+					segInfo.StartByte = rangeEnd
+				}
+				rangeUri, rangeEnd = segUrl, segInfo.StartByte+segInfo.ExpectByte
+			} else {
+				rangeUri, rangeEnd = "", 0
+			}
+			rangeOffset = -1
+			if !segDate.IsZero() {
+				segInfo.ProgramDateTime = segDate.Format(dateTimeLayout)
+				segDate = segDate.Add(time.Duration(segInfo.Duration * float64(time.Second)))
+			}
+			dateTagged = false
+			if adSegment && p.AdPolicy == AdPolicyMark {
+				segInfo.Ad = true
+			}
+			if adSegment && p.AdPolicy == AdPolicyStrip { //按广告标记删除分片
+				adCount++
+				adDuration += segInfo.Duration
+			} else {
+				segments = append(segments, segInfo)
+			}
 			segInfo = segInfoObj{}
+			adSegment = false
+			msn++
 
 			//优酷的广告分段则清除此分片
 			//需要注意，遇到广告说明程序对上文的#EXT-X-DISCONTINUITY做出的动作是不必要的，
 			//其实上下文是同一种编码，需要恢复到原先的part上
-			if DelAd && strings.Contains(segUrl, "ccode=") && strings.Contains(segUrl, "/ad/") && strings.Contains(segUrl, "duration=") {
+			if p.DelAd && strings.Contains(segUrl, "ccode=") && strings.Contains(segUrl, "/ad/") && strings.Contains(segUrl, "duration=") {
 				segments = append(segments[:len(segments)-1], segments[len(segments):]...)
 				segIndex--
 				hasAd = true
-			}
-			// 优酷广告(4K分辨率测试)
-			if DelAd && strings.Contains(segUrl, "ccode=0902") && strings.Contains(segUrl, "duration=") {
+			} else if p.DelAd && strings.Contains(segUrl, "ccode=0902") && strings.Contains(segUrl, "duration=") { // 优酷广告(4K分辨率测试)
 				segments = append(segments[:len(segments)-1], segments[len(segments):]...)
 				segIndex--
 				hasAd = true
 			}
 			expectSegment = false
-		} else if expectPlaylist {
-			listUrl := p.CombineURL(p.BaseUrl, line)
-			if strings.Contains(p.M3u8Url, "?__gda__") {
-				reg := regexp.MustCompile(`\\?__gda__.*`)
-				s := reg.FindAllString(p.M3u8Url, -1)
-				if len(s) > 0 {
-					listUrl += s[0]
-				}
-			}
-			sb := []string{`{"URL":"` + listUrl + `",`}
-			for i := 0; i < 10; i++ {
-				if extList[i] != "" {
-					switch i {
-					case 0:
-						sb = append(sb, `"BANDWIDTH":"`+extList[i]+`",`)
-					case 1:
-						sb = append(sb, `"AVERAGE-BANDWIDTH":"`+extList[i]+`",`)
-					case 2:
-						sb = append(sb, `"CODECS":"`+extList[i]+`",`)
-					case 3:
-						sb = append(sb, `"RESOLUTION":"`+extList[i]+`",`)
-					case 4:
-						sb = append(sb, `"FRAME-RATE":"`+extList[i]+`",`)
-					case 5:
-						sb = append(sb, `"HDCP-LEVEL":"`+extList[i]+`",`)
-					case 6:
-						sb = append(sb, `"AUDIO":"`+extList[i]+`",`)
-					case 7:
-						sb = append(sb, `"VIDEO":"`+extList[i]+`",`)
-					case 8:
-						sb = append(sb, `"SUBTITLES":"`+extList[i]+`",`)
-					case 9:
-						sb = append(sb, `"CLOSED-CAPTIONS":"`+extList[i]+`",`)
-					}
-				}
-			}
-			sb = append(sb, `}`)
-			p.extLists = append(p.extLists, strings.ReplaceAll(strings.Join(sb, ""), `,}`, `}`))
-			extL, _ := strconv.ParseInt(extList[0], 10, 64)
-			if extL >= p.bestBandwidth {
-				p.bestBandwidth, _ = strconv.ParseInt(extList[0], 10, 64)
-				p.bestUrl = listUrl
-				p.bestUrlAudio = extList[6]
-				p.bestUrlSub = extList[8]
-			}
-			extList = []string{}
-			expectPlaylist = false
 		}
 	}
 
-	if !isM3u {
-		log.WriteError(lang.Lang.InvalidM3u8Error)
-		log.Error(lang.Lang.InvalidM3u8Error)
-		return
+	if err := scanner.Err(); err != nil {
+		return &ParseError{Url: p.M3u8Url, Err: err}
 	}
 
-	if parts == nil {
+	if len(segments) > 0 { //直播列表没有 #EXT-X-ENDLIST
 		parts = append(parts, segments)
 	}
 
-	if p.audioUrl != "" && global.VIDEO_TYPE == "IGNORE" {
-		log.WriteInfo(lang.Lang.StartParsing + p.audioUrl)
-		log.WriteInfo(lang.Lang.DownloadingExternalAudioTrack)
+	if len(p.variants) > 0 {
+		if err := p.selectVariant(); err != nil {
+			return err
+		}
+	}
+
+	if p.audioUrl != "" && p.AudioOnly {
+		log.WriteInfoTo(p.LogFile, lang.Lang.StartParsing+p.audioUrl)
+		log.WriteInfoTo(p.LogFile, lang.Lang.DownloadingExternalAudioTrack)
 		log.Warn(lang.Lang.DownloadingExternalAudioTrack)
 		dir, _ := ioutil.ReadDir(p.DownDir)
 		for _, d := range dir {
+			if d.Name() == localPlaylistDir { //音轨列表位于此目录中
+				continue
+			}
 			os.RemoveAll(path.Join([]string{p.DownDir, d.Name()}...))
 		}
 		p.M3u8Url = p.audioUrl
 		p.BaseUrl = ""
 		p.audioUrl = ""
 		p.bestUrlAudio = ""
-		p.M3u8Parse()
-		return
+		return p.M3u8Parse()
 	}
 	jsonResult := jsonResultObj{}
 	jsonResult.M3u8 = p.M3u8Url
@@ -585,100 +716,86 @@ func (p *m3u8Parser) M3u8Parse() {
 
 	jsonM3u8Info := jsonM3u8InfoObj{}
 	jsonM3u8Info.OriginalCount = segIndex - startIndex
-	jsonM3u8Info.Count = segIndex - startIndex
+	jsonM3u8Info.Count = segIndex - startIndex - adCount
 	jsonM3u8Info.Vod = isEndlist
 	jsonM3u8Info.TargetDuration = targetDuration
-	jsonM3u8Info.TotalDuration = totalDuration
+	jsonM3u8Info.RecTime = recTime
+	jsonM3u8Info.TotalDuration = totalDuration - adDuration
+	jsonM3u8Info.AdBreaks = ads.result(p.AdPolicy == AdPolicyStrip)
+	if len(jsonM3u8Info.AdBreaks) > 0 {
+		log.Info(fmt.Sprintf(lang.Lang.AdBreaksFound, len(jsonM3u8Info.AdBreaks), p.AdPolicy))
+		log.WriteInfoTo(p.LogFile, fmt.Sprintf(lang.Lang.AdBreaksFound, len(jsonM3u8Info.AdBreaks), p.AdPolicy))
+	}
+
+	if len(parts) > 1 && classify {
+		parts, jsonM3u8Info.RemovedParts = p.classifyParts(parts, extMAPs)
+		for _, removed := range jsonM3u8Info.RemovedParts {
+			jsonM3u8Info.Count -= int64(removed.Count)
+			jsonM3u8Info.TotalDuration -= removed.Duration
+		}
+	}
+	jsonM3u8Info.PartTarget = partTarget
+	p.lowLatency = partTarget > 0 && !isEndlist
+	p.live = jsonM3u8Info.OriginalCount > 0 && !isEndlist
+	if p.live && p.manifest != "" {
+		return &ParseError{Url: p.M3u8Url, Err: ErrLiveManifest}
+	}
 
 	if p.bestUrlAudio != "" && p.media_audio_group[p.bestUrlAudio] != nil {
-		if len(p.media_audio_group[p.bestUrlAudio]) == 1 {
-			p.audioUrl = p.media_audio_group[p.bestUrlAudio][0].Uri
-		} else { //多种音频语言 让用户选择
-			// 暂定
+		audios, err := p.selectRenditions("AUDIO", p.bestUrlAudio, p.AudioSelector)
+		if err != nil {
+			return err
 		}
+		p.audios = audios
+		p.audioUrl = firstRenditionUri(audios)
 	}
 
 	if p.bestUrlSub != "" && p.media_sub_group[p.bestUrlSub] != nil {
-		if len(p.media_sub_group[p.bestUrlSub]) == 1 {
-			p.subUrl = p.media_sub_group[p.bestUrlSub][0].Uri
-		} else { //多种字幕语言 让用户选择
-			// 暂定
+		subtitles, err := p.selectRenditions("SUBTITLES", p.bestUrlSub, p.SubtitleSelector)
+		if err != nil {
+			return err
 		}
+		p.subtitles = subtitles
+		p.subUrl = firstRenditionUri(subtitles)
 	}
 
-	if p.audioUrl != "" {
-		jsonM3u8Info.Audio = p.audioUrl
+	for _, rendition := range p.audios {
+		jsonM3u8Info.Audios = append(jsonM3u8Info.Audios, newMediaObj(rendition))
 	}
-	if p.subUrl != "" {
-		jsonM3u8Info.Sub = p.subUrl
+	for _, rendition := range p.subtitles {
+		jsonM3u8Info.Subtitles = append(jsonM3u8Info.Subtitles, newMediaObj(rendition))
 	}
-	if extMAP[0] != "" {
-		downloadManager.HasExtMap = true
-		if extMAP[1] != "" {
-			jsonM3u8Info.ExtMAP = extMAP[0]
-		} else {
-			jsonM3u8Info.ExtMAP = extMAP[0] + "|" + extMAP[1]
+	if jsonM3u8Info.OriginalCount > 0 && !p.live {
+		if err := p.parseTracks(jsonM3u8Info.Audios, jsonM3u8Info.Subtitles); err != nil {
+			return err
 		}
-	} else {
-		downloadManager.HasExtMap = false
+	}
+	if len(extMAPs) > 0 {
+		jsonM3u8Info.ExtMAP = extMAPs[0]
+		jsonM3u8Info.ExtMAPs = extMAPs
 	}
 
-	if DurStart != "" || DurEnd != "" { //根据DurRange来生成分片Range
-		var (
-			secStart float64 = 0
-			secEnd   float64 = -1
-		)
-		if DurEnd == "" {
-			secEnd = totalDuration
-		}
-		reg := regexp.MustCompile(`(\d+):(\d+):(\d+)`)
-		if reg.MatchString(DurStart) {
-			s := reg.FindAllString(DurStart, -1)
-			if len(s) >= 3 {
-				hh, _ := strconv.ParseInt(s[0], 10, 32)
-				mm, _ := strconv.ParseInt(s[1], 10, 32)
-				ss, _ := strconv.ParseInt(s[2], 10, 32)
-				secStart = float64(ss + mm*60 + hh*3600)
-			} else {
-				secStart = 0
-			}
+	// 只对媒体列表选择分片
+	if jsonM3u8Info.OriginalCount > 0 && (!p.DateStart.IsZero() || !p.DateEnd.IsZero()) { //根据绝对时间选择分片
+		newParts, clip, err := filterByDate(parts, p.DateStart, p.DateEnd)
+		if err != nil {
+			return &ParseError{Url: p.M3u8Url, Err: err}
 		}
-		if reg.MatchString(DurEnd) {
-			s := reg.FindAllString(DurEnd, -1)
-			if len(s) >= 3 {
-				hh, _ := strconv.ParseInt(s[0], 10, 32)
-				mm, _ := strconv.ParseInt(s[1], 10, 32)
-				ss, _ := strconv.ParseInt(s[2], 10, 32)
-				secEnd = float64(ss + mm*60 + hh*3600)
-			} else {
-				secEnd = 0
-			}
-		}
-		flag1 := false
-		flag2 := false
-		if secEnd-secStart > 0 {
-			var dur float64 = 0
-			for _, part := range parts {
-				for _, seg := range part {
-					dur += seg.Duration
-					if !flag1 && dur > secStart {
-						RangeStart = seg.Index
-						flag1 = true
-					}
-
-					if !flag2 && dur >= secEnd {
-						RangeEnd = seg.Index
-						flag2 = true
-					}
-				}
-			}
+		if len(newParts) == 0 {
+			return &ParseError{Url: p.M3u8Url, Err: ErrEmptyDateRange}
 		}
+		parts = newParts
+		jsonM3u8Info.Clips = []clipObj{clip}
+		jsonM3u8Info.Count, jsonM3u8Info.TotalDuration = countSegments(parts)
 	}
 
-	if RangeStart != 0 || RangeEnd != -1 { //根据Range来清除部分分片
-		if RangeEnd == -1 {
-			RangeEnd = segIndex - startIndex - 1
-		}
+	if jsonM3u8Info.OriginalCount > 0 && len(p.TimeRanges) > 0 { //根据时长选择分片，多个范围依次拼接
+		parts, jsonM3u8Info.Clips = selectTimeRanges(parts, p.TimeRanges)
+		jsonM3u8Info.Count, jsonM3u8Info.TotalDuration = countSegments(parts)
+	}
+
+	rangeStart, rangeEnd := p.RangeStart, p.RangeEnd
+	if rangeStart != 0 || rangeEnd != -1 { //根据Range来清除部分分片
 		var (
 			newCount         int64          = 0
 			newTotalDuration float64        = 0
@@ -687,7 +804,8 @@ func (p *m3u8Parser) M3u8Parse() {
 		for _, part := range parts {
 			newPart := []segInfoObj{}
 			for _, seg := range part {
-				if RangeStart <= seg.Index && seg.Index <= RangeEnd {
+				index := seg.Index - startIndex
+				if rangeStart <= index && (rangeEnd == -1 || index <= rangeEnd) {
 					newPart = append(newPart, seg)
 					newCount++
 					newTotalDuration += seg.Duration
@@ -704,26 +822,214 @@ func (p *m3u8Parser) M3u8Parse() {
 	}
 
 	jsonM3u8Info.Segments = parts
+	jsonM3u8Info.BinaryMerge = hasUnsupportedKey(parts)
 	jsonResult.M3u8Info = jsonM3u8Info
 
 	if !p.LiveStream {
-		log.WriteInfo(lang.Lang.WrtingMeta)
+		log.WriteInfoTo(p.LogFile, lang.Lang.WrtingMeta)
 		log.Info(lang.Lang.WrtingMeta)
 	}
 	jsonResultBytes, err := json.Marshal(jsonResult)
 	if err != nil {
-		log.WriteError(err.Error())
-		log.Error(err.Error())
-		return
+		log.WriteErrorTo(p.LogFile, err.Error())
+		return err
+	}
+	if err := tool.WriteFile(p.jsonSavePath, tool.BytesToStr(jsonResultBytes)); err != nil {
+		return err
+	}
+	return p.MasterListCheck()
+}
+
+// 主列表中的清晰度条目，写入playLists.json
+type playListObj struct {
+	URL              string `json:"URL"`
+	Bandwidth        string `json:"BANDWIDTH,omitempty"`
+	AverageBandwidth string `json:"AVERAGE-BANDWIDTH,omitempty"`
+	Codecs           string `json:"CODECS,omitempty"`
+	Resolution       string `json:"RESOLUTION,omitempty"`
+	FrameRate        string `json:"FRAME-RATE,omitempty"`
+	HDCPLevel        string `json:"HDCP-LEVEL,omitempty"`
+	Audio            string `json:"AUDIO,omitempty"`
+	Video            string `json:"VIDEO,omitempty"`
+	Subtitles        string `json:"SUBTITLES,omitempty"`
+	ClosedCaptions   string `json:"CLOSED-CAPTIONS,omitempty"`
+}
+
+// 读取主列表中的清晰度、音轨与字幕，地址转换为绝对地址
+func (p *m3u8Parser) readMasterPlaylist(master *MasterPlaylist) {
+	for _, rendition := range master.Renditions {
+		if rendition.URI != "" {
+			rendition.URI = p.CombineURL(p.BaseUrl, rendition.URI)
+		}
+		p.renditions = append(p.renditions, rendition)
+		switch rendition.Type {
+		case "AUDIO":
+			_audio := newAudio(rendition.Name, rendition.Language, rendition.URI, rendition.Channels)
+			p.media_audio_group[rendition.GroupID] = append(p.media_audio_group[rendition.GroupID], *_audio)
+		case "SUBTITLES":
+			sub := newSubtitle(rendition.Name, rendition.Language, rendition.URI)
+			p.media_sub_group[rendition.GroupID] = append(p.media_sub_group[rendition.GroupID], *sub)
+		}
+	}
+	for _, variant := range master.Variants {
+		variant.URI = p.CombineURL(p.BaseUrl, variant.URI)
+		if strings.Contains(p.M3u8Url, "?__gda__") {
+			reg := regexp.MustCompile(`\\?__gda__.*`)
+			s := reg.FindAllString(p.M3u8Url, -1)
+			if len(s) > 0 {
+				variant.URI += s[0]
+			}
+		}
+		p.variants = append(p.variants, variant)
+		obj := playListObj{
+			URL:            variant.URI,
+			Codecs:         variant.Codecs,
+			Resolution:     variant.Resolution,
+			HDCPLevel:      variant.HDCPLevel,
+			Audio:          variant.Audio,
+			Video:          variant.Video,
+			Subtitles:      variant.Subtitles,
+			ClosedCaptions: variant.ClosedCaptions,
+		}
+		if variant.Bandwidth > 0 {
+			obj.Bandwidth = strconv.FormatInt(variant.Bandwidth, 10)
+		}
+		if variant.AverageBandwidth > 0 {
+			obj.AverageBandwidth = strconv.FormatInt(variant.AverageBandwidth, 10)
+		}
+		if variant.FrameRate > 0 {
+			obj.FrameRate = formatFloat(variant.FrameRate)
+		}
+		objBytes, _ := json.Marshal(obj)
+		p.extLists = append(p.extLists, tool.BytesToStr(objBytes))
+	}
+}
+
+// 媒体列表为尚未结束的LL-HLS直播
+func (p *m3u8Parser) IsLowLatency() bool {
+	return p.lowLatency
+}
+
+// 媒体列表为尚未结束的直播
+func (p *m3u8Parser) IsLive() bool {
+	return p.live
+}
+
+// 主列表中定义的变量，录制媒体列表时用于IMPORT
+func (p *m3u8Parser) Variables() map[string]string {
+	return p.variables
+}
+
+// 按 --selectVideo 规则选择清晰度，未指定时选择带宽最高的条目
+func (p *m3u8Parser) selectVariant() error {
+	selector := p.VideoSelector
+	if selector == nil {
+		selector = &VariantSelector{Pick: "best"}
+	}
+	variant, err := selector.Select(p.variants)
+	if err != nil {
+		log.Error(lang.Lang.NoVariantMatch)
+		for i, v := range p.variants {
+			log.Info(fmt.Sprintf("%d. %s", i, describeVariant(v)))
+		}
+		return &ParseError{Url: p.M3u8Url, Err: err}
+	}
+	log.Info(lang.Lang.SelectedVariant + describeVariant(variant))
+	log.WriteInfoTo(p.LogFile, lang.Lang.SelectedVariant+describeVariant(variant))
+	p.bestUrl = variant.URI
+	p.bestUrlAudio = variant.Audio
+	p.bestUrlSub = variant.Subtitles
+	return nil
+}
+
+// 在清晰度对应的EXT-X-MEDIA分组中选择音轨或字幕，未指定规则时选择默认条目
+func (p *m3u8Parser) selectRenditions(mediaType string, groupId string, selector *RenditionSelector) ([]*Rendition, error) {
+	group := []*Rendition{}
+	for _, rendition := range p.renditions {
+		if rendition.Type == mediaType && rendition.GroupID == groupId {
+			group = append(group, rendition)
+		}
+	}
+	if len(group) == 0 {
+		return nil, nil
+	}
+	if selector == nil {
+		return []*Rendition{preferredRendition(group)}, nil
+	}
+	selected, unmatched, err := selector.Select(group)
+	for _, i := range unmatched {
+		log.Warn(fmt.Sprintf(lang.Lang.RenditionRuleUnmatched, mediaType, i+1))
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf(lang.Lang.NoRenditionMatch, mediaType))
+		for i, rendition := range group {
+			log.Info(fmt.Sprintf("%d. %s", i, describeRendition(rendition)))
+		}
+		return nil, &ParseError{Url: p.M3u8Url, Err: err}
+	}
+	for _, rendition := range selected {
+		log.Info(fmt.Sprintf(lang.Lang.SelectedRendition, mediaType) + describeRendition(rendition))
+		log.WriteInfoTo(p.LogFile, fmt.Sprintf(lang.Lang.SelectedRendition, mediaType)+describeRendition(rendition))
+	}
+	return selected, nil
+}
+
+// 选中的音轨与字幕的媒体列表单独解析到各自的目录，下载后作为额外的轨道合并
+func (p *m3u8Parser) parseTracks(audios []jsonMediaObj, subtitles []jsonMediaObj) error {
+	for i := range audios {
+		if err := p.parseTrack(&audios[i], fmt.Sprintf("Audio_%d", i)); err != nil {
+			return err
+		}
+	}
+	for i := range subtitles {
+		if err := p.parseTrack(&subtitles[i], fmt.Sprintf("Subtitle_%d", i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 没有URI的条目已包含在主视频流中，与当前列表相同的条目(仅下载音频时)无需重复下载
+func (p *m3u8Parser) parseTrack(media *jsonMediaObj, dir string) error {
+	if media.Uri == "" || media.Uri == p.M3u8Url {
+		return nil
+	}
+	log.Info(lang.Lang.StartParsing + media.Uri)
+	log.WriteInfoTo(p.LogFile, lang.Lang.StartParsing+media.Uri)
+	track := NewM3u8Parser()
+	track.M3u8Url = media.Uri
+	track.DownDir = path.Join(p.DownDir, dir)
+	track.DownName = dir
+	track.Headers = p.Headers
+	track.KeyFile = p.KeyFile
+	track.KeyBase64 = p.KeyBase64
+	track.KeyIV = p.KeyIV
+	track.variables = p.variables
+	track.LogFile = p.LogFile
+	track.ParseOptions = p.ParseOptions
+	if err := track.M3u8Parse(); err != nil {
+		return err
 	}
-	tool.WriteFile(p.jsonSavePath, tool.BytesToStr(jsonResultBytes))
-	p.MasterListCheck()
+	media.Dir = dir
+	return nil
 }
 
-func (p *m3u8Parser) MasterListCheck() {
+// 第一个带有URI的条目，没有URI的条目已包含在主视频流中
+func firstRenditionUri(renditions []*Rendition) string {
+	for _, rendition := range renditions {
+		if rendition.URI != "" {
+			return rendition.URI
+		}
+	}
+	return ""
+}
+
+func (p *m3u8Parser) MasterListCheck() error {
 	if len(p.extLists) != 0 { //若存在多个清晰度条目，输出另一个json文件存放
-		tool.CopyFile(p.m3u8SavePath, path.Join(path.Dir(p.m3u8SavePath), "master.m3u8"))
-		log.WriteInfo("Master List Found")
+		if err := tool.CopyFile(p.m3u8SavePath, path.Join(path.Dir(p.m3u8SavePath), "master.m3u8")); err != nil {
+			return err
+		}
+		log.WriteInfoTo(p.LogFile, "Master List Found")
 		log.Warn(lang.Lang.MasterListFound)
 		type jsonObj struct {
 			MasterUri      string      `json:"masterUri,omitempty"`
@@ -742,80 +1048,31 @@ func (p *m3u8Parser) MasterListCheck() {
 		if p.media_sub_group != nil {
 			jso.SubtitleTracks = p.media_sub_group
 		}
-		log.WriteInfo(lang.Lang.WrtingMasterMeta)
+		log.WriteInfoTo(p.LogFile, lang.Lang.WrtingMasterMeta)
 		log.Info(lang.Lang.WrtingMasterMeta)
 		jsoBytes, err := json.Marshal(jso)
 		if err != nil {
-			log.WriteError(err.Error())
-			log.Error(err.Error())
-			return
+			log.WriteErrorTo(p.LogFile, err.Error())
+			return err
+		}
+		if err := tool.WriteFile(path.Join(path.Dir(p.jsonSavePath), "playLists.json"), tool.BytesToStr(jsoBytes)); err != nil {
+			return err
 		}
-		tool.WriteFile(path.Join(path.Dir(p.jsonSavePath), "playLists.json"), tool.BytesToStr(jsoBytes))
-		log.WriteInfo(lang.Lang.SelectPlaylist + ": " + p.bestUrl)
+		log.WriteInfoTo(p.LogFile, lang.Lang.SelectPlaylist+": "+p.bestUrl)
 		log.Info(lang.Lang.SelectPlaylist)
-		log.WriteInfo(lang.Lang.StartReParsing)
+		log.WriteInfoTo(p.LogFile, lang.Lang.StartReParsing)
 		log.Warn(lang.Lang.StartReParsing)
 		p.M3u8Url = p.bestUrl
 		p.BaseUrl = ""
-		p.M3u8Parse()
+		return p.M3u8Parse()
 	}
-}
-
-func (p *m3u8Parser) ParseKey(line string) []string {
-	// if !p.downloadingM3u8KeyTip {
-	// 	log.Warn(lang.Lang.DownloadingM3u8Key)
-	// 	p.downloadingM3u8KeyTip = true
-	// }
-	// tmp := strings.Split(strings.ReplaceAll(line, tags.EXT_X_KEY+":", ""), ",")
-	// key := []string{"NONE", "", ""}
-	// u_l := tool.GetTagAttribute(strings.ReplaceAll(p.lastKeyLine, tags.EXT_X_KEY+":", ""), "URI")
-	// m := tool.GetTagAttribute(strings.ReplaceAll(line, tags.EXT_X_KEY+":", ""), "METHOD")
-	// u := tool.GetTagAttribute(strings.ReplaceAll(line, tags.EXT_X_KEY+":", ""), "URI")
-	// i := tool.GetTagAttribute(strings.ReplaceAll(line, tags.EXT_X_KEY+":", ""), "IV")
-
-	// // 存在加密
-	// if m != "" {
-	// 	if m != "AES-128" {
-	// 		log.Error(fmt.Sprintf(lang.Lang.NotSupportMethodError, m))
-	// 		DownloadManager.BinaryMerge = true
-	// 		return []string{fmt.Sprintf("%s(NOTSUPPORTED)", m), "", ""}
-	// 	}
-	// 	key[0] = m
-	// 	key[1] = u
-	// 	if u_l == u {
-	// 		key[1] = p.m3u8CurrentKey[1]
-	// 	} else {
-	// 		log.WriteInfo(lang.Lang.DownloadingM3u8Key + " " + key[1])
-	// 		if strings.HasPrefix(key[1], "http") {
-	// 			keyUrl := key[1]
-	// 			if strings.Contains(key[1], "imooc.com/") {
-	// 				byts, _ := download.GetWebSource(key[1], p.Headers, 60)
-	// 				key[1] = decode.ImoocDecodeKey(tool.BytesToStr(byts))
-	// 			} else if key[1] == "https://hls.ventunotech.com/m3u8/pc_videosecurevtnkey.key" {
-	// 				byts, _ := download.GetWebSource(keyUrl, p.Headers, 60)
-	// 				temp := tool.BytesToStr(byts)
-	// 				log.Info(temp)
-	// 				tempKey := make([]byte, 16)
-	// 				for i := 0; i < 16; i++ {
-	// 					str := strings.NewReader(temp[i*2 : 2])
-	// 					bf := bufio.NewReaderSize(str, 16)
-	// 					byt, _ := bf.ReadByte()
-	// 					tempKey[i] = byt
-	// 				}
-	// 				key[1] = base64.StdEncoding.EncodeToString(tempKey)
-	// 			} else if strings.Contains(key[1], "drm.vod2.myqcloud.com/getlicense") {
-	// 				temp, _ := download.HttpDownloadFileToBytes(keyUrl, p.Headers, 60)
-	// 				key[1] = tool.BytesToStr(temp)
-	// 			}
-	// 		} else {
-
-	// 		}
-	// 	}
-	// }
-	return []string{}
+	return nil
 }
 
 func (p *m3u8Parser) CombineURL(baseurl string, uri string) string {
+	if ref, err := url.Parse(uri); err == nil && ref.IsAbs() {
+		return uri
+	}
 	u, _ := url.Parse(baseurl)
 	uu := u.Scheme + "://" + u.Host
 	if strings.HasPrefix(uri, "/") {
@@ -836,11 +1093,16 @@ func (p *m3u8Parser) CombineURL(baseurl string, uri string) string {
 
 // 获取baseUrl
 func getBaseUrl(m3u8url string, headers string) (string, error) {
+	if !strings.HasPrefix(m3u8url, "http") { //本地文件无需请求
+		return m3u8url[:strings.LastIndex(m3u8url, "/")+1], nil
+	}
 	req, err := request.New(m3u8url, http.MethodGet, 5, false)
 	if err != nil {
 		return "", err
 	}
-	req.SetHeaders(headers)
+	if err := req.SetHeaders(headers); err != nil {
+		return "", err
+	}
 	m3u8url, err = req.Get302()
 	if err != nil {
 		return "", err
@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+)
+
+// 解析本地m3u8并读取生成的meta.json
+func parseMeta(t *testing.T, content string, options ParseOptions) jsonM3u8InfoObj {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, "index.m3u8"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := NewM3u8Parser()
+	p.ParseOptions = options
+	p.M3u8Url = "file://" + path.Join(dir, "index.m3u8")
+	p.BaseUrl = "http://example.com/"
+	p.DownDir = path.Join(dir, "out")
+	if err := p.M3u8Parse(); err != nil {
+		t.Fatal(err)
+	}
+	byts, err := os.ReadFile(path.Join(p.DownDir, "meta.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result jsonResultObj
+	if err := json.Unmarshal(byts, &result); err != nil {
+		t.Fatal(err)
+	}
+	return result.M3u8Info
+}
+
+// 按顺序返回全部分片
+func allSegments(info jsonM3u8InfoObj) []segInfoObj {
+	segs := []segInfoObj{}
+	for _, part := range info.Segments {
+		segs = append(segs, part...)
+	}
+	return segs
+}
+
+func TestByteRangeOffsets(t *testing.T) {
+	type want struct {
+		uri               string
+		start, expectByte int64
+	}
+	for _, c := range []struct {
+		name    string
+		content string
+		want    []want
+	}{
+		{
+			name: "explicit offsets",
+			content: "#EXTM3U\n#EXT-X-TARGETDURATION:10\n" +
+				"#EXTINF:10,\n#EXT-X-BYTERANGE:100@0\na.ts\n#EXTINF:10,\n#EXT-X-BYTERANGE:200@500\na.ts\n#EXT-X-ENDLIST\n",
+			want: []want{{"a.ts", 0, 100}, {"a.ts", 500, 200}},
+		},
+		{
+			name: "chained without offset",
+			content: "#EXTM3U\n#EXT-X-TARGETDURATION:10\n" +
+				"#EXTINF:10,\n#EXT-X-BYTERANGE:100@50\na.ts\n#EXTINF:10,\n#EXT-X-BYTERANGE:200\na.ts\n#EXTINF:10,\n#EXT-X-BYTERANGE:300\na.ts\n#EXT-X-ENDLIST\n",
+			want: []want{{"a.ts", 50, 100}, {"a.ts", 150, 200}, {"a.ts", 350, 300}},
+		},
+		{
+			name: "first range without offset",
+			content: "#EXTM3U\n#EXT-X-TARGETDURATION:10\n" +
+				"#EXTINF:10,\n#EXT-X-BYTERANGE:100\na.ts\n#EXTINF:10,\n#EXT-X-BYTERANGE:100\na.ts\n#EXT-X-ENDLIST\n",
+			want: []want{{"a.ts", 0, 100}, {"a.ts", 100, 100}},
+		},
+		{
+			name: "new resource restarts",
+			content: "#EXTM3U\n#EXT-X-TARGETDURATION:10\n" +
+				"#EXTINF:10,\n#EXT-X-BYTERANGE:100@0\na.ts\n#EXTINF:10,\n#EXT-X-BYTERANGE:100\nb.ts\n#EXTINF:10,\nc.ts\n#EXT-X-ENDLIST\n",
+			want: []want{{"a.ts", 0, 100}, {"b.ts", 0, 100}, {"c.ts", 0, 0}},
+		},
+		{
+			name: "skipped ad range does not leak",
+			content: "#EXTM3U\n#EXT-X-TARGETDURATION:10\n" +
+				"#UPLYNK-SEGMENT:y,00000000,ad\n#EXTINF:10,\n#EXT-X-BYTERANGE:100@900\nad.ts\n" +
+				"#UPLYNK-SEGMENT:x,00000000,segment\n#EXTINF:10,\na.ts\n#EXT-X-ENDLIST\n",
+			want: []want{{"a.ts", 0, 0}},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			segs := allSegments(parseMeta(t, c.content, NewParseOptions()))
+			if len(segs) != len(c.want) {
+				t.Fatalf("got %d segments, want %d", len(segs), len(c.want))
+			}
+			for i, seg := range segs {
+				w := c.want[i]
+				if seg.SegUri != "http://example.com/"+w.uri || seg.StartByte != w.start || seg.ExpectByte != w.expectByte {
+					t.Errorf("segment %d = %s %d@%d, want %s %d@%d", i, seg.SegUri, seg.ExpectByte, seg.StartByte, w.uri, w.expectByte, w.start)
+				}
+			}
+		})
+	}
+}
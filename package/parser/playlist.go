@@ -0,0 +1,724 @@
+package parser
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+
+	"github.com/xfy520/m3u8_cli/package/tags"
+	"github.com/xfy520/m3u8_cli/package/tool"
+)
+
+// 可编码为m3u8文本的播放列表
+type Playlist interface {
+	Encode() string
+}
+
+// 字节范围(#EXT-X-BYTERANGE)，Offset为-1时表示未指定偏移
+type ByteRange struct {
+	Length int64
+	Offset int64
+}
+
+// #EXT-X-START
+type Start struct {
+	TimeOffset float64
+	Precise    bool
+	Other      []tool.Attribute
+}
+
+// #EXT-X-KEY / #EXT-X-SESSION-KEY
+type Key struct {
+	Method            string
+	URI               string
+	IV                string
+	KeyFormat         string
+	KeyFormatVersions string
+	Other             []tool.Attribute
+}
+
+// #EXT-X-MAP
+type Map struct {
+	URI       string
+	ByteRange *ByteRange
+	Other     []tool.Attribute
+}
+
+// #EXT-X-DATERANGE
+type DateRange struct {
+	ID              string
+	Class           string
+	StartDate       string
+	EndDate         string
+	Duration        float64
+	PlannedDuration float64
+	SCTE35Cmd       string
+	SCTE35Out       string
+	SCTE35In        string
+	EndOnNext       bool
+	Other           []tool.Attribute
+}
+
+// #EXT-X-PART，LL-HLS的部分分片
+type Part struct {
+	URI         string
+	Duration    float64
+	Independent bool
+	ByteRange   *ByteRange
+	Gap         bool
+	Other       []tool.Attribute
+}
+
+// #EXT-X-PART-INF
+type PartInf struct {
+	PartTarget float64
+	Other      []tool.Attribute
+}
+
+// #EXT-X-SERVER-CONTROL
+type ServerControl struct {
+	CanSkipUntil      float64
+	CanSkipDateRanges bool
+	HoldBack          float64
+	PartHoldBack      float64
+	CanBlockReload    bool
+	Other             []tool.Attribute
+}
+
+// #EXT-X-PRELOAD-HINT，ByteRangeLength为0时表示到资源末尾
+type PreloadHint struct {
+	Type            string
+	URI             string
+	ByteRangeStart  int64
+	ByteRangeLength int64
+	Other           []tool.Attribute
+}
+
+// #EXT-X-RENDITION-REPORT，LastPart为-1时表示未指定
+type RenditionReport struct {
+	URI      string
+	LastMsn  int64
+	LastPart int64
+	Other    []tool.Attribute
+}
+
+// 媒体分片
+type Segment struct {
+	URI             string
+	Duration        float64
+	Title           string
+	ByteRange       *ByteRange
+	Discontinuity   bool
+	Keys            []*Key
+	Map             *Map
+	ProgramDateTime string
+	DateRanges      []*DateRange
+	Gap             bool
+	Bitrate         int64
+	// 组成该分片的部分分片
+	Parts []*Part
+	// 未识别的标签及注释，按出现顺序保留
+	Tags []string
+}
+
+// 媒体播放列表
+type MediaPlaylist struct {
+	Version               int64
+	TargetDuration        int64
+	MediaSequence         int64
+	DiscontinuitySequence int64
+	PlaylistType          string
+	AllowCache            string
+	EndList               bool
+	IFramesOnly           bool
+	IndependentSegments   bool
+	Start                 *Start
+	PartInf               *PartInf
+	ServerControl         *ServerControl
+	Segments              []*Segment
+	// 最后一个分片之后尚未完成的分片所包含的部分分片
+	Parts            []*Part
+	PreloadHints     []*PreloadHint
+	RenditionReports []*RenditionReport
+	// 位于头部的未识别标签
+	Tags []string
+	// 最后一个分片之后的未识别标签
+	TrailingTags []string
+}
+
+// #EXT-X-STREAM-INF / #EXT-X-I-FRAME-STREAM-INF
+type Variant struct {
+	URI              string
+	Bandwidth        int64
+	AverageBandwidth int64
+	Codecs           string
+	Resolution       string
+	FrameRate        float64
+	HDCPLevel        string
+	VideoRange       string
+	Audio            string
+	Video            string
+	Subtitles        string
+	ClosedCaptions   string
+	IFrame           bool
+	Other            []tool.Attribute
+	// 位于该条目之前的未识别标签
+	Tags []string
+}
+
+// #EXT-X-MEDIA
+type Rendition struct {
+	Type            string
+	URI             string
+	GroupID         string
+	Language        string
+	AssocLanguage   string
+	Name            string
+	Default         bool
+	AutoSelect      bool
+	Forced          bool
+	InstreamID      string
+	Characteristics string
+	Channels        string
+	Other           []tool.Attribute
+	// 位于该条目之前的未识别标签
+	Tags []string
+}
+
+// #EXT-X-SESSION-DATA
+type SessionData struct {
+	DataID   string
+	Value    string
+	URI      string
+	Language string
+	Other    []tool.Attribute
+}
+
+// 主播放列表
+type MasterPlaylist struct {
+	Version             int64
+	IndependentSegments bool
+	Start               *Start
+	Variants            []*Variant
+	IFrameVariants      []*Variant
+	Renditions          []*Rendition
+	SessionData         []*SessionData
+	SessionKeys         []*Key
+	// 位于头部的未识别标签及注释
+	Tags []string
+	// 最后一个条目之后的未识别标签
+	TrailingTags []string
+}
+
+// 解析m3u8文本，根据内容返回 *MasterPlaylist 或 *MediaPlaylist
+func DecodePlaylist(content string) (Playlist, error) {
+	lines, err := playlistLines(content)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		name, _ := splitTag(line)
+		if name == tags.EXT_X_STREAM_INF || name == tags.EXT_X_I_FRAME_STREAM_INF || name == tags.EXT_X_MEDIA {
+			return decodeMasterPlaylist(lines), nil
+		}
+	}
+	return decodeMediaPlaylist(lines), nil
+}
+
+// 解析主播放列表
+func DecodeMasterPlaylist(content string) (*MasterPlaylist, error) {
+	lines, err := playlistLines(content)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMasterPlaylist(lines), nil
+}
+
+// 解析媒体播放列表
+func DecodeMediaPlaylist(content string) (*MediaPlaylist, error) {
+	lines, err := playlistLines(content)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMediaPlaylist(lines), nil
+}
+
+// 拆分有效行并校验#EXTM3U头
+func playlistLines(content string) ([]string, error) {
+	lines := []string{}
+	scanner := bufio.NewScanner(strings.NewReader(strings.TrimPrefix(content, "\ufeff")))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], tags.EXT_M3U) {
+		return nil, &ParseError{Err: ErrInvalidM3u8}
+	}
+	return lines[1:], nil
+}
+
+// 拆分标签名与值
+func splitTag(line string) (string, string) {
+	if !strings.HasPrefix(line, "#") {
+		return "", line
+	}
+	index := strings.Index(line, ":")
+	if index == -1 {
+		return line, ""
+	}
+	return line[:index], line[index+1:]
+}
+
+func decodeMediaPlaylist(lines []string) *MediaPlaylist {
+	playlist := &MediaPlaylist{}
+	var (
+		seg        *Segment = &Segment{}
+		keys       []*Key
+		keysSealed bool
+		currentMap *Map
+		pending    []string
+		hasContent bool
+	)
+	for _, line := range lines {
+		name, value := splitTag(line)
+		if name == "" { // 分片地址
+			seg.URI = line
+			seg.Keys = keys
+			seg.Map = currentMap
+			playlist.Segments = append(playlist.Segments, seg)
+			seg = &Segment{}
+			keysSealed = true
+			pending = nil
+			hasContent = false
+			continue
+		}
+		segmentTag := true
+		switch name {
+		case tags.EXT_X_VERSION:
+			playlist.Version, _ = strconv.ParseInt(value, 10, 64)
+			segmentTag = false
+		case tags.EXT_X_TARGETDURATION:
+			playlist.TargetDuration, _ = strconv.ParseInt(value, 10, 64)
+			segmentTag = false
+		case tags.EXT_X_MEDIA_SEQUENCE:
+			playlist.MediaSequence, _ = strconv.ParseInt(value, 10, 64)
+			segmentTag = false
+		case tags.EXT_X_DISCONTINUITY_SEQUENCE:
+			playlist.DiscontinuitySequence, _ = strconv.ParseInt(value, 10, 64)
+			segmentTag = false
+		case tags.EXT_X_PLAYLIST_TYPE:
+			playlist.PlaylistType = value
+			segmentTag = false
+		case tags.EXT_X_ALLOW_CACHE:
+			playlist.AllowCache = value
+			segmentTag = false
+		case tags.EXT_X_ENDLIST:
+			playlist.EndList = true
+			segmentTag = false
+		case tags.EXT_I_FRAMES_ONLY:
+			playlist.IFramesOnly = true
+			segmentTag = false
+		case tags.EXT_IS_INDEPENDENT_SEGMENTS:
+			playlist.IndependentSegments = true
+			segmentTag = false
+		case tags.EXT_X_START:
+			playlist.Start = decodeStart(value)
+			segmentTag = false
+		case tags.EXT_X_PART_INF:
+			playlist.PartInf = decodePartInf(value)
+			segmentTag = false
+		case tags.EXT_X_SERVER_CONTROL:
+			playlist.ServerControl = decodeServerControl(value)
+			segmentTag = false
+		case tags.EXT_X_PART:
+			seg.Parts = append(seg.Parts, decodePart(value))
+			segmentTag = false
+		case tags.EXT_X_PRELOAD_HINT:
+			playlist.PreloadHints = append(playlist.PreloadHints, decodePreloadHint(value))
+			segmentTag = false
+		case tags.EXT_X_RENDITION_REPORT:
+			playlist.RenditionReports = append(playlist.RenditionReports, decodeRenditionReport(value))
+			segmentTag = false
+		case tags.EXTINF:
+			duration, title := value, ""
+			if index := strings.Index(value, ","); index != -1 {
+				duration, title = value[:index], value[index+1:]
+			}
+			seg.Duration, _ = strconv.ParseFloat(strings.TrimSpace(duration), 64)
+			seg.Title = title
+		case tags.EXT_X_BYTERANGE:
+			seg.ByteRange = decodeByteRange(value)
+		case tags.EXT_X_DISCONTINUITY:
+			seg.Discontinuity = true
+		case tags.EXT_X_KEY:
+			if keysSealed {
+				keys = nil
+				keysSealed = false
+			}
+			keys = append(keys, decodeKey(value))
+		case tags.EXT_X_MAP:
+			currentMap = decodeMap(value)
+		case tags.EXT_X_PROGRAM_DATE_TIME:
+			seg.ProgramDateTime = value
+		case tags.EXT_X_DATERANGE:
+			seg.DateRanges = append(seg.DateRanges, decodeDateRange(value))
+		case tags.EXT_X_GAP:
+			seg.Gap = true
+		case tags.EXT_X_BITRATE:
+			seg.Bitrate, _ = strconv.ParseInt(value, 10, 64)
+		default:
+			// 第一个分片之前的未识别标签视为头部标签
+			if len(playlist.Segments) == 0 && !hasContent {
+				playlist.Tags = append(playlist.Tags, line)
+				segmentTag = false
+			} else {
+				seg.Tags = append(seg.Tags, line)
+			}
+		}
+		if segmentTag {
+			pending = append(pending, line)
+			hasContent = true
+		}
+	}
+	if hasContent {
+		playlist.TrailingTags = pending
+	}
+	playlist.Parts = seg.Parts
+	return playlist
+}
+
+func decodeMasterPlaylist(lines []string) *MasterPlaylist {
+	playlist := &MasterPlaylist{}
+	var (
+		variant    *Variant
+		pending    []string
+		hasContent bool
+	)
+	for _, line := range lines {
+		name, value := splitTag(line)
+		switch name {
+		case "":
+			if variant != nil {
+				variant.URI = line
+				playlist.Variants = append(playlist.Variants, variant)
+				variant = nil
+			}
+		case tags.EXT_X_VERSION:
+			playlist.Version, _ = strconv.ParseInt(value, 10, 64)
+		case tags.EXT_IS_INDEPENDENT_SEGMENTS:
+			playlist.IndependentSegments = true
+		case tags.EXT_X_START:
+			playlist.Start = decodeStart(value)
+		case tags.EXT_X_STREAM_INF:
+			variant = decodeVariant(value)
+			variant.Tags, pending = pending, nil
+			hasContent = true
+		case tags.EXT_X_I_FRAME_STREAM_INF:
+			iframe := decodeVariant(value)
+			iframe.IFrame = true
+			iframe.Tags, pending = pending, nil
+			playlist.IFrameVariants = append(playlist.IFrameVariants, iframe)
+			hasContent = true
+		case tags.EXT_X_MEDIA:
+			rendition := decodeRendition(value)
+			rendition.Tags, pending = pending, nil
+			playlist.Renditions = append(playlist.Renditions, rendition)
+			hasContent = true
+		case tags.EXT_X_SESSION_DATA:
+			playlist.SessionData = append(playlist.SessionData, decodeSessionData(value))
+			hasContent = true
+		case tags.EXT_X_SESSION_KEY:
+			playlist.SessionKeys = append(playlist.SessionKeys, decodeKey(value))
+			hasContent = true
+		default:
+			// 第一个条目之前的未识别标签视为头部标签，其余的随后面的条目输出
+			if !hasContent {
+				playlist.Tags = append(playlist.Tags, line)
+			} else if variant != nil {
+				variant.Tags = append(variant.Tags, line)
+			} else {
+				pending = append(pending, line)
+			}
+		}
+	}
+	playlist.TrailingTags = pending
+	return playlist
+}
+
+func decodeStart(value string) *Start {
+	start := &Start{}
+	for _, attr := range tool.ParseTagAttributes(value) {
+		switch attr.Key {
+		case "TIME-OFFSET":
+			start.TimeOffset, _ = strconv.ParseFloat(attr.Value, 64)
+		case "PRECISE":
+			start.Precise = attr.Value == "YES"
+		default:
+			start.Other = append(start.Other, attr)
+		}
+	}
+	return start
+}
+
+func decodeByteRange(value string) *ByteRange {
+	byteRange := &ByteRange{Offset: -1}
+	t := strings.Split(strings.TrimSpace(value), "@")
+	byteRange.Length, _ = strconv.ParseInt(t[0], 10, 64)
+	if len(t) == 2 {
+		byteRange.Offset, _ = strconv.ParseInt(t[1], 10, 64)
+	}
+	return byteRange
+}
+
+func decodePart(value string) *Part {
+	part := &Part{}
+	for _, attr := range tool.ParseTagAttributes(value) {
+		switch attr.Key {
+		case "URI":
+			part.URI = attr.Value
+		case "DURATION":
+			part.Duration, _ = strconv.ParseFloat(attr.Value, 64)
+		case "INDEPENDENT":
+			part.Independent = attr.Value == "YES"
+		case "BYTERANGE":
+			part.ByteRange = decodeByteRange(attr.Value)
+		case "GAP":
+			part.Gap = attr.Value == "YES"
+		default:
+			part.Other = append(part.Other, attr)
+		}
+	}
+	return part
+}
+
+func decodePartInf(value string) *PartInf {
+	partInf := &PartInf{}
+	for _, attr := range tool.ParseTagAttributes(value) {
+		switch attr.Key {
+		case "PART-TARGET":
+			partInf.PartTarget, _ = strconv.ParseFloat(attr.Value, 64)
+		default:
+			partInf.Other = append(partInf.Other, attr)
+		}
+	}
+	return partInf
+}
+
+func decodeServerControl(value string) *ServerControl {
+	control := &ServerControl{}
+	for _, attr := range tool.ParseTagAttributes(value) {
+		switch attr.Key {
+		case "CAN-SKIP-UNTIL":
+			control.CanSkipUntil, _ = strconv.ParseFloat(attr.Value, 64)
+		case "CAN-SKIP-DATERANGES":
+			control.CanSkipDateRanges = attr.Value == "YES"
+		case "HOLD-BACK":
+			control.HoldBack, _ = strconv.ParseFloat(attr.Value, 64)
+		case "PART-HOLD-BACK":
+			control.PartHoldBack, _ = strconv.ParseFloat(attr.Value, 64)
+		case "CAN-BLOCK-RELOAD":
+			control.CanBlockReload = attr.Value == "YES"
+		default:
+			control.Other = append(control.Other, attr)
+		}
+	}
+	return control
+}
+
+func decodePreloadHint(value string) *PreloadHint {
+	hint := &PreloadHint{}
+	for _, attr := range tool.ParseTagAttributes(value) {
+		switch attr.Key {
+		case "TYPE":
+			hint.Type = attr.Value
+		case "URI":
+			hint.URI = attr.Value
+		case "BYTERANGE-START":
+			hint.ByteRangeStart, _ = strconv.ParseInt(attr.Value, 10, 64)
+		case "BYTERANGE-LENGTH":
+			hint.ByteRangeLength, _ = strconv.ParseInt(attr.Value, 10, 64)
+		default:
+			hint.Other = append(hint.Other, attr)
+		}
+	}
+	return hint
+}
+
+func decodeRenditionReport(value string) *RenditionReport {
+	report := &RenditionReport{LastPart: -1}
+	for _, attr := range tool.ParseTagAttributes(value) {
+		switch attr.Key {
+		case "URI":
+			report.URI = attr.Value
+		case "LAST-MSN":
+			report.LastMsn, _ = strconv.ParseInt(attr.Value, 10, 64)
+		case "LAST-PART":
+			report.LastPart, _ = strconv.ParseInt(attr.Value, 10, 64)
+		default:
+			report.Other = append(report.Other, attr)
+		}
+	}
+	return report
+}
+
+func decodeKey(value string) *Key {
+	key := &Key{}
+	for _, attr := range tool.ParseTagAttributes(value) {
+		switch attr.Key {
+		case "METHOD":
+			key.Method = attr.Value
+		case "URI":
+			key.URI = attr.Value
+		case "IV":
+			key.IV = attr.Value
+		case "KEYFORMAT":
+			key.KeyFormat = attr.Value
+		case "KEYFORMATVERSIONS":
+			key.KeyFormatVersions = attr.Value
+		default:
+			key.Other = append(key.Other, attr)
+		}
+	}
+	return key
+}
+
+func decodeMap(value string) *Map {
+	m := &Map{}
+	for _, attr := range tool.ParseTagAttributes(value) {
+		switch attr.Key {
+		case "URI":
+			m.URI = attr.Value
+		case "BYTERANGE":
+			m.ByteRange = decodeByteRange(attr.Value)
+		default:
+			m.Other = append(m.Other, attr)
+		}
+	}
+	return m
+}
+
+func decodeDateRange(value string) *DateRange {
+	dateRange := &DateRange{}
+	for _, attr := range tool.ParseTagAttributes(value) {
+		switch attr.Key {
+		case "ID":
+			dateRange.ID = attr.Value
+		case "CLASS":
+			dateRange.Class = attr.Value
+		case "START-DATE":
+			dateRange.StartDate = attr.Value
+		case "END-DATE":
+			dateRange.EndDate = attr.Value
+		case "DURATION":
+			dateRange.Duration, _ = strconv.ParseFloat(attr.Value, 64)
+		case "PLANNED-DURATION":
+			dateRange.PlannedDuration, _ = strconv.ParseFloat(attr.Value, 64)
+		case "SCTE35-CMD":
+			dateRange.SCTE35Cmd = attr.Value
+		case "SCTE35-OUT":
+			dateRange.SCTE35Out = attr.Value
+		case "SCTE35-IN":
+			dateRange.SCTE35In = attr.Value
+		case "END-ON-NEXT":
+			dateRange.EndOnNext = attr.Value == "YES"
+		default:
+			dateRange.Other = append(dateRange.Other, attr)
+		}
+	}
+	return dateRange
+}
+
+func decodeVariant(value string) *Variant {
+	variant := &Variant{}
+	for _, attr := range tool.ParseTagAttributes(value) {
+		switch attr.Key {
+		case "URI":
+			variant.URI = attr.Value
+		case "BANDWIDTH":
+			variant.Bandwidth, _ = strconv.ParseInt(attr.Value, 10, 64)
+		case "AVERAGE-BANDWIDTH":
+			variant.AverageBandwidth, _ = strconv.ParseInt(attr.Value, 10, 64)
+		case "CODECS":
+			variant.Codecs = attr.Value
+		case "RESOLUTION":
+			variant.Resolution = attr.Value
+		case "FRAME-RATE":
+			variant.FrameRate, _ = strconv.ParseFloat(attr.Value, 64)
+		case "HDCP-LEVEL":
+			variant.HDCPLevel = attr.Value
+		case "VIDEO-RANGE":
+			variant.VideoRange = attr.Value
+		case "AUDIO":
+			variant.Audio = attr.Value
+		case "VIDEO":
+			variant.Video = attr.Value
+		case "SUBTITLES":
+			variant.Subtitles = attr.Value
+		case "CLOSED-CAPTIONS":
+			variant.ClosedCaptions = attr.Value
+		default:
+			variant.Other = append(variant.Other, attr)
+		}
+	}
+	return variant
+}
+
+func decodeRendition(value string) *Rendition {
+	rendition := &Rendition{}
+	for _, attr := range tool.ParseTagAttributes(value) {
+		switch attr.Key {
+		case "TYPE":
+			rendition.Type = attr.Value
+		case "URI":
+			rendition.URI = attr.Value
+		case "GROUP-ID":
+			rendition.GroupID = attr.Value
+		case "LANGUAGE":
+			rendition.Language = attr.Value
+		case "ASSOC-LANGUAGE":
+			rendition.AssocLanguage = attr.Value
+		case "NAME":
+			rendition.Name = attr.Value
+		case "DEFAULT":
+			rendition.Default = attr.Value == "YES"
+		case "AUTOSELECT":
+			rendition.AutoSelect = attr.Value == "YES"
+		case "FORCED":
+			rendition.Forced = attr.Value == "YES"
+		case "INSTREAM-ID":
+			rendition.InstreamID = attr.Value
+		case "CHARACTERISTICS":
+			rendition.Characteristics = attr.Value
+		case "CHANNELS":
+			rendition.Channels = attr.Value
+		default:
+			rendition.Other = append(rendition.Other, attr)
+		}
+	}
+	return rendition
+}
+
+func decodeSessionData(value string) *SessionData {
+	data := &SessionData{}
+	for _, attr := range tool.ParseTagAttributes(value) {
+		switch attr.Key {
+		case "DATA-ID":
+			data.DataID = attr.Value
+		case "VALUE":
+			data.Value = attr.Value
+		case "URI":
+			data.URI = attr.Value
+		case "LANGUAGE":
+			data.Language = attr.Value
+		default:
+			data.Other = append(data.Other, attr)
+		}
+	}
+	return data
+}
@@ -0,0 +1,164 @@
+package parser
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --downloadRange 指定的时长范围(秒)，End为-1时到结尾
+type TimeRange struct {
+	Start float64
+	End   float64
+}
+
+var ErrInvalidTimeRange = errors.New("invalid time range")
+
+// meta.json中需要精确裁剪的时间段，位置相对于合并后的文件
+type clipObj struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// HH:MM:SS 格式的时长
+var clockReg = regexp.MustCompile(`^(\d+):(\d+):(\d+(?:\.\d+)?)$`)
+
+// 将 HH:MM:SS 转换为秒数，格式不正确时返回-1
+func parseClock(value string) float64 {
+	params := clockReg.FindStringSubmatch(strings.TrimSpace(value))
+	if params == nil {
+		return -1
+	}
+	hh, _ := strconv.ParseFloat(params[1], 64)
+	mm, _ := strconv.ParseFloat(params[2], 64)
+	ss, _ := strconv.ParseFloat(params[3], 64)
+	return hh*3600 + mm*60 + ss
+}
+
+// 解析以逗号分隔的 HH:MM:SS-HH:MM:SS，起止时间均可省略，结束时间为00:00:00时同样表示到结尾
+func ParseTimeRanges(value string) ([]TimeRange, error) {
+	ranges := []TimeRange{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		index := strings.Index(item, "-")
+		if index == -1 {
+			return nil, ErrInvalidTimeRange
+		}
+		start, end := strings.TrimSpace(item[:index]), strings.TrimSpace(item[index+1:])
+		if start == "" && end == "" {
+			return nil, ErrInvalidTimeRange
+		}
+		timeRange := TimeRange{Start: 0, End: -1}
+		if start != "" {
+			if timeRange.Start = parseClock(start); timeRange.Start < 0 {
+				return nil, ErrInvalidTimeRange
+			}
+		}
+		if end != "" {
+			if timeRange.End = parseClock(end); timeRange.End < 0 {
+				return nil, ErrInvalidTimeRange
+			}
+			if timeRange.End == 0 {
+				timeRange.End = -1
+			}
+		}
+		if timeRange.End != -1 && timeRange.End <= timeRange.Start {
+			return nil, ErrInvalidTimeRange
+		}
+		ranges = append(ranges, timeRange)
+	}
+	return ranges, nil
+}
+
+// 按开始时间排序并合并重叠的范围，开放的结束时间以+Inf表示
+func mergeTimeRanges(ranges []TimeRange) []TimeRange {
+	sorted := []TimeRange{}
+	for _, r := range ranges {
+		if r.End < 0 {
+			r.End = math.Inf(1)
+		}
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+	merged := []TimeRange{}
+	for _, r := range sorted {
+		if len(merged) > 0 && r.Start <= merged[len(merged)-1].End {
+			if r.End > merged[len(merged)-1].End {
+				merged[len(merged)-1].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// 保留与任一时长范围有交集的分片，并换算出各范围在合并后的文件中的位置
+func selectTimeRanges(parts [][]segInfoObj, ranges []TimeRange) ([][]segInfoObj, []clipObj) {
+	type keptSeg struct {
+		start    float64 // 在原列表中的开始时间
+		position float64 // 在合并后的文件中的开始时间
+		duration float64
+	}
+	ranges = mergeTimeRanges(ranges)
+	newParts := [][]segInfoObj{}
+	kept := []keptSeg{}
+	var start, position float64
+	for _, part := range parts {
+		newPart := []segInfoObj{}
+		for _, seg := range part {
+			end := start + seg.Duration
+			for _, r := range ranges {
+				if end > r.Start && start < r.End {
+					newPart = append(newPart, seg)
+					kept = append(kept, keptSeg{start: start, position: position, duration: seg.Duration})
+					position += seg.Duration
+					break
+				}
+			}
+			start = end
+		}
+		if len(newPart) != 0 {
+			newParts = append(newParts, newPart)
+		}
+	}
+	// 原列表中的时间点对应到合并后的文件中
+	locate := func(t float64) (float64, bool) {
+		for _, seg := range kept {
+			if t >= seg.start && t <= seg.start+seg.duration {
+				return seg.position + t - seg.start, true
+			}
+		}
+		return 0, false
+	}
+	clips := []clipObj{}
+	for _, r := range ranges {
+		clipStart, ok := locate(r.Start)
+		if !ok {
+			continue
+		}
+		clipEnd, ok := locate(math.Min(r.End, start))
+		if ok && clipEnd > clipStart {
+			clips = append(clips, clipObj{Start: clipStart, End: clipEnd})
+		}
+	}
+	return newParts, clips
+}
+
+// 统计分片数量与总时长
+func countSegments(parts [][]segInfoObj) (int64, float64) {
+	var count int64
+	var duration float64
+	for _, part := range parts {
+		for _, seg := range part {
+			count++
+			duration += seg.Duration
+		}
+	}
+	return count, duration
+}
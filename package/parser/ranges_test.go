@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseTimeRanges(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		want  []TimeRange
+	}{
+		{"00:00:10-00:00:20", []TimeRange{{10, 20}}},
+		{"00:01:00-", []TimeRange{{60, -1}}},
+		{"-00:00:30", []TimeRange{{0, 30}}},
+		{"00:00:30-00:00:00", []TimeRange{{30, -1}}},
+		{"01:00:00-01:30:00, 00:00:05.5-00:00:08", []TimeRange{{3600, 5400}, {5.5, 8}}},
+	} {
+		got, err := ParseTimeRanges(c.value)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseTimeRanges(%q) = %v, %v, want %v", c.value, got, err, c.want)
+		}
+	}
+	for _, value := range []string{"-", "00:00:20-00:00:10", "00:00:10", "1-2", "00:00:10-00:00:20,"} {
+		if _, err := ParseTimeRanges(value); err != ErrInvalidTimeRange {
+			t.Errorf("ParseTimeRanges(%q) error = %v, want ErrInvalidTimeRange", value, err)
+		}
+	}
+}
+
+func TestMergeTimeRanges(t *testing.T) {
+	inf := math.Inf(1)
+	for _, c := range []struct {
+		name   string
+		ranges []TimeRange
+		want   []TimeRange
+	}{
+		{"disjoint sorted", []TimeRange{{20, 30}, {0, 10}}, []TimeRange{{0, 10}, {20, 30}}},
+		{"overlapping", []TimeRange{{0, 20}, {10, 30}}, []TimeRange{{0, 30}}},
+		{"contained", []TimeRange{{0, 30}, {10, 20}}, []TimeRange{{0, 30}}},
+		{"adjacent", []TimeRange{{0, 10}, {10, 20}}, []TimeRange{{0, 20}}},
+		{"open-ended absorbs later", []TimeRange{{50, 60}, {30, -1}}, []TimeRange{{30, inf}}},
+		{"open-ended after", []TimeRange{{0, 10}, {30, -1}}, []TimeRange{{0, 10}, {30, inf}}},
+	} {
+		if got := mergeTimeRanges(c.ranges); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSelectTimeRanges(t *testing.T) {
+	// 6个10秒的分片，分为两个分部
+	parts := [][]segInfoObj{
+		{{Index: 0, Duration: 10}, {Index: 1, Duration: 10}, {Index: 2, Duration: 10}},
+		{{Index: 3, Duration: 10}, {Index: 4, Duration: 10}, {Index: 5, Duration: 10}},
+	}
+	for _, c := range []struct {
+		name    string
+		ranges  []TimeRange
+		indexes []int64
+		clips   []clipObj
+	}{
+		{"single", []TimeRange{{15, 35}}, []int64{1, 2, 3}, []clipObj{{5, 25}}},
+		{"overlapping", []TimeRange{{20, 35}, {15, 25}}, []int64{1, 2, 3}, []clipObj{{5, 25}}},
+		{"open-ended", []TimeRange{{45, -1}}, []int64{4, 5}, []clipObj{{5, 20}}},
+		{"disjoint", []TimeRange{{45, -1}, {0, 5}}, []int64{0, 4, 5}, []clipObj{{0, 5}, {15, 30}}},
+		{"segment aligned", []TimeRange{{10, 30}}, []int64{1, 2}, []clipObj{{0, 20}}},
+		{"past the end", []TimeRange{{120, -1}}, []int64{}, []clipObj{}},
+	} {
+		newParts, clips := selectTimeRanges(parts, c.ranges)
+		indexes := []int64{}
+		for _, part := range newParts {
+			for _, seg := range part {
+				indexes = append(indexes, seg.Index)
+			}
+		}
+		if !reflect.DeepEqual(indexes, c.indexes) || !reflect.DeepEqual(clips, c.clips) {
+			t.Errorf("%s: got %v %v, want %v %v", c.name, indexes, clips, c.indexes, c.clips)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoVariantMatch   = errors.New("no variant matches the selection")
+	ErrNoRenditionMatch = errors.New("no rendition matches the selection")
+	ErrInvalidSelector  = errors.New("invalid selection expression")
+)
+
+// 各选择表达式可用的字段
+var (
+	variantFields   = []string{"res", "codec", "fps", "range", "hdcp", "bw"}
+	renditionFields = []string{"lang", "name", "channels", "default", "autoselect", "forced", "char"}
+)
+
+// 比较运算符，按长度排列以便优先匹配双字符运算符
+var selectOperators = []string{">=", "<=", "!=", "!~", ">", "<", "=", "~"}
+
+// 单条筛选规则，如 res>=1080
+type selectRule struct {
+	Field    string
+	Operator string
+	Value    string
+}
+
+// 清晰度选择表达式，规则之间为"与"关系，筛选后按 best|worst|index:N 取出一个
+type VariantSelector struct {
+	Rules []selectRule
+	Pick  string
+	Index int
+}
+
+// 解析 --selectVideo 表达式，如 "res>=1080,codec~hvc1,fps<=30,range=PQ" 或 "best|worst|index:N"
+func ParseVariantSelector(expr string) (*VariantSelector, error) {
+	selector := &VariantSelector{Pick: "best"}
+	for _, item := range strings.Split(expr, ",") {
+		item = strings.TrimSpace(item)
+		lower := strings.ToLower(item)
+		switch {
+		case item == "":
+			continue
+		case lower == "best" || lower == "worst":
+			selector.Pick = lower
+			continue
+		case strings.HasPrefix(lower, "index:"):
+			index, err := strconv.Atoi(strings.TrimSpace(item[6:]))
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidSelector, item)
+			}
+			selector.Pick = "index"
+			selector.Index = index
+			continue
+		}
+		rule, err := parseSelectRule(item, variantFields)
+		if err != nil {
+			return nil, err
+		}
+		selector.Rules = append(selector.Rules, rule)
+	}
+	return selector, nil
+}
+
+func parseSelectRule(item string, fields []string) (selectRule, error) {
+	for _, op := range selectOperators {
+		if index := strings.Index(item, op); index > 0 {
+			rule := selectRule{
+				Field:    strings.ToLower(strings.TrimSpace(item[:index])),
+				Operator: op,
+				Value:    strings.TrimSpace(item[index+len(op):]),
+			}
+			for _, field := range fields {
+				if rule.Field == field {
+					return rule, nil
+				}
+			}
+			break
+		}
+	}
+	return selectRule{}, fmt.Errorf("%w: %s", ErrInvalidSelector, item)
+}
+
+// 从候选清晰度中选出一个，未满足任何候选时返回错误
+func (s *VariantSelector) Select(variants []*Variant) (*Variant, error) {
+	matched := []*Variant{}
+	for _, variant := range variants {
+		if s.Match(variant) {
+			matched = append(matched, variant)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, ErrNoVariantMatch
+	}
+	switch s.Pick {
+	case "index":
+		if s.Index >= len(matched) {
+			return nil, ErrNoVariantMatch
+		}
+		return matched[s.Index], nil
+	case "worst":
+		worst := matched[0]
+		for _, variant := range matched[1:] {
+			if variant.Bandwidth < worst.Bandwidth {
+				worst = variant
+			}
+		}
+		return worst, nil
+	}
+	// 带宽相同时取靠后的条目，与原有行为一致
+	best := matched[0]
+	for _, variant := range matched[1:] {
+		if variant.Bandwidth >= best.Bandwidth {
+			best = variant
+		}
+	}
+	return best, nil
+}
+
+// 判断清晰度是否满足全部规则
+func (s *VariantSelector) Match(variant *Variant) bool {
+	for _, rule := range s.Rules {
+		if !rule.matchVariant(variant) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r selectRule) matchVariant(variant *Variant) bool {
+	switch r.Field {
+	case "res":
+		if variant.Resolution == "" {
+			return false
+		}
+		width, height := parseResolution(variant.Resolution)
+		value := strings.TrimSuffix(strings.ToLower(r.Value), "p")
+		if strings.Contains(value, "x") {
+			w, h := parseResolution(value)
+			return compareNumber(float64(width*height), r.Operator, float64(w*h))
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		return compareNumber(float64(height), r.Operator, number)
+	case "fps":
+		number, err := strconv.ParseFloat(r.Value, 64)
+		if err != nil || variant.FrameRate == 0 {
+			return false
+		}
+		return compareNumber(variant.FrameRate, r.Operator, number)
+	case "bw":
+		number, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(r.Value), "k"), 64)
+		if err != nil {
+			return false
+		}
+		if strings.HasSuffix(strings.ToLower(r.Value), "k") {
+			number *= 1000
+		}
+		return compareNumber(float64(variant.Bandwidth), r.Operator, number)
+	case "codec":
+		return compareText(variant.Codecs, r.Operator, r.Value)
+	case "range":
+		// 未声明VIDEO-RANGE时视为SDR
+		videoRange := variant.VideoRange
+		if videoRange == "" {
+			videoRange = "SDR"
+		}
+		return compareText(videoRange, r.Operator, r.Value)
+	case "hdcp":
+		hdcp := variant.HDCPLevel
+		if hdcp == "" {
+			hdcp = "NONE"
+		}
+		return compareText(hdcp, r.Operator, r.Value)
+	}
+	return false
+}
+
+// 音轨/字幕选择表达式，多组规则以 ; 分隔，结果取并集
+// 每组规则默认选出一个(优先DEFAULT=YES)，加上 all 时选出全部符合的条目
+type RenditionSelector struct {
+	Groups []renditionGroup
+}
+
+type renditionGroup struct {
+	Rules []selectRule
+	All   bool
+}
+
+// 解析 --selectAudio / --selectSubtitle 表达式，如 "lang=ja;lang=en,name~dub" 或 "all"
+func ParseRenditionSelector(expr string) (*RenditionSelector, error) {
+	selector := &RenditionSelector{}
+	for _, alternative := range strings.Split(expr, ";") {
+		group := renditionGroup{}
+		for _, item := range strings.Split(alternative, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			if strings.ToLower(item) == "all" {
+				group.All = true
+				continue
+			}
+			rule, err := parseSelectRule(item, renditionFields)
+			if err != nil {
+				return nil, err
+			}
+			group.Rules = append(group.Rules, rule)
+		}
+		if group.All || len(group.Rules) > 0 {
+			selector.Groups = append(selector.Groups, group)
+		}
+	}
+	if len(selector.Groups) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSelector, expr)
+	}
+	return selector, nil
+}
+
+// 按规则选出音轨或字幕，未满足的规则组记录在返回的unmatched中
+func (s *RenditionSelector) Select(renditions []*Rendition) ([]*Rendition, []int, error) {
+	selected := []*Rendition{}
+	unmatched := []int{}
+	picked := map[*Rendition]bool{}
+	for i, group := range s.Groups {
+		matched := []*Rendition{}
+		for _, rendition := range renditions {
+			if group.match(rendition) {
+				matched = append(matched, rendition)
+			}
+		}
+		if len(matched) == 0 {
+			unmatched = append(unmatched, i)
+			continue
+		}
+		if !group.All {
+			matched = []*Rendition{preferredRendition(matched)}
+		}
+		for _, rendition := range matched {
+			if !picked[rendition] {
+				picked[rendition] = true
+				selected = append(selected, rendition)
+			}
+		}
+	}
+	if len(selected) == 0 {
+		return nil, unmatched, ErrNoRenditionMatch
+	}
+	return selected, unmatched, nil
+}
+
+// 优先选择DEFAULT=YES的条目，其次AUTOSELECT=YES，否则取第一个
+func preferredRendition(renditions []*Rendition) *Rendition {
+	for _, rendition := range renditions {
+		if rendition.Default {
+			return rendition
+		}
+	}
+	for _, rendition := range renditions {
+		if rendition.AutoSelect {
+			return rendition
+		}
+	}
+	return renditions[0]
+}
+
+func (g renditionGroup) match(rendition *Rendition) bool {
+	for _, rule := range g.Rules {
+		if !rule.matchRendition(rendition) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r selectRule) matchRendition(rendition *Rendition) bool {
+	switch r.Field {
+	case "lang":
+		// lang=en 同时匹配 en-US 等细分语言
+		language := strings.ToLower(rendition.Language)
+		value := strings.ToLower(r.Value)
+		if r.Operator == "=" || r.Operator == "!=" {
+			equal := language == value || strings.HasPrefix(language, value+"-")
+			return equal == (r.Operator == "=")
+		}
+		return compareText(language, r.Operator, value)
+	case "name":
+		return compareText(rendition.Name, r.Operator, r.Value)
+	case "channels":
+		channels, err := strconv.ParseFloat(strings.Split(rendition.Channels, "/")[0], 64)
+		number, err2 := strconv.ParseFloat(r.Value, 64)
+		if err != nil || err2 != nil {
+			return false
+		}
+		return compareNumber(channels, r.Operator, number)
+	case "default":
+		return compareText(yesNo(rendition.Default), r.Operator, normalizeYesNo(r.Value))
+	case "autoselect":
+		return compareText(yesNo(rendition.AutoSelect), r.Operator, normalizeYesNo(r.Value))
+	case "forced":
+		return compareText(yesNo(rendition.Forced), r.Operator, normalizeYesNo(r.Value))
+	case "char":
+		return compareText(rendition.Characteristics, r.Operator, r.Value)
+	}
+	return false
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "YES"
+	}
+	return "NO"
+}
+
+func normalizeYesNo(value string) string {
+	switch strings.ToLower(value) {
+	case "yes", "true", "1":
+		return "YES"
+	}
+	return "NO"
+}
+
+func parseResolution(resolution string) (int, int) {
+	t := strings.Split(strings.ToLower(resolution), "x")
+	if len(t) != 2 {
+		return 0, 0
+	}
+	width, _ := strconv.Atoi(strings.TrimSpace(t[0]))
+	height, _ := strconv.Atoi(strings.TrimSpace(t[1]))
+	return width, height
+}
+
+func compareNumber(a float64, op string, b float64) bool {
+	switch op {
+	case ">=":
+		return a >= b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case "<":
+		return a < b
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	}
+	return false
+}
+
+// 文本比较不区分大小写，~ 为包含
+func compareText(a string, op string, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "~":
+		return strings.Contains(a, b)
+	case "!~":
+		return !strings.Contains(a, b)
+	}
+	return false
+}
+
+// 清晰度描述，用于日志输出
+func describeVariant(variant *Variant) string {
+	items := []string{}
+	if variant.Resolution != "" {
+		items = append(items, variant.Resolution)
+	}
+	if variant.Bandwidth > 0 {
+		items = append(items, fmt.Sprintf("%d Kbps", variant.Bandwidth/1000))
+	}
+	if variant.Codecs != "" {
+		items = append(items, variant.Codecs)
+	}
+	if variant.FrameRate > 0 {
+		items = append(items, formatFloat(variant.FrameRate)+"fps")
+	}
+	if variant.VideoRange != "" {
+		items = append(items, variant.VideoRange)
+	}
+	return "[" + strings.Join(items, "] [") + "]"
+}
+
+// 音轨或字幕描述，用于日志输出
+func describeRendition(rendition *Rendition) string {
+	items := []string{rendition.Name, rendition.Language}
+	if rendition.Channels != "" {
+		items = append(items, rendition.Channels+"ch")
+	}
+	return strings.ReplaceAll("["+strings.Join(items, "] [")+"]", "[]", "")
+}
@@ -0,0 +1,118 @@
+package parser
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoProgramDateTime = errors.New("playlist has no EXT-X-PROGRAM-DATE-TIME")
+	ErrEmptyDateRange    = errors.New("no segment in the date range")
+)
+
+// meta.json中分片开始时间的格式
+const dateTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// #EXT-X-PROGRAM-DATE-TIME 常见的几种ISO 8601写法
+var dateTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999Z07",
+}
+
+// 不带时区的时间按本地时间处理
+var localDateTimeLayouts = []string{
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+}
+
+const dateTimePattern = `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}(?::?\d{2})?)?`
+
+// 2026-10-18T20:00:00Z-2026-10-18T21:30:00Z，起止时间均可省略
+var dateRangeReg = regexp.MustCompile(`^(` + dateTimePattern + `)?-(` + dateTimePattern + `)?$`)
+
+func parseDateTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	var err error
+	for _, layout := range dateTimeLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range localDateTimeLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// 解析 --downloadRange 中的绝对时间范围，不是时间范围时返回false
+func ParseDateRange(value string) (time.Time, time.Time, bool) {
+	params := dateRangeReg.FindStringSubmatch(strings.TrimSpace(value))
+	if params == nil || (params[1] == "" && params[2] == "") {
+		return time.Time{}, time.Time{}, false
+	}
+	var start, end time.Time
+	var err error
+	if params[1] != "" {
+		if start, err = parseDateTime(params[1]); err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+	}
+	if params[2] != "" {
+		if end, err = parseDateTime(params[2]); err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+	}
+	return start, end, true
+}
+
+// 按分片的开始时间保留与 [dateStart, dateEnd) 有交集的分片，没有开始时间的分片无法定位，一并移除。
+// 同时返回首尾分片中超出范围部分去除后在输出中的时间段
+func filterByDate(parts [][]segInfoObj, dateStart time.Time, dateEnd time.Time) ([][]segInfoObj, clipObj, error) {
+	hasDate := false
+	newParts := [][]segInfoObj{}
+	clip := clipObj{}
+	var position float64
+	for _, part := range parts {
+		newPart := []segInfoObj{}
+		for _, seg := range part {
+			if seg.ProgramDateTime == "" {
+				continue
+			}
+			hasDate = true
+			start, err := time.Parse(dateTimeLayout, seg.ProgramDateTime)
+			if err != nil {
+				continue
+			}
+			end := start.Add(time.Duration(seg.Duration * float64(time.Second)))
+			if !dateStart.IsZero() && !end.After(dateStart) {
+				continue
+			}
+			if !dateEnd.IsZero() && !start.Before(dateEnd) {
+				continue
+			}
+			if position == 0 && !dateStart.IsZero() && start.Before(dateStart) {
+				clip.Start = dateStart.Sub(start).Seconds()
+			}
+			position += seg.Duration
+			clip.End = position
+			if !dateEnd.IsZero() && end.After(dateEnd) {
+				clip.End -= end.Sub(dateEnd).Seconds()
+			}
+			newPart = append(newPart, seg)
+		}
+		if len(newPart) != 0 {
+			newParts = append(newParts, newPart)
+		}
+	}
+	if !hasDate {
+		return nil, clip, ErrNoProgramDateTime
+	}
+	return newParts, clip, nil
+}
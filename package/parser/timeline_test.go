@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDateRange(t *testing.T) {
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = time.FixedZone("UTC+8", 8*3600)
+	utc := func(hour, min, sec int) time.Time {
+		return time.Date(2026, 10, 18, hour, min, sec, 0, time.UTC)
+	}
+	for _, c := range []struct {
+		value      string
+		start, end time.Time
+	}{
+		{"2026-10-18T20:00:05Z-2026-10-18T21:00:00Z", utc(20, 0, 5), utc(21, 0, 0)},
+		{"2026-10-18T20:00:00+08:00-2026-10-18T21:00:00+0800", utc(12, 0, 0), utc(13, 0, 0)},
+		{"2026-10-18T20:00:00-2026-10-18 21:00:00", utc(12, 0, 0), utc(13, 0, 0)}, // 不带时区按本地时间
+		{"2026-10-18T20:00:00.500Z-", utc(20, 0, 0).Add(500 * time.Millisecond), time.Time{}},
+		{"-2026-10-18T20:00:00-05:00", time.Time{}, time.Date(2026, 10, 19, 1, 0, 0, 0, time.UTC)},
+	} {
+		start, end, ok := ParseDateRange(c.value)
+		if !ok || !start.Equal(c.start) || !end.Equal(c.end) {
+			t.Errorf("ParseDateRange(%q) = %v, %v, %v, want %v, %v", c.value, start, end, ok, c.start, c.end)
+		}
+	}
+	for _, value := range []string{"00:01:00-00:02:00", "-", "12-34", "2026-10-18-2026-10-19"} {
+		if _, _, ok := ParseDateRange(value); ok {
+			t.Errorf("ParseDateRange(%q) accepted", value)
+		}
+	}
+}
+
+func TestFilterByDate(t *testing.T) {
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = time.FixedZone("UTC+8", 8*3600)
+	// 4个10秒的分片，分片时间为UTC，范围使用本地时间
+	parts := [][]segInfoObj{{
+		{Index: 0, Duration: 10, ProgramDateTime: "2026-10-18T12:00:00.000Z"},
+		{Index: 1, Duration: 10, ProgramDateTime: "2026-10-18T12:00:10.000Z"},
+		{Index: 2, Duration: 10, ProgramDateTime: "2026-10-18T12:00:20.000Z"},
+		{Index: 3, Duration: 10, ProgramDateTime: "2026-10-18T12:00:30.000Z"},
+	}}
+	for _, c := range []struct {
+		value   string
+		indexes []int64
+		clip    clipObj
+	}{
+		{"2026-10-18T20:00:05-2026-10-18T20:00:25", []int64{0, 1, 2}, clipObj{5, 25}},
+		{"2026-10-18T12:00:05Z-2026-10-18T12:00:25Z", []int64{0, 1, 2}, clipObj{5, 25}},
+		{"2026-10-18T20:00:20-", []int64{2, 3}, clipObj{0, 20}},
+		{"-2026-10-18T12:00:10Z", []int64{0}, clipObj{0, 10}},
+	} {
+		start, end, ok := ParseDateRange(c.value)
+		if !ok {
+			t.Fatalf("ParseDateRange(%q) failed", c.value)
+		}
+		newParts, clip, err := filterByDate(parts, start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		indexes := []int64{}
+		for _, part := range newParts {
+			for _, seg := range part {
+				indexes = append(indexes, seg.Index)
+			}
+		}
+		if !reflect.DeepEqual(indexes, c.indexes) || clip != c.clip {
+			t.Errorf("%s: got %v %v, want %v %v", c.value, indexes, clip, c.indexes, c.clip)
+		}
+	}
+	if _, _, err := filterByDate([][]segInfoObj{{{Duration: 10}}}, time.Time{}, time.Now()); err != ErrNoProgramDateTime {
+		t.Errorf("error = %v, want ErrNoProgramDateTime", err)
+	}
+}
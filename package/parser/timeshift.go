@@ -0,0 +1,118 @@
+package parser
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 回看地址中常见的开始、结束时间参数名，比较时不区分大小写
+var (
+	playbackStartParams = []string{"starttime", "start_time", "begintime", "begin_time", "playbackbegin"}
+	playbackEndParams   = []string{"endtime", "end_time", "playbackend"}
+)
+
+// -00:30:00 表示从当前时间之前30分钟开始
+var startOffsetReg = regexp.MustCompile(`^-(\d+):([0-5]?\d):([0-5]?\d)$`)
+
+// 解析 --liveStartFrom，可以是相对当前时间的 -HH:MM:SS 或绝对时间
+func ParseStartFrom(value string, now time.Time) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if params := startOffsetReg.FindStringSubmatch(value); params != nil {
+		hh, _ := strconv.Atoi(params[1])
+		mm, _ := strconv.Atoi(params[2])
+		ss, _ := strconv.Atoi(params[3])
+		return now.Add(-time.Duration(ss+mm*60+hh*3600) * time.Second), true
+	}
+	start, err := parseDateTime(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return start, true
+}
+
+// 查找地址中的时间参数，返回参数的原始名称与值
+func playbackParam(query url.Values, names []string) (string, string, bool) {
+	for key, values := range query {
+		for _, name := range names {
+			if strings.EqualFold(key, name) && len(values) > 0 && values[0] != "" {
+				return key, values[0], true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// 地址带有已经过去的结束时间参数时为已结束的回看，按点播处理。
+// 无法解析为时间的同名参数(如签名中的过期时间格式不符)或结束时间未到时仍按列表本身判断
+func isPlaybackUrl(uri string, now time.Time) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	_, value, ok := playbackParam(u.Query(), playbackEndParams)
+	if !ok {
+		return false
+	}
+	end, ok := parsePlaybackTime(value)
+	return ok && !end.After(now)
+}
+
+// 将回看地址的开始时间参数改为start，保持参数原有的时间格式，地址没有开始时间参数时返回false
+func SetPlaybackStart(uri string, start time.Time) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri, false
+	}
+	query := u.Query()
+	key, value, ok := playbackParam(query, playbackStartParams)
+	if !ok {
+		return uri, false
+	}
+	query.Set(key, formatPlaybackTime(value, start))
+	u.RawQuery = query.Encode()
+	return u.String(), true
+}
+
+// 解析回看地址中的时间参数，支持的格式与formatPlaybackTime相同
+func parsePlaybackTime(value string) (time.Time, bool) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		switch len(value) {
+		case 10:
+			return time.Unix(n, 0), true
+		case 13:
+			return time.Unix(0, n*int64(time.Millisecond)), true
+		case 14:
+			t, err := time.ParseInLocation("20060102150405", value, time.Local)
+			return t, err == nil
+		}
+		return time.Time{}, false
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04:05", value, time.Local)
+	return t, err == nil
+}
+
+// 按原参数值的格式输出时间：10位秒级时间戳、13位毫秒级时间戳、14位本地时间yyyyMMddHHmmss或带时区的时间
+func formatPlaybackTime(old string, t time.Time) string {
+	if _, err := strconv.ParseInt(old, 10, 64); err == nil {
+		switch len(old) {
+		case 13:
+			return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+		case 14:
+			return t.Local().Format("20060102150405")
+		}
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+	if _, err := time.Parse(time.RFC3339, old); err == nil {
+		return t.Format(time.RFC3339)
+	}
+	if _, err := time.ParseInLocation("2006-01-02T15:04:05", old, time.Local); err == nil {
+		return t.Local().Format("2006-01-02T15:04:05")
+	}
+	return strconv.FormatInt(t.Unix(), 10)
+}
@@ -0,0 +1,157 @@
+package probe
+
+import (
+	"github.com/xfy520/m3u8_cli/package/mp4"
+)
+
+// 按位读取，用于解析指数哥伦布编码
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) bit() uint {
+	if r.pos >= len(r.data)*8 {
+		r.pos++
+		return 0
+	}
+	b := (r.data[r.pos/8] >> (7 - uint(r.pos%8))) & 1
+	r.pos++
+	return uint(b)
+}
+
+func (r *bitReader) bits(n int) uint {
+	v := uint(0)
+	for i := 0; i < n; i++ {
+		v = v<<1 | r.bit()
+	}
+	return v
+}
+
+func (r *bitReader) ue() uint {
+	zeros := 0
+	for r.bit() == 0 && zeros < 32 {
+		zeros++
+	}
+	return (1<<uint(zeros) - 1) + r.bits(zeros)
+}
+
+func (r *bitReader) se() int {
+	v := r.ue()
+	if v&1 == 1 {
+		return int(v+1) / 2
+	}
+	return -int(v / 2)
+}
+
+func (r *bitReader) overrun() bool {
+	return r.pos > len(r.data)*8
+}
+
+// 从H.264基本流中第一个SPS读取分辨率
+func h264Resolution(es []byte) (int, int) {
+	for _, nal := range mp4.SplitNALUnits(es) {
+		if len(nal) > 4 && nal[0]&0x1f == 7 {
+			return spsResolution(removeEmulationPrevention(nal[1:]))
+		}
+	}
+	return 0, 0
+}
+
+func spsResolution(sps []byte) (int, int) {
+	r := &bitReader{data: sps}
+	profile := r.bits(8)
+	r.bits(16) // constraint_set_flags, level_idc
+	r.ue()     // seq_parameter_set_id
+	chromaFormat := uint(1)
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = r.ue()
+		if chromaFormat == 3 {
+			r.bit() // separate_colour_plane_flag
+		}
+		r.ue()  // bit_depth_luma_minus8
+		r.ue()  // bit_depth_chroma_minus8
+		r.bit() // qpprime_y_zero_transform_bypass_flag
+		if r.bit() == 1 {
+			count := 8
+			if chromaFormat == 3 {
+				count = 12
+			}
+			for i := 0; i < count; i++ {
+				if r.bit() == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := 8, 8
+				for j := 0; j < size && next != 0; j++ {
+					next = (last + r.se() + 256) % 256
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+	r.ue() // log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.bit()
+		r.se()
+		r.se()
+		for i := r.ue(); i > 0 && !r.overrun(); i-- {
+			r.se()
+		}
+	}
+	r.ue()  // max_num_ref_frames
+	r.bit() // gaps_in_frame_num_value_allowed_flag
+	widthMbs := int(r.ue()) + 1
+	heightMapUnits := int(r.ue()) + 1
+	frameMbsOnly := int(r.bit())
+	if frameMbsOnly == 0 {
+		r.bit() // mb_adaptive_frame_field_flag
+	}
+	r.bit() // direct_8x8_inference_flag
+	width := widthMbs * 16
+	height := (2 - frameMbsOnly) * heightMapUnits * 16
+	if r.bit() == 1 {
+		left, right, top, bottom := int(r.ue()), int(r.ue()), int(r.ue()), int(r.ue())
+		cropX, cropY := 1, 2-frameMbsOnly
+		if chromaFormat == 1 || chromaFormat == 2 {
+			cropX = 2
+		}
+		if chromaFormat == 1 {
+			cropY *= 2
+		}
+		width -= (left + right) * cropX
+		height -= (top + bottom) * cropY
+	}
+	if r.overrun() || width <= 0 || height <= 0 {
+		return 0, 0
+	}
+	return width, height
+}
+
+// 去除防竞争字节 00 00 03
+func removeEmulationPrevention(nal []byte) []byte {
+	out := make([]byte, 0, len(nal))
+	zeros := 0
+	for _, b := range nal {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
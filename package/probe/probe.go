@@ -0,0 +1,210 @@
+package probe
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xfy520/m3u8_cli/package/mp4"
+)
+
+var ErrUnknownFormat = errors.New("unknown media format")
+
+const tsPacketSize = 188
+
+// TS中的流类型，SAMPLE-AES加密的类型按明文类型处理
+var tsStreamCodecs = map[byte]string{
+	0x01: "mpeg1video",
+	0x02: "mpeg2video",
+	0x03: "mp3",
+	0x04: "mp3",
+	0x0f: "aac",
+	0x11: "aac_latm",
+	0x1b: "h264",
+	0x24: "hevc",
+	0x81: "ac3",
+	0x87: "eac3",
+	0xdb: "h264",
+	0xcf: "aac",
+	0xc1: "ac3",
+	0xc2: "eac3",
+}
+
+// mp4样本描述与TS流类型统一后的编码名称
+var mp4Codecs = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"dvh1": "hevc",
+	"dvhe": "hevc",
+	"mp4a": "aac",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+}
+
+// 分片的媒体信息
+type MediaInfo struct {
+	Format    string // ts 或 fmp4
+	Codecs    []string
+	Width     int
+	Height    int
+	Timescale uint32
+}
+
+// 用于比较的特征字符串
+func (m *MediaInfo) String() string {
+	return fmt.Sprintf("%s %s %dx%d %d", m.Format, strings.Join(m.Codecs, ","), m.Width, m.Height, m.Timescale)
+}
+
+// 读取分片的编码、分辨率与时间刻度，fMP4分片本身不含moov时使用init
+func Probe(data []byte, init []byte) (*MediaInfo, error) {
+	if len(data) >= tsPacketSize && data[0] == 0x47 {
+		return probeTS(data), nil
+	}
+	if _, ok := mp4.Find(data, "moov"); ok {
+		return probeMP4(data), nil
+	}
+	if _, ok := mp4.Find(init, "moov"); ok {
+		return probeMP4(init), nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+func probeMP4(data []byte) *MediaInfo {
+	info := &MediaInfo{Format: "fmp4"}
+	for _, track := range mp4.ReadTracks(data) {
+		codec := track.Codec
+		if name, ok := mp4Codecs[codec]; ok {
+			codec = name
+		}
+		info.Codecs = append(info.Codecs, codec)
+		if track.Type == "video" && info.Width == 0 {
+			info.Width, info.Height = track.Width, track.Height
+			info.Timescale = track.Timescale
+		}
+		if info.Timescale == 0 {
+			info.Timescale = track.Timescale
+		}
+	}
+	sort.Strings(info.Codecs)
+	return info
+}
+
+// 读取PAT/PMT得到各流的编码，并从视频流的SPS中读取分辨率
+func probeTS(data []byte) *MediaInfo {
+	info := &MediaInfo{Format: "ts", Timescale: 90000}
+	pmtPid := -1
+	var streams map[uint16]byte
+	videoPid := -1
+	es := []byte{}
+	for pos := 0; pos+tsPacketSize <= len(data); pos += tsPacketSize {
+		packet := data[pos : pos+tsPacketSize]
+		if packet[0] != 0x47 {
+			break
+		}
+		pid := int(packet[1]&0x1f)<<8 | int(packet[2])
+		pusi := packet[1]&0x40 != 0
+		payload := tsPayload(packet)
+		switch {
+		case pid == 0 && pusi && pmtPid < 0:
+			pmtPid = readPAT(payload)
+		case pid == pmtPid && pusi && streams == nil:
+			streams = readPMT(payload)
+			for streamPid, streamType := range streams {
+				if codec := tsStreamCodecs[streamType]; codec == "h264" || codec == "hevc" {
+					videoPid = int(streamPid)
+				}
+			}
+		case pid == videoPid && len(es) < 256*1024:
+			if pusi {
+				payload = pesPayload(payload)
+			}
+			es = append(es, payload...)
+		}
+		if streams != nil && (videoPid < 0 || len(es) >= 256*1024) {
+			break
+		}
+	}
+	for _, streamType := range streams {
+		codec, ok := tsStreamCodecs[streamType]
+		if !ok {
+			codec = fmt.Sprintf("0x%02x", streamType)
+		}
+		info.Codecs = append(info.Codecs, codec)
+	}
+	sort.Strings(info.Codecs)
+	if videoPid >= 0 && tsStreamCodecs[streams[uint16(videoPid)]] == "h264" {
+		info.Width, info.Height = h264Resolution(es)
+	}
+	return info
+}
+
+func tsPayload(packet []byte) []byte {
+	afc := (packet[3] >> 4) & 0x03
+	pos := 4
+	if afc == 2 {
+		return nil
+	}
+	if afc == 3 {
+		pos += 1 + int(packet[4])
+	}
+	if pos >= tsPacketSize {
+		return nil
+	}
+	return packet[pos:]
+}
+
+// 去除PES头
+func pesPayload(payload []byte) []byte {
+	if len(payload) < 9 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return payload
+	}
+	pos := 9 + int(payload[8])
+	if pos > len(payload) {
+		return nil
+	}
+	return payload[pos:]
+}
+
+func readPAT(payload []byte) int {
+	if len(payload) < 1 || 1+int(payload[0]) > len(payload) {
+		return -1
+	}
+	section := payload[1+int(payload[0]):]
+	if len(section) < 8 {
+		return -1
+	}
+	length := int(section[1]&0x0f)<<8 | int(section[2])
+	for i := 8; i+4 <= 3+length-4 && i+4 <= len(section); i += 4 {
+		program := int(section[i])<<8 | int(section[i+1])
+		if program != 0 {
+			return int(section[i+2]&0x1f)<<8 | int(section[i+3])
+		}
+	}
+	return -1
+}
+
+func readPMT(payload []byte) map[uint16]byte {
+	streams := map[uint16]byte{}
+	if len(payload) < 1 || 1+int(payload[0]) > len(payload) {
+		return streams
+	}
+	section := payload[1+int(payload[0]):]
+	if len(section) < 12 {
+		return streams
+	}
+	length := int(section[1]&0x0f)<<8 | int(section[2])
+	end := 3 + length - 4
+	if end > len(section) {
+		end = len(section)
+	}
+	pos := 12 + (int(section[10]&0x0f)<<8 | int(section[11]))
+	for pos+5 <= end {
+		pid := uint16(section[pos+1]&0x1f)<<8 | uint16(section[pos+2])
+		streams[pid] = section[pos]
+		pos += 5 + (int(section[pos+3]&0x0f)<<8 | int(section[pos+4]))
+	}
+	return streams
+}
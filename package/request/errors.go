@@ -0,0 +1,27 @@
+package request
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/xfy520/m3u8_cli/package/lang"
+)
+
+var ErrRedirectCount = errors.New(lang.Lang.RedirectCountError)
+
+// 请求了字节范围，服务器却返回了完整内容或其他范围
+var ErrRangeIgnored = errors.New("server did not honor the range request")
+
+// HTTP状态码错误
+type HTTPStatusError struct {
+	Url        string
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	if e.Status != "" {
+		return e.Url + ": " + e.Status
+	}
+	return e.Url + ": " + strconv.Itoa(e.StatusCode)
+}
@@ -4,6 +4,8 @@ import (
 	"compress/gzip"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
@@ -29,9 +31,11 @@ var (
 
 type Request interface {
 	Send(redirectCount int) ([]byte, error)
+	SendStream(redirectCount int) (io.ReadCloser, error)
+	SendRangeStream(redirectCount int, startByte int64, expectByte int64) (io.ReadCloser, error)
 	Set(key string, value string)
 	InitHeader()
-	SetHeaders(headers string)
+	SetHeaders(headers string) error
 	Get302() (string, error)
 }
 
@@ -87,44 +91,52 @@ func Exists(path string) bool {
 	return true
 }
 
-func getHeaderStr(headers string) string {
+func getHeaderStr(headers string) (string, error) {
 	_, filename, _, ok := runtime.Caller(1)
 	if !ok {
-		return headers
+		return headers, nil
 	}
 	if tool.Exists(headers) && tool.IsFile(headers) {
 		headersByte, err := tool.ReadFile(headers)
-		tool.Check(err)
-		return string(headersByte)
+		if err != nil {
+			return "", err
+		}
+		return string(headersByte), nil
 	}
-	headers = path.Join(filename, headers)
-	if tool.Exists(headers) && tool.IsFile(headers) {
-		headersByte, err := tool.ReadFile(headers)
-		tool.Check(err)
-		return string(headersByte)
+	headersPath := path.Join(filename, headers)
+	if tool.Exists(headersPath) && tool.IsFile(headersPath) {
+		headersByte, err := tool.ReadFile(headersPath)
+		if err != nil {
+			return "", err
+		}
+		return string(headersByte), nil
 	}
-	return headers
+	return headers, nil
 }
 
-func getHeaderMap(headers string) map[string]interface{} {
-	headersBytes := tool.StrToBytes(getHeaderStr(headers))
+func getHeaderMap(headers string) (map[string]interface{}, error) {
+	headerStr, err := getHeaderStr(headers)
+	if err != nil {
+		return nil, err
+	}
+	headersBytes := tool.StrToBytes(headerStr)
 	if json.Valid(headersBytes) {
 		jsonMap := make(map[string]interface{})
 		err := json.Unmarshal(headersBytes, &jsonMap)
 		if err != nil {
-			return make(map[string]interface{})
+			return nil, err
 		}
-		return jsonMap
+		return jsonMap, nil
 	} else {
-		headersArray := strings.Split(headers, "|")
+		headersArray := strings.Split(headerStr, "|")
 		jsonMap := make(map[string]interface{})
 		for _, value := range headersArray {
-			values := strings.SplitN(value, ":", 1)
+			values := strings.SplitN(value, ":", 2)
 			if len(values) == 2 {
-				jsonMap[values[0]] = values[1]
+				jsonMap[strings.TrimSpace(values[0])] = strings.TrimSpace(values[1])
 			}
 		}
-		return jsonMap
+		return jsonMap, nil
 	}
 }
 
@@ -141,13 +153,17 @@ func (r *request) InitHeader() {
 	r.req.Header.Set("user-agent", userAgent)
 }
 
-func (r *request) SetHeaders(headers string) {
+func (r *request) SetHeaders(headers string) error {
 	if headers != "" {
-		jsonMap := getHeaderMap(headers)
+		jsonMap, err := getHeaderMap(headers)
+		if err != nil {
+			return err
+		}
 		for k, v := range jsonMap {
 			r.req.Header.Set(k, Strval(v))
 		}
 	}
+	return nil
 }
 
 func New(uri string, method string, timeOut time.Duration, banRedirect bool) (Request, error) {
@@ -188,8 +204,8 @@ func New(uri string, method string, timeOut time.Duration, banRedirect bool) (Re
 }
 
 func (r *request) Send(redirectCount int) ([]byte, error) {
-	if redirectCount == 0 && redirectCount != -1 {
-		return nil, errors.New("")
+	if redirectCount == 0 {
+		return nil, ErrRedirectCount
 	}
 	redirectCount -= 1
 	res, err := r.client.Do(r.req)
@@ -205,6 +221,9 @@ func (r *request) Send(redirectCount int) ([]byte, error) {
 		r.req.URL = loc
 		return r.Send(redirectCount)
 	}
+	if res.StatusCode >= 400 {
+		return nil, &HTTPStatusError{Url: r.req.URL.String(), StatusCode: res.StatusCode, Status: res.Status}
+	}
 	body := res.Body
 	if res.Header.Get("Content-Encoding") == "gzip" {
 		body, err = gzip.NewReader(res.Body)
@@ -223,10 +242,93 @@ func (r *request) Send(redirectCount int) ([]byte, error) {
 	return b, nil
 }
 
+// 响应体，关闭时同时关闭原始连接
+type streamBody struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (b *streamBody) Close() error {
+	return b.closer.Close()
+}
+
+// 以流的方式读取响应体，由调用方负责关闭
+func (r *request) SendStream(redirectCount int) (io.ReadCloser, error) {
+	return r.sendStream(redirectCount, 0, 0)
+}
+
+// 以流的方式读取指定字节范围，服务器必须返回206且Content-Range从startByte开始，响应体最多读取expectByte字节
+func (r *request) SendRangeStream(redirectCount int, startByte int64, expectByte int64) (io.ReadCloser, error) {
+	r.Set("range", "bytes="+strconv.FormatInt(startByte, 10)+"-"+strconv.FormatInt(startByte+expectByte-1, 10))
+	return r.sendStream(redirectCount, startByte, expectByte)
+}
+
+func (r *request) sendStream(redirectCount int, startByte int64, expectByte int64) (io.ReadCloser, error) {
+	if redirectCount == 0 {
+		return nil, ErrRedirectCount
+	}
+	redirectCount -= 1
+	res, err := r.client.Do(r.req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == 301 || res.StatusCode == 302 || res.StatusCode == 307 {
+		res.Body.Close()
+		loc, err := res.Location()
+		if err != nil {
+			return nil, err
+		}
+		r.req.URL = loc
+		return r.sendStream(redirectCount, startByte, expectByte)
+	}
+	if res.StatusCode >= 400 {
+		res.Body.Close()
+		return nil, &HTTPStatusError{Url: r.req.URL.String(), StatusCode: res.StatusCode, Status: res.Status}
+	}
+	var raw io.Reader = res.Body
+	if expectByte > 0 {
+		if res.StatusCode != http.StatusPartialContent || contentRangeStart(res.Header.Get("Content-Range")) != startByte {
+			res.Body.Close()
+			return nil, fmt.Errorf("%w: %s %s %s", ErrRangeIgnored, r.req.URL.String(), res.Status, res.Header.Get("Content-Range"))
+		}
+		raw = io.LimitReader(res.Body, expectByte)
+	}
+	switch res.Header.Get("Content-Encoding") {
+	case "gzip":
+		body, err := gzip.NewReader(raw)
+		if err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+		return &streamBody{Reader: body, closer: res.Body}, nil
+	case "br":
+		return &streamBody{Reader: brotli.NewReader(raw), closer: res.Body}, nil
+	}
+	return &streamBody{Reader: raw, closer: res.Body}, nil
+}
+
+// Content-Range: bytes 100-199/1000 中的起始位置，无法解析时返回-1
+func contentRangeStart(value string) int64 {
+	value = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(value), "bytes"))
+	index := strings.Index(value, "-")
+	if index <= 0 {
+		return -1
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(value[:index]), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
+
 func (r *request) Get302() (string, error) {
 	res, err := r.client.Do(r.req)
 	if err != nil {
 		return "", err
 	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		return "", &HTTPStatusError{Url: r.req.URL.String(), StatusCode: res.StatusCode, Status: res.Status}
+	}
 	return res.Request.URL.String(), nil
 }
@@ -0,0 +1,354 @@
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xfy520/m3u8_cli/package/lang"
+	"github.com/xfy520/m3u8_cli/package/live"
+	"github.com/xfy520/m3u8_cli/package/log"
+	"github.com/xfy520/m3u8_cli/package/tool"
+)
+
+var ErrInvalidEntry = errors.New("invalid schedule entry")
+
+// 各计划的状态
+const (
+	statePending   = "pending"
+	stateRecording = "recording"
+	stateDone      = "done"
+	stateFailed    = "failed"
+	stateMissed    = "missed" // 结束时间已过仍未开始
+)
+
+// 检查计划与重新读取计划文件的间隔
+const (
+	tickInterval   = time.Second
+	reloadInterval = 30 * time.Second
+)
+
+// 计划文件中的一条录制计划，频道选项与频道列表文件相同
+type Entry struct {
+	live.Channel
+	Start    string `json:"start"`              // 2026-10-18T20:00:00+08:00 或本地时间 2026-10-18 20:00:00
+	End      string `json:"end,omitempty"`      // 与Duration二选一
+	Duration string `json:"duration,omitempty"` // HH:MM:SS
+	start    time.Time
+	end      time.Time
+}
+
+// 计划的唯一标识，用于保存状态
+func (e *Entry) key() string {
+	return e.Name + "@" + e.start.Format(time.RFC3339)
+}
+
+// 计划的执行状态，保存在计划文件旁的 .state.json 中，重启后据此继续
+type entryState struct {
+	State    string `json:"state"`
+	Started  string `json:"started,omitempty"`
+	Finished string `json:"finished,omitempty"`
+	MergedTo string `json:"mergedTo,omitempty"` // 与同一频道正在进行的录制重叠时并入该录制
+	Error    string `json:"error,omitempty"`
+}
+
+// 录制器，由调用方按计划创建
+type Recorder interface {
+	Record() error
+	Stop()
+}
+
+// 一次正在进行的录制，同一频道重叠的计划共用
+type run struct {
+	entries  []*Entry
+	recorder Recorder
+	end      time.Time
+	stopped  bool
+}
+
+type runResult struct {
+	run *run
+	err error
+}
+
+// 常驻运行，按计划开始录制并在时间段结束时停止，计划文件的变化会定期重新读取
+type scheduler struct {
+	File string
+	// 创建录制器，name为以实际开始时间命名的保存名
+	NewRecorder func(entry *Entry, name string) Recorder
+	entries     map[string]*Entry
+	states      map[string]*entryState
+	runs        map[string]*run // 以频道名为键
+	done        chan runResult
+	loadedAt    time.Time
+}
+
+func NewScheduler() *scheduler {
+	return &scheduler{
+		entries: map[string]*Entry{},
+		states:  map[string]*entryState{},
+		runs:    map[string]*run{},
+		done:    make(chan runResult),
+	}
+}
+
+// 运行直到stop关闭，关闭时停止全部录制并等待其结束
+func (s *scheduler) Run(stop <-chan struct{}) error {
+	if err := s.loadState(); err != nil {
+		return err
+	}
+	if err := s.reload(); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		s.check(time.Now())
+		select {
+		case <-stop:
+			// 正在录制的计划保持录制状态，重启后继续
+			for _, r := range s.runs {
+				r.recorder.Stop()
+			}
+			for len(s.runs) > 0 {
+				result := <-s.done
+				delete(s.runs, result.run.entries[0].Name)
+			}
+			return nil
+		case result := <-s.done:
+			s.finish(result)
+		case <-ticker.C:
+			if time.Since(s.loadedAt) >= reloadInterval {
+				if err := s.reload(); err != nil {
+					log.Warn(lang.Lang.InvalidSchedule + err.Error())
+				}
+			}
+		}
+	}
+}
+
+// 开始到时的计划，停止已到结束时间的录制
+func (s *scheduler) check(now time.Time) {
+	for _, entry := range s.sortedEntries() {
+		state := s.states[entry.key()]
+		if state.State != statePending && state.State != stateRecording {
+			continue
+		}
+		if !now.Before(entry.end) && !s.running(entry) {
+			s.setState(entry, stateMissed, "")
+			continue
+		}
+		if now.Before(entry.start) {
+			continue
+		}
+		if state.State == stateRecording && s.running(entry) {
+			continue
+		}
+		s.start(entry, now)
+	}
+	for _, r := range s.runs {
+		if !r.stopped && !now.Before(r.end) {
+			r.stopped = true
+			r.recorder.Stop()
+		}
+	}
+}
+
+func (s *scheduler) running(entry *Entry) bool {
+	r, ok := s.runs[entry.Name]
+	if !ok {
+		return false
+	}
+	for _, e := range r.entries {
+		if e == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// 开始录制，同一频道已在录制时延长其结束时间
+func (s *scheduler) start(entry *Entry, now time.Time) {
+	if r, ok := s.runs[entry.Name]; ok && !r.stopped {
+		r.entries = append(r.entries, entry)
+		if entry.end.After(r.end) {
+			r.end = entry.end
+		}
+		s.states[entry.key()].MergedTo = r.entries[0].key()
+		s.setState(entry, stateRecording, "")
+		log.Info(fmt.Sprintf(lang.Lang.ScheduleMerged, entry.key(), r.entries[0].key()))
+		log.WriteInfo(fmt.Sprintf(lang.Lang.ScheduleMerged, entry.key(), r.entries[0].key()))
+		return
+	}
+	if _, ok := s.runs[entry.Name]; ok {
+		// 上一段录制正在结束，等其结束后再开始
+		return
+	}
+	name := live.ChunkName(entry.Name, now)
+	r := &run{entries: []*Entry{entry}, recorder: s.NewRecorder(entry, name), end: entry.end}
+	s.runs[entry.Name] = r
+	s.states[entry.key()].Started = now.Format(time.RFC3339)
+	s.setState(entry, stateRecording, "")
+	log.Info(fmt.Sprintf(lang.Lang.ScheduleStarted, entry.key(), entry.end.Format(time.RFC3339)))
+	log.WriteInfo(fmt.Sprintf(lang.Lang.ScheduleStarted, entry.key(), entry.end.Format(time.RFC3339)))
+	go func() {
+		s.done <- runResult{run: r, err: r.recorder.Record()}
+	}()
+}
+
+// 录制结束后更新其包含的全部计划的状态，直播提前结束时不再重新开始
+func (s *scheduler) finish(result runResult) {
+	delete(s.runs, result.run.entries[0].Name)
+	state, message := stateDone, ""
+	if result.err != nil {
+		state, message = stateFailed, result.err.Error()
+		log.Error(result.err.Error())
+		log.WriteError(result.err.Error())
+	}
+	for _, entry := range result.run.entries {
+		s.states[entry.key()].Finished = time.Now().Format(time.RFC3339)
+		s.setState(entry, state, message)
+	}
+}
+
+func (s *scheduler) setState(entry *Entry, state string, message string) {
+	s.states[entry.key()].State = state
+	s.states[entry.key()].Error = message
+	if err := s.saveState(); err != nil {
+		log.WriteError(err.Error())
+	}
+}
+
+// 按开始时间排序的计划
+func (s *scheduler) sortedEntries() []*Entry {
+	entries := make([]*Entry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].start.Before(entries[j].start)
+	})
+	return entries
+}
+
+// 重新读取计划文件，新增的计划加入等待，已删除且未开始的计划不再执行
+func (s *scheduler) reload() error {
+	s.loadedAt = time.Now()
+	entries, err := LoadEntries(s.File)
+	if err != nil {
+		return err
+	}
+	current := map[string]*Entry{}
+	for _, entry := range entries {
+		key := entry.key()
+		if old, ok := s.entries[key]; ok {
+			// 正在录制的计划保留原对象
+			current[key] = old
+			continue
+		}
+		current[key] = entry
+		if _, ok := s.states[key]; !ok {
+			s.states[key] = &entryState{State: statePending}
+		}
+	}
+	for key, entry := range s.entries {
+		if _, ok := current[key]; !ok && s.running(entry) {
+			current[key] = entry
+		}
+	}
+	s.entries = current
+	return nil
+}
+
+// 状态文件与计划文件位于同一目录
+func (s *scheduler) stateFile() string {
+	return strings.TrimSuffix(s.File, path.Ext(s.File)) + ".state.json"
+}
+
+func (s *scheduler) loadState() error {
+	if !tool.Exists(s.stateFile()) {
+		return nil
+	}
+	byts, err := tool.ReadFile(s.stateFile())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(byts, &s.states)
+}
+
+func (s *scheduler) saveState() error {
+	byts, err := json.Marshal(s.states)
+	if err != nil {
+		return err
+	}
+	return tool.WriteFile(s.stateFile(), tool.BytesToStr(byts))
+}
+
+// 读取JSON格式的计划文件
+func LoadEntries(file string) ([]*Entry, error) {
+	byts, err := tool.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	entries := []*Entry{}
+	if err := json.Unmarshal(byts, &entries); err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		entry.Name = tool.GetFileName(strings.TrimSpace(entry.Name))
+		if entry.Name == "" || entry.Url == "" {
+			return nil, fmt.Errorf("%w: %d", ErrInvalidEntry, i)
+		}
+		if entry.start, err = parseTime(entry.Start); err != nil {
+			return nil, fmt.Errorf("%w: %s %s", ErrInvalidEntry, entry.Name, entry.Start)
+		}
+		switch {
+		case entry.End != "":
+			if entry.end, err = parseTime(entry.End); err != nil {
+				return nil, fmt.Errorf("%w: %s %s", ErrInvalidEntry, entry.Name, entry.End)
+			}
+		case entry.Duration != "":
+			duration, ok := parseDuration(entry.Duration)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s %s", ErrInvalidEntry, entry.Name, entry.Duration)
+			}
+			entry.end = entry.start.Add(duration)
+		}
+		if !entry.end.After(entry.start) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidEntry, entry.Name)
+		}
+	}
+	return entries, nil
+}
+
+// 带时区的时间按RFC3339解析，不带时区的按本地时间
+func parseTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04:05", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+}
+
+var durationReg = regexp.MustCompile(`^(\d+):([0-5]?\d):([0-5]?\d)$`)
+
+// 解析HH:MM:SS格式的时长
+func parseDuration(value string) (time.Duration, bool) {
+	params := durationReg.FindStringSubmatch(strings.TrimSpace(value))
+	if params == nil {
+		return 0, false
+	}
+	hh, _ := strconv.Atoi(params[1])
+	mm, _ := strconv.Atoi(params[2])
+	ss, _ := strconv.Atoi(params[3])
+	return time.Duration(ss+mm*60+hh*3600) * time.Second, true
+}
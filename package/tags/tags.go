@@ -27,4 +27,15 @@ const (
 	EXT_X_CUE_SPAN               = "#EXT-X-CUE-SPAN"
 	EXT_X_MAP                    = "#EXT-X-MAP"
 	EXT_X_START                  = "#EXT-X-START"
+	EXT_X_DATERANGE              = "#EXT-X-DATERANGE"
+	EXT_X_GAP                    = "#EXT-X-GAP"
+	EXT_X_BITRATE                = "#EXT-X-BITRATE"
+	EXT_X_SESSION_DATA           = "#EXT-X-SESSION-DATA"
+	EXT_X_SESSION_KEY            = "#EXT-X-SESSION-KEY"
+	EXT_X_PART                   = "#EXT-X-PART"
+	EXT_X_PART_INF               = "#EXT-X-PART-INF"
+	EXT_X_PRELOAD_HINT           = "#EXT-X-PRELOAD-HINT"
+	EXT_X_SERVER_CONTROL         = "#EXT-X-SERVER-CONTROL"
+	EXT_X_RENDITION_REPORT       = "#EXT-X-RENDITION-REPORT"
+	EXT_X_DEFINE                 = "#EXT-X-DEFINE"
 )
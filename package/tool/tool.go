@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -50,7 +51,7 @@ func Pause() {
 	fmt.Printf("\033[1;32;40m%s\033[0m", lang.Lang.AnyKey)
 	b := make([]byte, 1)
 	os.Stdin.Read(b)
-	Exit()
+	Exit(1)
 }
 
 // 错误判断
@@ -62,11 +63,11 @@ func Check(err error) {
 }
 
 // 退出处理
-func Exit() {
+func Exit(code int) {
 	log.Info("开始退出...")
 	log.Info("执行清理...")
 	log.Info("结束退出...")
-	os.Exit(0)
+	os.Exit(code)
 }
 
 // 字符串判断三目
@@ -294,6 +295,31 @@ func WriteFile(file_path string, text string) error {
 	return errors.New(lang.Lang.FilePathError + file_path)
 }
 
+// file:协议地址转本地路径
+func UrlToPath(u *url.URL) string {
+	uri := u.Path
+	if uri == "" {
+		uri = u.Opaque
+	}
+	if runtime.GOOS == "windows" && strings.HasPrefix(uri, "/") && strings.Contains(uri, ":") {
+		uri = uri[1:]
+	}
+	return uri
+}
+
+// 本地路径转file:协议地址
+func PathToUrl(file string) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	abs = filepath.ToSlash(abs)
+	if !strings.HasPrefix(abs, "/") {
+		abs = "/" + abs
+	}
+	return (&url.URL{Scheme: "file", Path: abs}).String(), nil
+}
+
 // 字符串转字节数组
 func StrToBytes(s string) []byte {
 	x := (*[2]uintptr)(unsafe.Pointer(&s))
@@ -318,19 +344,62 @@ func GetTimeStamp(bflag bool) int64 {
 	}
 }
 
-func GetTagAttribute(attributeList string, key string) string {
-	if attributeList != "" {
-		tmp := strings.Trim(attributeList, " ")
-		if strings.Contains(tmp, key+"=") {
-			if tmp[strings.Index(tmp, key+"=")+len(key)+1] == '"' {
-				return tmp[strings.Index(tmp, key+"=")+len(key)+2 : strings.Index(tmp[0:strings.Index(tmp, key+"=")+len(key)+2], `"`)]
+// 标签属性
+type Attribute struct {
+	Key    string
+	Value  string
+	Quoted bool
+}
+
+// 解析标签属性列表，支持带引号的值中出现逗号
+func ParseTagAttributes(attributeList string) []Attribute {
+	attrs := []Attribute{}
+	tmp := strings.TrimSpace(attributeList)
+	if strings.HasPrefix(tmp, "#") {
+		index := strings.Index(tmp, ":")
+		if index == -1 {
+			return attrs
+		}
+		tmp = tmp[index+1:]
+	}
+	for len(tmp) > 0 {
+		tmp = strings.TrimLeft(tmp, ", ")
+		index := strings.Index(tmp, "=")
+		if index == -1 {
+			break
+		}
+		attr := Attribute{Key: strings.TrimSpace(tmp[:index])}
+		tmp = tmp[index+1:]
+		if strings.HasPrefix(tmp, `"`) {
+			end := strings.Index(tmp[1:], `"`)
+			if end == -1 {
+				end = len(tmp) - 1
+			}
+			attr.Value = tmp[1 : end+1]
+			attr.Quoted = true
+			if end+2 < len(tmp) {
+				tmp = tmp[end+2:]
 			} else {
-				if strings.Contains(tmp[0:strings.Index(tmp, key+"=")+len(key)+2], ",") {
-					return tmp[strings.Index(tmp, key+"=")+len(key)+1 : strings.Index(tmp[0:strings.Index(tmp, key+"=")+len(key)+1], ",")]
-				} else {
-					return tmp[strings.Index(tmp, key+"=")+len(key)+1:]
-				}
+				tmp = ""
+			}
+		} else {
+			end := strings.Index(tmp, ",")
+			if end == -1 {
+				end = len(tmp)
 			}
+			attr.Value = strings.TrimSpace(tmp[:end])
+			tmp = tmp[end:]
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs
+}
+
+// 获取标签属性值
+func GetTagAttribute(attributeList string, key string) string {
+	for _, attr := range ParseTagAttributes(attributeList) {
+		if attr.Key == key {
+			return attr.Value
 		}
 	}
 	return ""